@@ -5,6 +5,20 @@ import { devLog, expectAuth } from 'src/utils/utils'
 import { useUserStore } from './user-store'
 import { walk } from 'vue/compiler-sfc'
 
+// Follows next_cursor through every page of a paginated listing and returns all items
+const fetchAllPages = async (query, params = {}) => {
+	const items = []
+	let cursor = null
+	do {
+		const pageParams = cursor ? { ...params, cursor } : params
+		devLog(`GET: ${query}`, pageParams)
+		const response = await api.get(query, { params: pageParams })
+		items.push(...(response.data?.items || []))
+		cursor = response.data?.next_cursor || null
+	} while (cursor)
+	return items
+}
+
 export const useApiStore = defineStore('api', {
 	state: () => ({
 		loading: {
@@ -37,7 +51,7 @@ export const useApiStore = defineStore('api', {
 				devLog(`GET: ${query}`, params)
 				const response = await api.get(query, { params })
 				devLog('Fetch Packages Response:', response.data)
-				return response.data || []
+				return response.data
 			} catch (error) {
 				this.handleError(error, 'Failed to fetch packages')
 			} finally {
@@ -53,7 +67,7 @@ export const useApiStore = defineStore('api', {
 				devLog(`GET: ${endpoint}`, searchParams)
 				const response = await api.get(endpoint, { params: searchParams })
 				devLog('Search Packages Response:', response.data)
-				return response.data || []
+				return response.data
 			} catch (error) {
 				this.handleError(error, 'Search failed')
 			} finally {
@@ -144,7 +158,7 @@ export const useApiStore = defineStore('api', {
 				devLog(`GET: ${query}`, params)
 				const response = await api.get(query, { params })
 				devLog('Fetch Tags Response:', response.data)
-				return response.data || []
+				return response.data?.items || []
 			} catch (error) {
 				this.handleError(error, 'Failed to fetch tags')
 			} finally {
@@ -289,11 +303,9 @@ export const useApiStore = defineStore('api', {
 
 		async fetchUserPackages() {
 			try {
-				const query = '/users/me/packages'
-				devLog(`GET: ${query}`)
-				const response = await api.get(query)
-				devLog('Fetch User Packages Response:', response.data)
-				return response.data || []
+				const items = await fetchAllPages('/users/me/packages', { limit: 100 })
+				devLog('Fetch User Packages Response:', items)
+				return items
 			} catch (error) {
 				this.handleError(error, 'Failed to fetch your packages')
 			}
@@ -410,11 +422,9 @@ export const useApiStore = defineStore('api', {
 			if (!expectAuth()) return
 
 			try {
-				const query = `/flags/all?status=${status}`
-				devLog(`GET: ${query}`)
-				const response = await api.get(query)
-				devLog('Fetch All Flags Response:', response.data)
-				return response.data || []
+				const items = await fetchAllPages('/flags/all', { status, limit: 200 })
+				devLog('Fetch All Flags Response:', items)
+				return items
 			} catch (error) {
 				this.handleError(error, 'Failed to fetch flags')
 			}
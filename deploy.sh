@@ -17,7 +17,7 @@ git pull origin master
 # Build the server
 echo "🔨 Building Go server..."
 cd server
-go build -o opm-server main.go
+go build -o opm-server .
 
 # Restart the service
 echo "🔄 Restarting OPM service..."
@@ -0,0 +1,260 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"opm/models"
+)
+
+// DefaultBaseURL is the public registry API
+const DefaultBaseURL = "https://api.pkg-odin.org"
+
+// Client talks to the registry REST API
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	UserAgent  string
+}
+
+// APIError is returned when the registry answers with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("registry returned %d: %s", e.StatusCode, e.Message)
+}
+
+// PackagePage is one page of a package listing or search
+type PackagePage struct {
+	Items      []models.Package `json:"items"`
+	NextCursor *string          `json:"next_cursor"` // Empty on the last page
+	Total      int              `json:"total"`
+}
+
+// New creates a client for the registry at baseURL
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		UserAgent:  "opm-cli",
+	}
+}
+
+// Search performs a full-text search on packages and returns the first page of at most
+// limit results, or of the server's default page size when limit is 0
+func (c *Client) Search(ctx context.Context, query string, limit int) (*PackagePage, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+
+	var page PackagePage
+	if err := c.get(ctx, "/packages/search?"+params.Encode(), &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetPackage fetches a single package by author slug and package slug
+func (c *Client) GetPackage(ctx context.Context, author, pkg string) (*models.Package, error) {
+	var p models.Package
+	if err := c.get(ctx, "/packages/"+url.PathEscape(author)+"/"+url.PathEscape(pkg), &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListVersions returns the published versions of a package, newest first
+func (c *Client) ListVersions(ctx context.Context, author, pkg string) ([]models.PackageVersion, error) {
+	var versions []models.PackageVersion
+	path := "/packages/" + url.PathEscape(author) + "/" + url.PathEscape(pkg) + "/versions"
+	if err := c.get(ctx, path, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// Resolve pins requirements of the form author/pkg[@ref] to exact commits. Requirements
+// that can't be resolved are listed in the result's Errors.
+func (c *Client) Resolve(ctx context.Context, requirements []string) (*models.ResolveResult, error) {
+	var result models.ResolveResult
+	if err := c.post(ctx, "/resolve", models.ResolveInput{Requirements: requirements}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// VerifyLockfile re-resolves every package of a lockfile. The result's Verified is false
+// when a package no longer resolves to its locked commit, and Errors says which.
+func (c *Client) VerifyLockfile(ctx context.Context, lockfile *models.Lockfile) (*models.ResolveResult, error) {
+	var result models.ResolveResult
+	if err := c.post(ctx, "/resolve", models.ResolveInput{Lockfile: lockfile}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Archive describes a downloaded source archive
+type Archive struct {
+	CommitSHA string
+	SHA256    string
+	Size      int64
+}
+
+// ErrArchiveMismatch is returned when a downloaded archive doesn't hash to the SHA-256
+// the registry announced for it
+var ErrArchiveMismatch = errors.New("archive does not match its SHA-256")
+
+// ErrArchivePending is returned when the registry is still building an archive after
+// archiveAttempts requests
+var ErrArchivePending = errors.New("registry is still building the archive")
+
+const (
+	// archiveAttempts is how often DownloadArchive asks for an archive being built
+	archiveAttempts = 10
+	// defaultArchiveRetry is the wait between those requests without a Retry-After
+	defaultArchiveRetry = 5 * time.Second
+	maxArchiveRetry     = time.Minute
+)
+
+// DownloadArchive writes the tar.gz source archive of a package at ref, or at its latest
+// version when ref is empty, to w and checks it against the SHA-256 the registry sent.
+// Archives the registry has yet to build are waited for. On ErrArchiveMismatch, whatever
+// was written to w must be discarded.
+func (c *Client) DownloadArchive(ctx context.Context, author, pkg, ref string, w io.Writer) (*Archive, error) {
+	path := "/packages/" + url.PathEscape(author) + "/" + url.PathEscape(pkg) + "/archive"
+	if ref != "" {
+		path += "?ref=" + url.QueryEscape(ref)
+	}
+
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		var err error
+		resp, err = c.send(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusAccepted {
+			break
+		}
+		resp.Body.Close()
+		if attempt == archiveAttempts {
+			return nil, fmt.Errorf("%w of %s/%s", ErrArchivePending, author, pkg)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryAfter(resp)):
+		}
+	}
+	defer resp.Body.Close()
+
+	archive := &Archive{
+		CommitSHA: resp.Header.Get("X-Commit-SHA"),
+		SHA256:    strings.ToLower(resp.Header.Get("X-Content-SHA256")),
+	}
+	if archive.SHA256 == "" {
+		return nil, fmt.Errorf("registry sent the archive of %s/%s without its SHA-256", author, pkg)
+	}
+
+	hash := sha256.New()
+	var err error
+	archive.Size, err = io.Copy(io.MultiWriter(w, hash), resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download archive of %s/%s: %w", author, pkg, err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != archive.SHA256 {
+		return nil, fmt.Errorf("%w: got %s, want %s", ErrArchiveMismatch, sum, archive.SHA256)
+	}
+	return archive, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	resp, err := c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decode(resp, path, out)
+}
+
+func (c *Client) post(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	resp, err := c.send(ctx, http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decode(resp, path, out)
+}
+
+// send makes a request and returns its response, or an APIError for a non-2xx status
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return resp, nil
+}
+
+func decode(resp *http.Response, path string, out interface{}) error {
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response from %s: %w", path, err)
+	}
+	return nil
+}
+
+// IsNotFound reports whether err is a 404 from the registry
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// retryAfter reads the wait a response asks for in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return defaultArchiveRetry
+	}
+	if wait := time.Duration(seconds) * time.Second; wait < maxArchiveRetry {
+		return wait
+	}
+	return maxArchiveRetry
+}
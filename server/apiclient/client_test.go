@@ -0,0 +1,220 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"opm/models"
+)
+
+// newTestClient returns a client of a stand-in registry serving handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return New(srv.URL + "/")
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	t.Helper()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/packages/search" || r.URL.Query().Get("q") != "ray lib" || r.URL.Query().Get("limit") != "2" {
+			t.Errorf("request = %s, want a search for ray lib limited to 2", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items": [{"slug": "raylib"}, {"slug": "raygui"}], "next_cursor": "abc", "total": 3}`))
+	})
+
+	page, err := client.Search(context.Background(), "ray lib", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 || page.Items[0].Slug != "raylib" || page.Total != 3 || page.NextCursor == nil || *page.NextCursor != "abc" {
+		t.Errorf("page = %+v, want raylib and raygui of 3 with a next cursor", page)
+	}
+}
+
+func TestGetPackageAndVersions(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/packages/ada/raylib":
+			writeJSON(t, w, models.Package{Slug: "raylib", DisplayName: "Raylib"})
+		case "/packages/ada/raylib/versions":
+			writeJSON(t, w, []models.PackageVersion{{Version: "1.1.0"}, {Version: "1.0.0", Yanked: true}})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	ctx := context.Background()
+
+	p, err := client.GetPackage(ctx, "ada", "raylib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DisplayName != "Raylib" {
+		t.Errorf("package = %+v, want Raylib", p)
+	}
+
+	versions, err := client.ListVersions(ctx, "ada", "raylib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != "1.1.0" || !versions[1].Yanked {
+		t.Errorf("versions = %+v, want 1.1.0 and a yanked 1.0.0", versions)
+	}
+
+	// Slugs are escaped rather than read as more path
+	if _, err := client.GetPackage(ctx, "ada", "ray/lib"); !IsNotFound(err) {
+		t.Errorf("GetPackage(ray/lib) error = %v, want not found", err)
+	}
+}
+
+func TestResolveAndVerify(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/resolve" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request = %s %s, want a JSON POST to /resolve", r.Method, r.URL)
+		}
+		var input models.ResolveInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Fatal(err)
+		}
+
+		if input.Lockfile != nil {
+			verified := input.Lockfile.Packages[0].Commit == "c0ffee"
+			writeJSON(t, w, models.ResolveResult{Lockfile: *input.Lockfile, Verified: &verified})
+			return
+		}
+		result := models.ResolveResult{Lockfile: models.Lockfile{LockfileVersion: 1}}
+		for _, requirement := range input.Requirements {
+			if requirement == "ada/missing" {
+				result.Errors = append(result.Errors, models.ResolveError{Requirement: requirement, Reason: "package not found"})
+				continue
+			}
+			result.Lockfile.Packages = append(result.Lockfile.Packages, models.LockedPackage{Name: requirement, Commit: "c0ffee"})
+		}
+		writeJSON(t, w, result)
+	})
+	ctx := context.Background()
+
+	result, err := client.Resolve(ctx, []string{"ada/raylib", "ada/missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Lockfile.Packages) != 1 || result.Lockfile.Packages[0].Name != "ada/raylib" {
+		t.Errorf("locked = %+v, want ada/raylib only", result.Lockfile.Packages)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Requirement != "ada/missing" {
+		t.Errorf("errors = %+v, want ada/missing", result.Errors)
+	}
+
+	verify, err := client.VerifyLockfile(ctx, &result.Lockfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verify.Verified == nil || !*verify.Verified {
+		t.Errorf("verified = %v, want true", verify.Verified)
+	}
+}
+
+func TestDownloadArchive(t *testing.T) {
+	archive := []byte("not really a tar.gz")
+	sum := sha256.Sum256(archive)
+	announced := hex.EncodeToString(sum[:])
+
+	building := 2
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/packages/ada/raylib/archive" {
+			http.NotFound(w, r)
+			return
+		}
+		// The archive is built in the background while the client waits
+		if ref := r.URL.Query().Get("ref"); ref == "unbuilt" || (ref == "v1.0.0" && building > 0) {
+			building--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("X-Commit-SHA", "c0ffee")
+		w.Header().Set("X-Content-SHA256", announced)
+		if r.URL.Query().Get("ref") == "tampered" {
+			w.Write([]byte("something else"))
+			return
+		}
+		if r.URL.Query().Get("ref") != "v1.0.0" {
+			t.Errorf("ref = %q, want v1.0.0", r.URL.Query().Get("ref"))
+		}
+		w.Write(archive)
+	})
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	got, err := client.DownloadArchive(ctx, "ada", "raylib", "v1.0.0", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CommitSHA != "c0ffee" || got.SHA256 != announced || got.Size != int64(len(archive)) || !bytes.Equal(buf.Bytes(), archive) {
+		t.Errorf("archive = %+v with %q, want the served archive", got, buf.String())
+	}
+	if building != 0 {
+		t.Errorf("downloaded the archive before it was built")
+	}
+
+	if _, err := client.DownloadArchive(ctx, "ada", "raylib", "unbuilt", &buf); !errors.Is(err, ErrArchivePending) {
+		t.Errorf("archive that is never built error = %v, want ErrArchivePending", err)
+	}
+
+	buf.Reset()
+	if _, err := client.DownloadArchive(ctx, "ada", "raylib", "tampered", &buf); !errors.Is(err, ErrArchiveMismatch) {
+		t.Errorf("tampered archive error = %v, want ErrArchiveMismatch", err)
+	}
+}
+
+func TestErrorStatuses(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/packages/ada/missing":
+			http.Error(w, "Package not found", http.StatusNotFound)
+		case "/packages/ada/broken":
+			http.Error(w, "Failed to fetch package", http.StatusInternalServerError)
+		case "/packages/ada/garbled":
+			w.Write([]byte("<html>"))
+		}
+	})
+	ctx := context.Background()
+
+	_, err := client.GetPackage(ctx, "ada", "missing")
+	if !IsNotFound(err) {
+		t.Errorf("missing package error = %v, want not found", err)
+	}
+
+	_, err = client.GetPackage(ctx, "ada", "broken")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Message != "Failed to fetch package" {
+		t.Errorf("broken package error = %v, want a 500 with the server's message", err)
+	}
+	if IsNotFound(err) {
+		t.Error("a 500 counts as not found")
+	}
+
+	if _, err := client.GetPackage(ctx, "ada", "garbled"); err == nil || errors.As(err, &apiErr) {
+		t.Errorf("garbled package error = %v, want a decode error", err)
+	}
+
+	if _, err := client.Resolve(ctx, []string{"ada/raylib"}); err == nil {
+		t.Error("Resolve of an empty response succeeded")
+	}
+}
@@ -0,0 +1,35 @@
+package apiclient
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ref identifies a package, optionally pinned to a version or git ref: author/pkg[@version]
+type Ref struct {
+	Author  string
+	Package string
+	Version string // Empty for latest
+}
+
+// ParseRef parses "author/pkg" or "author/pkg@version"
+func ParseRef(s string) (Ref, error) {
+	var ref Ref
+	name, version, _ := strings.Cut(strings.TrimSpace(s), "@")
+	author, pkg, found := strings.Cut(name, "/")
+	if !found || author == "" || pkg == "" || strings.Contains(pkg, "/") {
+		return ref, fmt.Errorf("invalid package reference %q, expected author/package[@version]", s)
+	}
+
+	ref.Author = author
+	ref.Package = pkg
+	ref.Version = version
+	return ref, nil
+}
+
+func (r Ref) String() string {
+	if r.Version == "" {
+		return r.Author + "/" + r.Package
+	}
+	return r.Author + "/" + r.Package + "@" + r.Version
+}
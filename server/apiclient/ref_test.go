@@ -0,0 +1,35 @@
+package apiclient
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Ref
+		wantErr bool
+	}{
+		{in: "ada/raylib", want: Ref{Author: "ada", Package: "raylib"}},
+		{in: " ada/raylib@v1.2.0 ", want: Ref{Author: "ada", Package: "raylib", Version: "v1.2.0"}},
+		{in: "ada/raylib@main", want: Ref{Author: "ada", Package: "raylib", Version: "main"}},
+		{in: "raylib", wantErr: true},
+		{in: "/raylib", wantErr: true},
+		{in: "ada/", wantErr: true},
+		{in: "ada/raylib/extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRef(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRef(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRef(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if !tt.wantErr && got.String() != strings.TrimSpace(tt.in) {
+			t.Errorf("ParseRef(%q).String() = %q", tt.in, got.String())
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"opm/logger"
+	"opm/middleware"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Actions
+const (
+	ActionFlagResolve       = "flag.resolve"
+	ActionUserBan           = "user.ban"
+	ActionUserSuspend       = "user.suspend"
+	ActionUserUnban         = "user.unban"
+	ActionUserMerge         = "user.merge"
+	ActionPackageUpdate     = "package.update"
+	ActionPackageDelete     = "package.delete"
+	ActionPackageQuarantine = "package.quarantine"
+	ActionPackageRelease    = "package.release"
+	ActionTagRemove         = "tag.remove"
+	ActionTagUpdate         = "tag.update"
+	ActionTagAlias          = "tag.alias"
+	ActionTagMerge          = "tag.merge"
+)
+
+// Target types
+const (
+	TargetFlag       = "flag"
+	TargetUser       = "user"
+	TargetPackage    = "package"
+	TargetPackageTag = "package_tag"
+	TargetTag        = "tag"
+)
+
+// Event describes a privileged action. ActorID and RequestID are taken from the request
+// context when left empty.
+type Event struct {
+	ActorID    int
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{} // State before the change, marshaled to JSON; nil for creations
+	After      interface{} // State after the change; nil for deletions
+	RequestID  string
+}
+
+// Execer is satisfied by *pgxpool.Pool and pgx.Tx, so an event can be written in the same
+// transaction as the change it describes
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// Record appends an event to the audit log using q
+func Record(ctx context.Context, q Execer, e Event) error {
+	if e.ActorID == 0 {
+		if authUser, ok := middleware.GetAuthUser(ctx); ok {
+			e.ActorID = authUser.UserID
+		}
+	}
+	if e.RequestID == "" {
+		e.RequestID = middleware.GetRequestID(ctx)
+	}
+
+	before, err := marshal(e.Before)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit before state: %w", err)
+	}
+	after, err := marshal(e.After)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit after state: %w", err)
+	}
+
+	var actorID, requestID interface{}
+	if e.ActorID != 0 {
+		actorID = e.ActorID
+	}
+	if e.RequestID != "" {
+		requestID = e.RequestID
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO audit_events (actor_id, action, target_type, target_id, before, after, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		actorID, e.Action, e.TargetType, e.TargetID, before, after, requestID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event %s: %w", e.Action, err)
+	}
+	return nil
+}
+
+// Log records an event using q outside of a transaction. Failures are logged rather than
+// returned, for callers whose change has already been committed.
+func Log(ctx context.Context, q Execer, e Event) {
+	if err := Record(ctx, q, e); err != nil {
+		logger.MainLogger.Printf("%v", err)
+	}
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
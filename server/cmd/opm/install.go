@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"opm/apiclient"
+	"opm/models"
+)
+
+// installRecordFile marks a directory as managed by opm, so remove never deletes anything else
+const installRecordFile = ".opm-install.json"
+
+type installRecord struct {
+	Ref           string    `json:"ref"`
+	RepositoryURL string    `json:"repository_url"`
+	Version       string    `json:"version,omitempty"`
+	GitRef        string    `json:"git_ref,omitempty"`
+	CommitSHA     string    `json:"commit_sha"`
+	InstalledAt   time.Time `json:"installed_at"`
+}
+
+func runInstall(ctx context.Context, client *apiclient.Client, collection string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: opm install <author>/<pkg>[@version]")
+	}
+	ref, err := apiclient.ParseRef(args[0])
+	if err != nil {
+		return err
+	}
+
+	p, err := client.GetPackage(ctx, ref.Author, ref.Package)
+	if apiclient.IsNotFound(err) {
+		return fmt.Errorf("package %s not found", ref)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := pickVersion(ctx, client, ref, p)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRepositoryURL(p.RepositoryURL); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(collection, p.Slug)
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists, run `opm remove %s` first", dir, p.Slug)
+	}
+	if err := os.MkdirAll(collection, 0755); err != nil {
+		return err
+	}
+
+	cloneArgs := []string{"clone", "--depth", "1", "--quiet"}
+	gitRef := ""
+	if version != nil {
+		gitRef = version.TagName
+		cloneArgs = append(cloneArgs, "--branch", gitRef)
+	}
+	cloneArgs = append(cloneArgs, "--", p.RepositoryURL, dir)
+
+	fmt.Printf("Cloning %s into %s\n", p.RepositoryURL, dir)
+	if err := git("", cloneArgs...); err != nil {
+		return err
+	}
+
+	commit, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	// A tag that no longer points at the published commit means the release was rewritten
+	if version != nil && !strings.HasPrefix(commit, version.CommitSHA) {
+		os.RemoveAll(dir)
+		return fmt.Errorf("tag %s points at %s but version %s was published at %s",
+			version.TagName, shortSHA(commit), version.Version, shortSHA(version.CommitSHA))
+	}
+
+	record := installRecord{
+		Ref:           ref.Author + "/" + p.Slug,
+		RepositoryURL: p.RepositoryURL,
+		GitRef:        gitRef,
+		CommitSHA:     commit,
+		InstalledAt:   time.Now().UTC(),
+	}
+	if version != nil {
+		record.Version = version.Version
+		record.Ref += "@" + version.Version
+	}
+	if err := writeInstallRecord(dir, record); err != nil {
+		return err
+	}
+
+	fmt.Printf("Installed %s (%s)\n", record.Ref, shortSHA(commit))
+	return nil
+}
+
+// checkRepositoryURL refuses repository URLs that git would read as an option, a local path or
+// a transport other than http(s) and git, since the URL comes from the registry
+func checkRepositoryURL(repositoryURL string) error {
+	u, err := url.Parse(repositoryURL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "git") {
+		return fmt.Errorf("refusing to clone repository URL %q: only http(s) and git URLs are supported", repositoryURL)
+	}
+	return nil
+}
+
+// pickVersion returns the version requested by ref, the package's latest version when none is
+// requested, or nil to install the default branch of a package that has never been published
+func pickVersion(ctx context.Context, client *apiclient.Client, ref apiclient.Ref, p *models.Package) (*models.PackageVersion, error) {
+	if ref.Version == "" {
+		return p.LatestVersion, nil
+	}
+
+	versions, err := client.ListVersions(ctx, ref.Author, ref.Package)
+	if err != nil {
+		return nil, err
+	}
+
+	want := strings.TrimPrefix(ref.Version, "v")
+	for i := range versions {
+		if versions[i].Version == want || versions[i].TagName == ref.Version {
+			if versions[i].Yanked {
+				fmt.Fprintf(os.Stderr, "warning: %s@%s has been yanked\n", ref.Package, versions[i].Version)
+			}
+			return &versions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("version %s of %s/%s not found", ref.Version, ref.Author, ref.Package)
+}
+
+func readInstallRecord(dir string) (*installRecord, error) {
+	data, err := os.ReadFile(filepath.Join(dir, installRecordFile))
+	if err != nil {
+		return nil, err
+	}
+	var record installRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func writeInstallRecord(dir string, record installRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, installRecordFile), append(data, '\n'), 0644)
+}
+
+func git(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return nil
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"opm/apiclient"
+)
+
+const usage = `Usage: opm [flags] <command> [arguments]
+
+Commands:
+  search <query>                    Search the registry
+  info <author>/<pkg>               Show package details and versions
+  install <author>/<pkg>[@version]  Clone a package into the collection directory
+  remove <pkg>                      Remove an installed package
+
+Flags:
+`
+
+func main() {
+	apiURL := flag.String("api", getEnv("OPM_API_URL", apiclient.DefaultBaseURL), "registry API URL (env OPM_API_URL)")
+	collection := flag.String("collection", defaultCollection(), "Odin collection directory to install into (env OPM_COLLECTION)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client := apiclient.New(*apiURL)
+	ctx := context.Background()
+	args := flag.Args()[1:]
+
+	var err error
+	switch flag.Arg(0) {
+	case "search":
+		err = runSearch(ctx, client, args)
+	case "info":
+		err = runInfo(ctx, client, args)
+	case "install":
+		err = runInstall(ctx, client, *collection, args)
+	case "remove":
+		err = runRemove(*collection, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "opm:", err)
+		os.Exit(1)
+	}
+}
+
+func runSearch(ctx context.Context, client *apiclient.Client, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: opm search <query>")
+	}
+
+	page, err := client.Search(ctx, strings.Join(args, " "), 20)
+	if err != nil {
+		return err
+	}
+	if len(page.Items) == 0 {
+		fmt.Println("No packages found")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, p := range page.Items {
+		author := ""
+		if p.Author != nil {
+			author = p.Author.Slug
+		}
+		fmt.Fprintf(tw, "%s/%s\t%s\t%s\n", author, p.Slug, p.Type, truncate(p.Description, 60))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if page.Total > len(page.Items) {
+		fmt.Printf("\nShowing %d of %d packages; refine the query to narrow them down\n", len(page.Items), page.Total)
+	}
+	return nil
+}
+
+func runInfo(ctx context.Context, client *apiclient.Client, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: opm info <author>/<pkg>")
+	}
+	ref, err := apiclient.ParseRef(args[0])
+	if err != nil {
+		return err
+	}
+
+	p, err := client.GetPackage(ctx, ref.Author, ref.Package)
+	if apiclient.IsNotFound(err) {
+		return fmt.Errorf("package %s not found", ref)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (%s/%s)\n", p.DisplayName, ref.Author, p.Slug)
+	fmt.Printf("  %s\n\n", p.Description)
+	fmt.Printf("  Type:        %s\n", p.Type)
+	fmt.Printf("  Status:      %s\n", p.Status)
+	fmt.Printf("  Repository:  %s\n", p.RepositoryURL)
+	if p.License != nil {
+		fmt.Printf("  License:     %s\n", *p.License)
+	}
+	if len(p.Tags) > 0 {
+		names := make([]string, len(p.Tags))
+		for i, t := range p.Tags {
+			names[i] = t.Name
+		}
+		fmt.Printf("  Tags:        %s\n", strings.Join(names, ", "))
+	}
+
+	versions, err := client.ListVersions(ctx, ref.Author, ref.Package)
+	if err != nil {
+		return err
+	}
+	if len(versions) > 0 {
+		fmt.Println("\n  Versions:")
+		for _, v := range versions {
+			yanked := ""
+			if v.Yanked {
+				yanked = " (yanked)"
+			}
+			fmt.Printf("    %-12s %s  %s%s\n", v.Version, shortSHA(v.CommitSHA), v.PublishedAt.Format("2006-01-02"), yanked)
+		}
+	}
+	return nil
+}
+
+func runRemove(collection string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: opm remove <pkg>")
+	}
+
+	name := args[0]
+	if ref, err := apiclient.ParseRef(name); err == nil {
+		name = ref.Package
+	}
+
+	dir := filepath.Join(collection, name)
+	installed, err := readInstallRecord(dir)
+	if err != nil {
+		return fmt.Errorf("%s is not installed by opm in %s", name, collection)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s from %s\n", installed.Ref, dir)
+	return nil
+}
+
+// defaultCollection picks OPM_COLLECTION, then $ODIN_ROOT/shared, then ./shared
+func defaultCollection() string {
+	if dir := os.Getenv("OPM_COLLECTION"); dir != "" {
+		return dir
+	}
+	if root := os.Getenv("ODIN_ROOT"); root != "" {
+		return filepath.Join(root, "shared")
+	}
+	return "shared"
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// truncate shortens s to at most n characters, counting runes so multi-byte characters
+// are never cut in half
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
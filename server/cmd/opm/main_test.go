@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"opm/apiclient"
+	"opm/models"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly ten", 11, "exactly ten"},
+		{"a longer description", 10, "a longe..."},
+		{"line one\nline two", 20, "line one line two"},
+		// Multi-byte characters count once and are never split
+		{"über schöne Pakete", 10, "über sc..."},
+		{"日本語のパッケージです", 6, "日本語..."},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.in, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPickVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]models.PackageVersion{
+			{Version: "1.1.0", TagName: "v1.1.0"},
+			{Version: "1.0.0", TagName: "release-1.0", Yanked: true},
+		})
+	}))
+	defer srv.Close()
+	client := apiclient.New(srv.URL)
+	ctx := context.Background()
+
+	latest := &models.PackageVersion{Version: "1.1.0"}
+	p := &models.Package{Slug: "raylib", LatestVersion: latest}
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "ada/raylib", want: "1.1.0"},
+		{ref: "ada/raylib@1.1.0", want: "1.1.0"},
+		{ref: "ada/raylib@v1.1.0", want: "1.1.0"},
+		{ref: "ada/raylib@release-1.0", want: "1.0.0"},
+		{ref: "ada/raylib@2.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		ref, err := apiclient.ParseRef(tt.ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := pickVersion(ctx, client, ref, p)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pickVersion(%s) error = %v, want error %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && v.Version != tt.want {
+			t.Errorf("pickVersion(%s) = %s, want %s", tt.ref, v.Version, tt.want)
+		}
+	}
+
+	// A package that was never published installs its default branch
+	if v, err := pickVersion(ctx, client, apiclient.Ref{Author: "ada", Package: "raylib"}, &models.Package{}); v != nil || err != nil {
+		t.Errorf("pickVersion of an unpublished package = %v, %v; want nil", v, err)
+	}
+}
+
+func TestCheckRepositoryURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://github.com/ada/raylib", true},
+		{"http://example.com/raylib.git", true},
+		{"git://example.com/raylib.git", true},
+		{"--upload-pack=touch /tmp/pwned", false},
+		{"-uhttps://github.com/ada/raylib", false},
+		{"file:///etc", false},
+		{"ext::sh -c touch% /tmp/pwned", false},
+		{"/home/ada/raylib", false},
+		{"https:///raylib", false},
+	}
+	for _, tt := range tests {
+		if err := checkRepositoryURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("checkRepositoryURL(%q) = %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}
@@ -30,8 +30,30 @@ type Config struct {
 	FrontendURL string
 
 	// API
-	RateLimit  string
-	RateWindow string
+	RateLimit      string // requests per window
+	RateWindow     string
+	TrustedProxies string // IPs and CIDR ranges whose X-Forwarded-For is believed
+
+	// Snapshots
+	SnapshotStorage string // local
+	SnapshotDir     string
+
+	// Moderation
+	QuarantineThresholds string // reason=weight pairs, e.g. "Malicious code=3,Spam=5"
+
+	// How often the trending package scores are recomputed
+	TrendingRefresh string
+
+	// Whether pending schema migrations are applied on startup
+	MigrateOnStart string
+
+	// Connection pool
+	DBMaxConns         string
+	DBMinConns         string
+	DBMaxConnLifetime  string
+	DBMaxConnIdleTime  string
+	DBConnectTimeout   string
+	DBStatementTimeout string // 0 disables the timeout
 }
 
 func Load() (*Config, error) {
@@ -63,6 +85,24 @@ func Load() (*Config, error) {
 		FrontendURL: getEnv("FRONTEND_URL", "http://localhost:3000"),
 		RateLimit:   getEnv("API_RATE_LIMIT", "100"),
 		RateWindow:  getEnv("API_RATE_WINDOW", "1m"),
+
+		TrustedProxies: getEnv("TRUSTED_PROXIES", "127.0.0.1,::1"),
+
+		SnapshotStorage: getEnv("SNAPSHOT_STORAGE", "local"),
+		SnapshotDir:     getEnv("SNAPSHOT_DIR", "snapshots"),
+
+		QuarantineThresholds: getEnv("FLAG_QUARANTINE_THRESHOLDS", ""),
+
+		TrendingRefresh: getEnv("TRENDING_REFRESH_INTERVAL", "10m"),
+
+		MigrateOnStart: getEnv("DB_MIGRATE_ON_START", "true"),
+
+		DBMaxConns:         getEnv("DB_MAX_CONNS", "128"),
+		DBMinConns:         getEnv("DB_MIN_CONNS", "0"),
+		DBMaxConnLifetime:  getEnv("DB_MAX_CONN_LIFETIME", "1h"),
+		DBMaxConnIdleTime:  getEnv("DB_MAX_CONN_IDLE_TIME", "10m"),
+		DBConnectTimeout:   getEnv("DB_CONNECT_TIMEOUT", "30s"),
+		DBStatementTimeout: getEnv("DB_STATEMENT_TIMEOUT", "30s"),
 	}
 
 	// Validate required fields
@@ -72,6 +112,9 @@ func Load() (*Config, error) {
 	if cfg.JWTSecret == "" {
 		return nil, fmt.Errorf("JWT_SECRET is required")
 	}
+	if !cfg.IsDevelopment() && !cfg.IsProduction() {
+		return nil, fmt.Errorf("ENV must be development or production, not %q", cfg.Env)
+	}
 
 	return cfg, nil
 }
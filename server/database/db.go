@@ -1,56 +0,0 @@
-package database
-
-import (
-	"context"
-	"fmt"
-	"time"
-
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
-)
-
-// DB wraps the PostgreSQL connection pool
-type DB struct {
-	*pgxpool.Pool
-}
-
-// New creates a new database connection pool
-func New(databaseURL string) (*DB, error) {
-	config, err := pgxpool.ParseConfig(databaseURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse database URL: %w", err)
-	}
-
-	// Configure the pool
-	config.MaxConns = 25
-	config.MinConns = 5
-	config.MaxConnLifetime = time.Hour
-	config.MaxConnIdleTime = time.Minute * 30
-
-	// Create the pool
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	pool, err := pgxpool.NewWithConfig(ctx, config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create connection pool: %w", err)
-	}
-
-	// Test the connection
-	if err := pool.Ping(ctx); err != nil {
-		pool.Close()
-		return nil, fmt.Errorf("failed to ping database: %w", err)
-	}
-
-	return &DB{Pool: pool}, nil
-}
-
-// Close closes the database connection pool
-func (db *DB) Close() {
-	db.Pool.Close()
-}
-
-// BeginTx starts a new transaction
-func (db *DB) BeginTx(ctx context.Context) (pgx.Tx, error) {
-	return db.Pool.Begin(ctx)
-}
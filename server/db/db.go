@@ -2,79 +2,113 @@ package db
 
 import (
 	"context"
-	"opm/logger"
+	"fmt"
+	"opm/config"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-var Conn *pgxpool.Pool
-
-func InitPool(dbURL string) {
-	config, err := pgxpool.ParseConfig(dbURL)
+// New creates a connection pool for cfg.DatabaseURL, tuned by the DB_* settings, and
+// checks that the database is reachable. Every connection runs in UTC with the
+// configured statement timeout.
+func New(ctx context.Context, cfg *config.Config) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.DatabaseURL)
 	if err != nil {
-		logger.MainLogger.Fatalf("❌ Unable to parse database URL: %v\n", err)
+		return nil, fmt.Errorf("unable to parse database URL: %w", err)
 	}
 
-	config.MaxConns = 128
-	config.MaxConnIdleTime = 10 * time.Minute
-	config.ConnConfig.ConnectTimeout = 30 * time.Second
-
-	Conn, err = pgxpool.NewWithConfig(context.Background(), config)
+	maxConns, err := parseConns("DB_MAX_CONNS", cfg.DBMaxConns)
 	if err != nil {
-		logger.MainLogger.Fatalf("❌ Unable to create connection pool: %v\n", err)
+		return nil, err
 	}
-
-	logger.MainLogger.Println("✅ Database connection pool initialized")
-
-	// Set timezone
-	_, err = Conn.Exec(context.Background(), "SET timezone = 'UTC';")
+	minConns, err := parseConns("DB_MIN_CONNS", cfg.DBMinConns)
 	if err != nil {
-		logger.MainLogger.Fatalf("❌ Error setting timezone: %v\n", err)
+		return nil, err
 	}
+	if maxConns == 0 || minConns > maxConns {
+		return nil, fmt.Errorf("DB_MAX_CONNS must be at least 1 and DB_MIN_CONNS")
+	}
+	poolConfig.MaxConns = maxConns
+	poolConfig.MinConns = minConns
 
-	logger.MainLogger.Println("🕒 Timezone set to UTC")
-}
-
-// Close closes the database connection pool
-func Close() {
-	if Conn != nil {
-		Conn.Close()
-		logger.MainLogger.Println("✅ Database connection pool closed")
+	if poolConfig.MaxConnLifetime, err = parseDuration("DB_MAX_CONN_LIFETIME", cfg.DBMaxConnLifetime, false); err != nil {
+		return nil, err
+	}
+	if poolConfig.MaxConnIdleTime, err = parseDuration("DB_MAX_CONN_IDLE_TIME", cfg.DBMaxConnIdleTime, false); err != nil {
+		return nil, err
+	}
+	if poolConfig.ConnConfig.ConnectTimeout, err = parseDuration("DB_CONNECT_TIMEOUT", cfg.DBConnectTimeout, true); err != nil {
+		return nil, err
+	}
+	statementTimeout, err := parseDuration("DB_STATEMENT_TIMEOUT", cfg.DBStatementTimeout, true)
+	if err != nil {
+		return nil, err
 	}
-}
 
-// Ping checks if the database connection is alive
-func Ping() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	// Session settings don't survive on a single connection, so each new one gets them
+	poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		_, err := conn.Exec(ctx, fmt.Sprintf("SET timezone = 'UTC'; SET statement_timeout = %d", statementTimeout.Milliseconds()))
+		return err
+	}
 
-	err := Conn.Ping(ctx)
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
-		logger.MainLogger.Printf("❌ Database ping failed: %v", err)
-		return err
+		return nil, fmt.Errorf("unable to create connection pool: %w", err)
 	}
-	return nil
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("unable to reach database: %w", err)
+	}
+	return pool, nil
 }
 
-// BeginTx starts a new transaction
-func BeginTx(ctx context.Context) (pgx.Tx, error) {
-	return Conn.Begin(ctx)
+func parseConns(name, value string) (int32, error) {
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return int32(n), nil
 }
 
-// Query is a convenience wrapper for Conn.Query
-func Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
-	return Conn.Query(ctx, sql, args...)
+// parseDuration parses a positive duration, or 0 for no limit when allowZero is set
+func parseDuration(name, value string, allowZero bool) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 || (d == 0 && !allowZero) {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return d, nil
 }
 
-// QueryRow is a convenience wrapper for Conn.QueryRow
-func QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
-	return Conn.QueryRow(ctx, sql, args...)
+// PoolStats is a snapshot of the connection pool
+type PoolStats struct {
+	MaxConns             int32         `json:"max_conns"`
+	TotalConns           int32         `json:"total_conns"`
+	AcquiredConns        int32         `json:"acquired_conns"`
+	IdleConns            int32         `json:"idle_conns"`
+	ConstructingConns    int32         `json:"constructing_conns"`
+	AcquireCount         int64         `json:"acquire_count"`
+	EmptyAcquireCount    int64         `json:"empty_acquire_count"`
+	CanceledAcquireCount int64         `json:"canceled_acquire_count"`
+	AcquireDuration      time.Duration `json:"acquire_duration_ns"`
+	NewConnsCount        int64         `json:"new_conns_count"`
 }
 
-// Exec is a convenience wrapper for Conn.Exec
-func Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
-	return Conn.Exec(ctx, sql, args...)
+// Stats returns a snapshot of pool's usage
+func Stats(pool *pgxpool.Pool) PoolStats {
+	s := pool.Stat()
+	return PoolStats{
+		MaxConns:             s.MaxConns(),
+		TotalConns:           s.TotalConns(),
+		AcquiredConns:        s.AcquiredConns(),
+		IdleConns:            s.IdleConns(),
+		ConstructingConns:    s.ConstructingConns(),
+		AcquireCount:         s.AcquireCount(),
+		EmptyAcquireCount:    s.EmptyAcquireCount(),
+		CanceledAcquireCount: s.CanceledAcquireCount(),
+		AcquireDuration:      s.AcquireDuration(),
+		NewConnsCount:        s.NewConnsCount(),
+	}
 }
@@ -0,0 +1,196 @@
+package gitremote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// FetchTimeout bounds a single shallow fetch and checkout
+	FetchTimeout = 2 * time.Minute
+
+	// ErrTooLarge is returned by Fetch when a repository exceeds its size limit
+	ErrTooLarge = errors.New("repository is too large")
+
+	// fetchPollInterval is how often a running fetch is measured against its size limit
+	fetchPollInterval = 100 * time.Millisecond
+)
+
+// Fetch shallow-clones url at ref (a tag, branch or commit SHA; empty for the default branch)
+// into dir, which must be empty or not exist, and returns the checked out commit. Unless
+// maxSize is 0, the fetch is aborted once the downloaded objects exceed maxSize bytes, and
+// nothing is checked out if the files of the commit add up to more than that.
+func Fetch(ctx context.Context, url, ref, dir string, maxSize int64) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, FetchTimeout)
+	defer cancel()
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	if _, err := run(ctx, dir, "init", "--quiet"); err != nil {
+		return "", err
+	}
+	fetch := []string{"fetch", "--quiet", "--depth", "1", "--no-tags", "--", url, ref}
+	if _, err := runBounded(ctx, dir, maxSize, fetch...); err != nil {
+		return "", err
+	}
+
+	if maxSize > 0 {
+		size, err := treeSize(ctx, dir, "FETCH_HEAD")
+		if err != nil {
+			return "", err
+		}
+		if size > maxSize {
+			return "", ErrTooLarge
+		}
+	}
+
+	if _, err := run(ctx, dir, "checkout", "--quiet", "FETCH_HEAD"); err != nil {
+		return "", err
+	}
+	return run(ctx, dir, "rev-parse", "HEAD")
+}
+
+// runBounded runs git in dir like run, killing it once the objects it downloaded grow
+// past maxSize bytes
+func runBounded(ctx context.Context, dir string, maxSize int64, args ...string) (string, error) {
+	if maxSize <= 0 {
+		return run(ctx, dir, args...)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := filepath.Join(dir, ".git", "objects")
+	var exceeded atomic.Bool
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(fetchPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if dirSize(objects) > maxSize {
+					exceeded.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	out, err := run(ctx, dir, args...)
+	if exceeded.Load() {
+		return "", ErrTooLarge
+	}
+	return out, err
+}
+
+// treeSize adds up the sizes of the files in the tree of commit
+func treeSize(ctx context.Context, dir, commit string) (int64, error) {
+	out, err := run(ctx, dir, "ls-tree", "-r", "-l", "-z", "--full-tree", commit)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, line := range strings.Split(out, "\x00") {
+		// "<mode> <type> <object> <size>\t<path>"; submodules have "-" for a size
+		meta, _, _ := strings.Cut(line, "\t")
+		fields := strings.Fields(meta)
+		if len(fields) != 4 {
+			continue
+		}
+		if size, err := strconv.ParseInt(fields[3], 10, 64); err == nil {
+			total += size
+		}
+	}
+	return total, nil
+}
+
+// dirSize adds up the sizes of the files under dir, skipping any that vanish meanwhile
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// TreeEntry is a file tracked in a checked out commit
+type TreeEntry struct {
+	Mode string // Git file mode: 100644, 100755, 120000 (symlink) or 160000 (submodule)
+	Path string
+}
+
+// ListFiles returns every entry of the tree checked out in dir, sorted by path
+func ListFiles(ctx context.Context, dir string) ([]TreeEntry, error) {
+	out, err := run(ctx, dir, "ls-tree", "-r", "-z", "--full-tree", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []TreeEntry{}
+	for _, line := range strings.Split(out, "\x00") {
+		// "<mode> <type> <object>\t<path>"
+		meta, path, found := strings.Cut(line, "\t")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 {
+			continue
+		}
+		entries = append(entries, TreeEntry{Mode: fields[0], Path: path})
+	}
+	return entries, nil
+}
+
+// run executes git in dir with the same protocol restrictions as LsRemote
+func run(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ALLOW_PROTOCOL="+AllowedProtocols,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("git %s timed out", args[0])
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s failed: %s", args[0], msg)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,150 @@
+package gitremote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Ref types reported by Resolve
+const (
+	RefTypeTag     = "tag"
+	RefTypeBranch  = "branch"
+	RefTypeDefault = "default_branch"
+	RefTypeCommit  = "commit"
+)
+
+var (
+	// AllowedProtocols is passed to git as GIT_ALLOW_PROTOCOL. Local "file" remotes are off by
+	// default so user-supplied repository URLs cannot read from the server's disk.
+	AllowedProtocols = "https:http:git"
+
+	// Timeout bounds a single ls-remote call
+	Timeout = 20 * time.Second
+
+	ErrRefNotFound = errors.New("ref not found")
+
+	fullSHARegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+)
+
+// Remote is the advertised state of a repository, as returned by git ls-remote
+type Remote struct {
+	URL           string
+	DefaultBranch string            // Branch HEAD points to, without refs/heads/
+	Head          string            // Commit HEAD points to
+	Tags          map[string]string // Tag name -> commit (annotated tags are peeled)
+	Branches      map[string]string // Branch name -> commit
+}
+
+// Resolved is a ref pinned to an exact commit
+type Resolved struct {
+	Ref     string `json:"ref"`
+	RefType string `json:"ref_type"`
+	Commit  string `json:"commit"`
+}
+
+// LsRemote lists the refs advertised by a repository
+func LsRemote(ctx context.Context, url string) (*Remote, error) {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--symref", "--", url)
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ALLOW_PROTOCOL="+AllowedProtocols,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("git ls-remote timed out after %s", Timeout)
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("git ls-remote failed: %s", msg)
+	}
+
+	return parseLsRemote(url, out), nil
+}
+
+func parseLsRemote(url string, out []byte) *Remote {
+	remote := &Remote{
+		URL:      url,
+		Tags:     map[string]string{},
+		Branches: map[string]string{},
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		left, name, found := strings.Cut(scanner.Text(), "\t")
+		if !found {
+			continue
+		}
+
+		// "ref: refs/heads/main<TAB>HEAD" from --symref
+		if target, isSymref := strings.CutPrefix(left, "ref: "); isSymref {
+			if name == "HEAD" {
+				remote.DefaultBranch = strings.TrimPrefix(target, "refs/heads/")
+			}
+			continue
+		}
+
+		sha := left
+		switch {
+		case name == "HEAD":
+			remote.Head = sha
+		case strings.HasPrefix(name, "refs/heads/"):
+			remote.Branches[strings.TrimPrefix(name, "refs/heads/")] = sha
+		case strings.HasPrefix(name, "refs/tags/"):
+			tag := strings.TrimPrefix(name, "refs/tags/")
+			if peeled, isPeeled := strings.CutSuffix(tag, "^{}"); isPeeled {
+				// The peeled entry is the commit an annotated tag points to
+				remote.Tags[peeled] = sha
+			} else if _, seen := remote.Tags[tag]; !seen {
+				remote.Tags[tag] = sha
+			}
+		}
+	}
+	return remote
+}
+
+// Resolve pins ref to a commit. An empty ref resolves the default branch; otherwise tags take
+// precedence over branches, and a full 40 character SHA is accepted as-is.
+func (r *Remote) Resolve(ref string) (*Resolved, error) {
+	if ref == "" || ref == "HEAD" {
+		if r.Head == "" {
+			return nil, fmt.Errorf("repository has no default branch")
+		}
+		name := r.DefaultBranch
+		if name == "" {
+			name = "HEAD"
+		}
+		return &Resolved{Ref: name, RefType: RefTypeDefault, Commit: r.Head}, nil
+	}
+
+	if sha, ok := r.Tags[strings.TrimPrefix(ref, "refs/tags/")]; ok {
+		return &Resolved{Ref: strings.TrimPrefix(ref, "refs/tags/"), RefType: RefTypeTag, Commit: sha}, nil
+	}
+
+	if sha, ok := r.Branches[strings.TrimPrefix(ref, "refs/heads/")]; ok {
+		return &Resolved{Ref: strings.TrimPrefix(ref, "refs/heads/"), RefType: RefTypeBranch, Commit: sha}, nil
+	}
+
+	// ls-remote only advertises ref tips, so arbitrary commits can't be verified here
+	if sha := strings.ToLower(ref); fullSHARegex.MatchString(sha) {
+		return &Resolved{Ref: sha, RefType: RefTypeCommit, Commit: sha}, nil
+	}
+
+	return nil, ErrRefNotFound
+}
@@ -0,0 +1,226 @@
+package gitremote
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// defaultProtocols is AllowedProtocols as the server runs with it
+var defaultProtocols = AllowedProtocols
+
+func TestMain(m *testing.M) {
+	// The test repositories are local directories
+	AllowedProtocols += ":file"
+	os.Exit(m.Run())
+}
+
+// testRepo is a local repository with a main and a dev branch, a lightweight tag v1.0.0
+// and an annotated tag v1.1.0
+type testRepo struct {
+	URL      string
+	V1       string // Commit of v1.0.0
+	V11      string // Commit of v1.1.0 and main
+	Dev      string // Commit of dev
+	v11Annot string // The v1.1.0 tag object
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=opm", "GIT_AUTHOR_EMAIL=opm@example.invalid",
+		"GIT_COMMITTER_NAME=opm", "GIT_COMMITTER_EMAIL=opm@example.invalid",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo := &testRepo{URL: "file://" + dir}
+	git(t, dir, "init", "--quiet", "--initial-branch=main")
+
+	write("README.md", "# Parser\n")
+	git(t, dir, "add", "README.md")
+	git(t, dir, "commit", "--quiet", "-m", "Initial commit")
+	git(t, dir, "tag", "v1.0.0")
+	repo.V1 = git(t, dir, "rev-parse", "HEAD")
+
+	write("parser.odin", "package parser\n")
+	git(t, dir, "add", "parser.odin")
+	git(t, dir, "commit", "--quiet", "-m", "Add parser")
+	git(t, dir, "tag", "-a", "v1.1.0", "-m", "Release 1.1.0")
+	repo.V11 = git(t, dir, "rev-parse", "HEAD")
+	repo.v11Annot = git(t, dir, "rev-parse", "v1.1.0")
+
+	git(t, dir, "checkout", "--quiet", "-b", "dev")
+	write("CHANGELOG.md", "Unreleased\n")
+	git(t, dir, "add", "CHANGELOG.md")
+	git(t, dir, "commit", "--quiet", "-m", "Start changelog")
+	repo.Dev = git(t, dir, "rev-parse", "HEAD")
+	git(t, dir, "checkout", "--quiet", "main")
+
+	return repo
+}
+
+func TestLsRemote(t *testing.T) {
+	repo := newTestRepo(t)
+
+	remote, err := LsRemote(context.Background(), repo.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.DefaultBranch != "main" || remote.Head != repo.V11 {
+		t.Errorf("HEAD = %s at %s, want main at %s", remote.DefaultBranch, remote.Head, repo.V11)
+	}
+	if remote.Branches["main"] != repo.V11 || remote.Branches["dev"] != repo.Dev {
+		t.Errorf("branches = %v, want main and dev", remote.Branches)
+	}
+	if remote.Tags["v1.0.0"] != repo.V1 {
+		t.Errorf("v1.0.0 = %s, want %s", remote.Tags["v1.0.0"], repo.V1)
+	}
+	// Annotated tags are peeled to their commit
+	if remote.Tags["v1.1.0"] != repo.V11 || repo.V11 == repo.v11Annot {
+		t.Errorf("v1.1.0 = %s, want the commit %s rather than the tag object", remote.Tags["v1.1.0"], repo.V11)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	repo := newTestRepo(t)
+
+	remote, err := LsRemote(context.Background(), repo.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref     string
+		want    Resolved
+		wantErr bool
+	}{
+		{ref: "", want: Resolved{Ref: "main", RefType: RefTypeDefault, Commit: repo.V11}},
+		{ref: "HEAD", want: Resolved{Ref: "main", RefType: RefTypeDefault, Commit: repo.V11}},
+		{ref: "v1.0.0", want: Resolved{Ref: "v1.0.0", RefType: RefTypeTag, Commit: repo.V1}},
+		{ref: "refs/tags/v1.1.0", want: Resolved{Ref: "v1.1.0", RefType: RefTypeTag, Commit: repo.V11}},
+		{ref: "dev", want: Resolved{Ref: "dev", RefType: RefTypeBranch, Commit: repo.Dev}},
+		{ref: "refs/heads/main", want: Resolved{Ref: "main", RefType: RefTypeBranch, Commit: repo.V11}},
+		{ref: strings.ToUpper(repo.V1), want: Resolved{Ref: repo.V1, RefType: RefTypeCommit, Commit: repo.V1}},
+		{ref: "v2.0.0", wantErr: true},
+		{ref: repo.V1[:12], wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := remote.Resolve(tt.ref)
+		if tt.wantErr {
+			if err != ErrRefNotFound {
+				t.Errorf("Resolve(%q) error = %v, want ErrRefNotFound", tt.ref, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.ref, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("Resolve(%q) = %+v, want %+v", tt.ref, *got, tt.want)
+		}
+	}
+}
+
+func TestFetchAndListFiles(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		ref    string
+		commit string
+		files  string
+	}{
+		{"", repo.V11, "README.md parser.odin"},
+		{"v1.0.0", repo.V1, "README.md"},
+		{"dev", repo.Dev, "CHANGELOG.md README.md parser.odin"},
+	}
+	for _, tt := range tests {
+		dir := filepath.Join(t.TempDir(), "checkout")
+		commit, err := Fetch(ctx, repo.URL, tt.ref, dir, 0)
+		if err != nil {
+			t.Fatalf("Fetch(%q): %v", tt.ref, err)
+		}
+		if commit != tt.commit {
+			t.Errorf("Fetch(%q) = %s, want %s", tt.ref, commit, tt.commit)
+		}
+
+		entries, err := ListFiles(ctx, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := []string{}
+		for _, e := range entries {
+			if e.Mode != "100644" {
+				t.Errorf("%s has mode %s, want 100644", e.Path, e.Mode)
+			}
+			paths = append(paths, e.Path)
+		}
+		if got := strings.Join(paths, " "); got != tt.files {
+			t.Errorf("files at %q = %s, want %s", tt.ref, got, tt.files)
+		}
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	// 64 KiB of zeros compress to almost nothing, so the files are what trips the limit
+	dir := strings.TrimPrefix(repo.URL, "file://")
+	if err := os.WriteFile(filepath.Join(dir, "zeros.bin"), make([]byte, 64*1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "add", "zeros.bin")
+	git(t, dir, "commit", "--quiet", "-m", "Add zeros")
+
+	checkout := filepath.Join(t.TempDir(), "checkout")
+	if _, err := Fetch(ctx, repo.URL, "", checkout, 16*1024); err != ErrTooLarge {
+		t.Errorf("Fetch limited to 16 KiB error = %v, want ErrTooLarge", err)
+	}
+	if _, err := os.Stat(filepath.Join(checkout, "zeros.bin")); !os.IsNotExist(err) {
+		t.Errorf("zeros.bin was checked out past the limit: %v", err)
+	}
+
+	if _, err := Fetch(ctx, repo.URL, "", filepath.Join(t.TempDir(), "checkout"), 1024*1024); err != nil {
+		t.Errorf("Fetch limited to 1 MiB: %v", err)
+	}
+}
+
+func TestLocalRemotesAreRefusedByDefault(t *testing.T) {
+	repo := newTestRepo(t)
+
+	allowed := AllowedProtocols
+	AllowedProtocols = defaultProtocols
+	defer func() { AllowedProtocols = allowed }()
+
+	if _, err := LsRemote(context.Background(), repo.URL); err == nil {
+		t.Error("LsRemote of a file:// remote succeeded")
+	}
+	if _, err := Fetch(context.Background(), repo.URL, "", t.TempDir(), 0); err == nil {
+		t.Error("Fetch of a file:// remote succeeded")
+	}
+}
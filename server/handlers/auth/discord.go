@@ -9,6 +9,8 @@ import (
 	"opm/config"
 	"opm/helpers"
 	"opm/logger"
+
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var discordEndpoint = oauth2.Endpoint{
@@ -16,65 +18,60 @@ var discordEndpoint = oauth2.Endpoint{
 	TokenURL: "https://discord.com/api/oauth2/token",
 }
 
+func discordOAuthConfig(cfg *config.Config) *oauth2.Config {
+	// Construct full redirect URL
+	redirectURL := cfg.Host
+	// In production, don't add port if HOST already includes the full URL
+	if cfg.Env == "development" && cfg.Port != "" && cfg.Port != "80" && cfg.Port != "443" {
+		redirectURL = fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
+	}
+	redirectURL = redirectURL + "/" + cfg.DiscordRedirectURL
+
+	return &oauth2.Config{
+		ClientID:     cfg.DiscordClientID,
+		ClientSecret: cfg.DiscordClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"identify", "email"},
+		Endpoint:     discordEndpoint,
+	}
+}
+
 // DiscordLogin initiates the Discord OAuth flow
+// Params: return_to (optional frontend path to land on after login)
 func DiscordLogin(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Construct full redirect URL
-		redirectURL := cfg.Host
-		// In production, don't add port if HOST already includes the full URL
-		if cfg.Env == "development" && cfg.Port != "" && cfg.Port != "80" && cfg.Port != "443" {
-			redirectURL = fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
-		}
-		redirectURL = redirectURL + "/" + cfg.DiscordRedirectURL
-
-		oauthConfig := &oauth2.Config{
-			ClientID:     cfg.DiscordClientID,
-			ClientSecret: cfg.DiscordClientSecret,
-			RedirectURL:  redirectURL,
-			Scopes:       []string{"identify", "email"},
-			Endpoint:     discordEndpoint,
-		}
+		startOAuth(w, r, cfg, discordOAuthConfig(cfg), helpers.OAuthState{
+			ReturnPath: r.URL.Query().Get("return_to"),
+		})
+	}
+}
 
-		// Generate state token using JWT secret
-		state := helpers.GenerateState(cfg.JWTSecret)
-		
-		url := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOnline)
-		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+// DiscordLink initiates the Discord OAuth flow to link a Discord identity to the current account
+// Params: merge (true to merge the account that already owns the identity), return_to
+func DiscordLink(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startLink(w, r, cfg, discordOAuthConfig(cfg))
 	}
 }
 
 // DiscordCallback handles the Discord OAuth callback
-func DiscordCallback(cfg *config.Config) http.HandlerFunc {
+func DiscordCallback(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Verify state parameter
-		state := r.URL.Query().Get("state")
-		if !helpers.ValidateState(state, cfg.JWTSecret) {
-			logger.SecurityLogger.Printf("Discord OAuth: Invalid state parameter received")
+		st, err := checkOAuthState(w, r, cfg)
+		if err != nil {
+			logger.SecurityLogger.Printf("Discord OAuth: Invalid state parameter: %v", err)
 			http.Error(w, "Invalid state parameter", http.StatusBadRequest)
 			return
 		}
-		
+
 		code := r.URL.Query().Get("code")
 		if code == "" {
 			http.Error(w, "Missing code parameter", http.StatusBadRequest)
 			return
 		}
 
-		// Construct full redirect URL
-		redirectURL := cfg.Host
-		// In production, don't add port if HOST already includes the full URL
-		if cfg.Env == "development" && cfg.Port != "" && cfg.Port != "80" && cfg.Port != "443" {
-			redirectURL = fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
-		}
-		redirectURL = redirectURL + "/" + cfg.DiscordRedirectURL
-
-		oauthConfig := &oauth2.Config{
-			ClientID:     cfg.DiscordClientID,
-			ClientSecret: cfg.DiscordClientSecret,
-			RedirectURL:  redirectURL,
-			Scopes:       []string{"identify", "email"},
-			Endpoint:     discordEndpoint,
-		}
+		oauthConfig := discordOAuthConfig(cfg)
 
 		// Exchange code for token
 		token, err := oauthConfig.Exchange(r.Context(), code)
@@ -97,6 +94,7 @@ func DiscordCallback(cfg *config.Config) http.HandlerFunc {
 		var discordUser struct {
 			ID            string `json:"id"`
 			Username      string `json:"username"`
+			GlobalName    string `json:"global_name"`
 			Discriminator string `json:"discriminator"`
 			Avatar        string `json:"avatar"`
 		}
@@ -107,19 +105,21 @@ func DiscordCallback(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		// TODO: Create or update user in database
-		// TODO: Generate JWT token
-		// TODO: Set cookie and redirect to frontend
-
-		// For now, just return success
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"message": "Discord login successful",
-			"user": map[string]interface{}{
-				"discord_id": discordUser.ID,
-				"username":   discordUser.Username,
-				"avatar":     discordUser.Avatar,
-			},
+		displayName := discordUser.GlobalName
+		if displayName == "" {
+			displayName = discordUser.Username
+		}
+		avatarURL := ""
+		if discordUser.Avatar != "" {
+			avatarURL = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", discordUser.ID, discordUser.Avatar)
+		}
+
+		completeOAuth(w, r, cfg, pool, st, oauthIdentity{
+			Provider:    "discord",
+			ProviderID:  discordUser.ID,
+			Username:    discordUser.Username,
+			DisplayName: displayName,
+			AvatarURL:   avatarURL,
 		})
 	}
-}
\ No newline at end of file
+}
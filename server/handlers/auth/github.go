@@ -4,52 +4,60 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
-	"time"
 
 	"opm/config"
 	"opm/helpers"
 	"opm/logger"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
 )
 
+func githubOAuthConfig(cfg *config.Config) *oauth2.Config {
+	// Construct full redirect URL
+	redirectURL := cfg.Host
+	// In production, don't add port if HOST already includes the full URL
+	if cfg.Env == "development" && cfg.Port != "" && cfg.Port != "80" && cfg.Port != "443" {
+		redirectURL = fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
+	}
+	redirectURL = redirectURL + "/" + cfg.GitHubRedirectURL
+
+	return &oauth2.Config{
+		ClientID:     cfg.GitHubClientID,
+		ClientSecret: cfg.GitHubClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"user:email", "read:user"},
+		Endpoint:     github.Endpoint,
+	}
+}
+
 // GitHubLogin initiates the GitHub OAuth flow
+// Params: return_to (optional frontend path to land on after login)
 func GitHubLogin(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Construct full redirect URL
-		redirectURL := cfg.Host
-		// In production, don't add port if HOST already includes the full URL
-		if cfg.Env == "development" && cfg.Port != "" && cfg.Port != "80" && cfg.Port != "443" {
-			redirectURL = fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
-		}
-		redirectURL = redirectURL + "/" + cfg.GitHubRedirectURL
-
-		oauthConfig := &oauth2.Config{
-			ClientID:     cfg.GitHubClientID,
-			ClientSecret: cfg.GitHubClientSecret,
-			RedirectURL:  redirectURL,
-			Scopes:       []string{"user:email", "read:user"},
-			Endpoint:     github.Endpoint,
-		}
-
-		// Generate state token using JWT secret
-		state := helpers.GenerateState(cfg.JWTSecret)
+		startOAuth(w, r, cfg, githubOAuthConfig(cfg), helpers.OAuthState{
+			ReturnPath: r.URL.Query().Get("return_to"),
+		})
+	}
+}
 
-		url := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOnline)
-		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+// GitHubLink initiates the GitHub OAuth flow to link a GitHub identity to the current account
+// Params: merge (true to merge the account that already owns the identity), return_to
+func GitHubLink(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startLink(w, r, cfg, githubOAuthConfig(cfg))
 	}
 }
 
 // GitHubCallback handles the GitHub OAuth callback
-func GitHubCallback(cfg *config.Config) http.HandlerFunc {
+func GitHubCallback(cfg *config.Config, pool *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		mainLogger := logger.MainLogger
 		// Verify state parameter
-		state := r.URL.Query().Get("state")
-		if !helpers.ValidateState(state, cfg.JWTSecret) {
-			mainLogger.Println("Github Callback Invalid State")
+		st, err := checkOAuthState(w, r, cfg)
+		if err != nil {
+			logger.SecurityLogger.Printf("GitHub OAuth: Invalid state parameter: %v", err)
 			http.Error(w, "Invalid state parameter", http.StatusBadRequest)
 			return
 		}
@@ -61,21 +69,7 @@ func GitHubCallback(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		// Construct full redirect URL
-		redirectURL := cfg.Host
-		// In production, don't add port if HOST already includes the full URL
-		if cfg.Env == "development" && cfg.Port != "" && cfg.Port != "80" && cfg.Port != "443" {
-			redirectURL = fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
-		}
-		redirectURL = redirectURL + "/" + cfg.GitHubRedirectURL
-
-		oauthConfig := &oauth2.Config{
-			ClientID:     cfg.GitHubClientID,
-			ClientSecret: cfg.GitHubClientSecret,
-			RedirectURL:  redirectURL,
-			Scopes:       []string{"user:email", "read:user"},
-			Endpoint:     github.Endpoint,
-		}
+		oauthConfig := githubOAuthConfig(cfg)
 
 		// Exchange code for token
 		token, err := oauthConfig.Exchange(r.Context(), code)
@@ -108,65 +102,17 @@ func GitHubCallback(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		// Create or update user in database
 		displayName := githubUser.Name
 		if displayName == "" {
 			displayName = githubUser.Login
 		}
 
-		user, err := helpers.FindOrCreateUser(
-			r.Context(),
-			"github",
-			fmt.Sprintf("%d", githubUser.ID),
-			githubUser.Login,
-			displayName,
-			githubUser.AvatarURL,
-		)
-		if err != nil {
-			// Log the actual error for debugging
-			mainLogger.Printf("Failed to create/update user: %v\n", err)
-			http.Error(w, "Failed to create/update user", http.StatusInternalServerError)
-			return
-		}
-
-		// Generate JWT token
-		tokenString, err := helpers.GenerateJWT(user.ID, cfg.JWTSecret)
-		if err != nil {
-			mainLogger.Println("Failed to generate token")
-			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
-			return
-		}
-
-		var domain string
-		var secure bool
-		switch os.Getenv("ENV") {
-		case "development":
-			domain = "localhost"
-			secure = false
-		case "production":
-			domain = ".pkg-odin.org" // Allow cookie across subdomains
-			secure = true
-		default:
-			fmt.Println("INVALID ENVIRONMENT")
-			domain = "localhost"
-			secure = false
-		}
-
-		// Set cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    tokenString,
-			Path:     "/",
-			Domain:   domain,
-			HttpOnly: true,
-			Secure:   secure,
-			SameSite: http.SameSiteLaxMode,
-			MaxAge:   7 * 24 * 60 * 60, // 7 days
-			Expires:  time.Now().Add(7 * 24 * time.Hour),
+		completeOAuth(w, r, cfg, pool, st, oauthIdentity{
+			Provider:    "github",
+			ProviderID:  fmt.Sprintf("%d", githubUser.ID),
+			Username:    githubUser.Login,
+			DisplayName: displayName,
+			AvatarURL:   githubUser.AvatarURL,
 		})
-
-		// Redirect to frontend
-		redirectURL = fmt.Sprintf("%s?auth=success", cfg.FrontendURL)
-		http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
 	}
 }
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"opm/config"
+	"opm/helpers"
+	"opm/logger"
+	"opm/middleware"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/oauth2"
+)
+
+// oauthIdentity is the provider account returned to an OAuth callback
+type oauthIdentity struct {
+	Provider    string
+	ProviderID  string
+	Username    string
+	DisplayName string
+	AvatarURL   string
+}
+
+// startLink begins an OAuth flow that attaches the provider identity to the current user
+func startLink(w http.ResponseWriter, r *http.Request, cfg *config.Config, oauthConfig *oauth2.Config) {
+	authUser, ok := middleware.GetAuthUser(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	startOAuth(w, r, cfg, oauthConfig, helpers.OAuthState{
+		ReturnPath: r.URL.Query().Get("return_to"),
+		LinkUserID: authUser.UserID,
+		Merge:      r.URL.Query().Get("merge") == "true",
+	})
+}
+
+// completeOAuth finishes a callback: links the identity when the flow was started by
+// startLink, otherwise logs in as the user owning it
+func completeOAuth(w http.ResponseWriter, r *http.Request, cfg *config.Config, pool *pgxpool.Pool, st *helpers.OAuthState, identity oauthIdentity) {
+	if st.LinkUserID != 0 {
+		completeLink(w, r, cfg, pool, st, identity)
+		return
+	}
+
+	user, err := helpers.FindOrCreateUser(
+		r.Context(),
+		pool,
+		identity.Provider,
+		identity.ProviderID,
+		identity.Username,
+		identity.DisplayName,
+		identity.AvatarURL,
+	)
+	if err != nil {
+		logger.MainLogger.Printf("Failed to create/update %s user: %v", identity.Provider, err)
+		http.Error(w, "Failed to create/update user", http.StatusInternalServerError)
+		return
+	}
+
+	tokenString, err := helpers.CreateSession(r.Context(), pool, r, user.ID, cfg.JWTSecret)
+	if err != nil {
+		logger.MainLogger.Printf("Failed to create session for user %d: %v", user.ID, err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, cfg, tokenString)
+
+	// Redirect to frontend
+	http.Redirect(w, r, frontendRedirect(cfg, st.ReturnPath, "auth", "success"), http.StatusTemporaryRedirect)
+}
+
+// completeLink attaches the identity to the user that started the flow, merging the account
+// that already owns it when asked to. The outcome is passed to the frontend as ?link=.
+func completeLink(w http.ResponseWriter, r *http.Request, cfg *config.Config, pool *pgxpool.Pool, st *helpers.OAuthState, identity oauthIdentity) {
+	ctx := r.Context()
+
+	// The flow must finish in the session that started it
+	authUser, ok := middleware.GetAuthUser(ctx)
+	if !ok || authUser.UserID != st.LinkUserID || authUser.APITokenID != 0 {
+		logger.SecurityLogger.Printf("%s link for user %d completed without its session", identity.Provider, st.LinkUserID)
+		http.Error(w, "Log in again to link this account", http.StatusUnauthorized)
+		return
+	}
+
+	result := "linked"
+	err := helpers.LinkIdentity(ctx, pool, authUser.UserID, identity.Provider, identity.ProviderID)
+	if err == helpers.ErrIdentityInUse && st.Merge {
+		var ownerID int
+		ownerID, err = helpers.FindUserByIdentity(ctx, pool, identity.Provider, identity.ProviderID)
+		if err == nil {
+			err = helpers.MergeUsers(ctx, pool, authUser.UserID, ownerID)
+		}
+		if err == nil {
+			result = "merged"
+			logger.SecurityLogger.Printf("User %d merged user %d via %s", authUser.UserID, ownerID, identity.Provider)
+		}
+	}
+
+	switch err {
+	case nil:
+	case helpers.ErrIdentityInUse:
+		result = "in_use"
+	case helpers.ErrProviderLinked:
+		result = "already_linked"
+	case helpers.ErrMergeConflict:
+		result = "merge_conflict"
+	case helpers.ErrMergeBannedAccount:
+		result = "merge_banned"
+	default:
+		logger.MainLogger.Printf("Failed to link %s identity to user %d: %v", identity.Provider, authUser.UserID, err)
+		http.Error(w, "Failed to link account", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, frontendRedirect(cfg, st.ReturnPath, "link", result), http.StatusTemporaryRedirect)
+}
+
+// setSessionCookie stores the session JWT in the auth cookie. ENV is checked when the
+// config is loaded, so anything but production is development.
+func setSessionCookie(w http.ResponseWriter, cfg *config.Config, tokenString string) {
+	domain := "localhost"
+	secure := false
+	if cfg.IsProduction() {
+		domain = ".pkg-odin.org" // Allow cookie across subdomains
+		secure = true
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    tokenString,
+		Path:     "/",
+		Domain:   domain,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(helpers.SessionTTL.Seconds()),
+		Expires:  time.Now().Add(helpers.SessionTTL),
+	})
+}
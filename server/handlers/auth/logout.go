@@ -4,11 +4,26 @@ import (
 	"net/http"
 	"os"
 	"time"
+
+	"opm/logger"
+	"opm/middleware"
+
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// Logout handles user logout
-func Logout() http.HandlerFunc {
+// Logout handles user logout, revoking the current session
+func Logout(pool *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if authUser, ok := middleware.GetAuthUser(r.Context()); ok && authUser.SessionID != "" {
+			_, err := pool.Exec(r.Context(),
+				"UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL",
+				authUser.SessionID,
+			)
+			if err != nil {
+				logger.MainLogger.Printf("Failed to revoke session for user %d: %v", authUser.UserID, err)
+			}
+		}
+
 		var domain string
 		var secure bool
 		switch os.Getenv("ENV") {
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"opm/config"
+	"opm/helpers"
+	"opm/logger"
+
+	"golang.org/x/oauth2"
+)
+
+const stateCookieName = "oauth_state"
+
+// startOAuth redirects to the provider with a state token bound to this browser
+func startOAuth(w http.ResponseWriter, r *http.Request, cfg *config.Config, oauthConfig *oauth2.Config, st helpers.OAuthState) {
+	state, err := newOAuthState(w, cfg, st)
+	if err != nil {
+		logger.MainLogger.Printf("Failed to generate OAuth state: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	url := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOnline)
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+// newOAuthState creates a state token and stores its nonce in a short-lived cookie
+func newOAuthState(w http.ResponseWriter, cfg *config.Config, st helpers.OAuthState) (string, error) {
+	state, nonce, err := helpers.GenerateState(cfg.JWTSecret, st)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    nonce,
+		Path:     "/auth",
+		HttpOnly: true,
+		Secure:   cfg.IsProduction(),
+		SameSite: http.SameSiteLaxMode, // Sent on the top-level redirect back from the provider
+		MaxAge:   int(helpers.StateTTL.Seconds()),
+		Expires:  time.Now().Add(helpers.StateTTL),
+	})
+	return state, nil
+}
+
+// checkOAuthState validates the state of a callback against the nonce cookie and clears the
+// cookie
+func checkOAuthState(w http.ResponseWriter, r *http.Request, cfg *config.Config) (*helpers.OAuthState, error) {
+	nonce := ""
+	if cookie, err := r.Cookie(stateCookieName); err == nil {
+		nonce = cookie.Value
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    "",
+		Path:     "/auth",
+		HttpOnly: true,
+		Secure:   cfg.IsProduction(),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+		Expires:  time.Now().Add(-1 * time.Hour),
+	})
+
+	if nonce == "" {
+		return nil, helpers.ErrStateMismatch
+	}
+	return helpers.ValidateState(r.URL.Query().Get("state"), nonce, cfg.JWTSecret)
+}
+
+// frontendRedirect builds a frontend URL for returnPath with key=value added to the query
+func frontendRedirect(cfg *config.Config, returnPath, key, value string) string {
+	u, err := url.Parse(cfg.FrontendURL + returnPath)
+	if err != nil {
+		u, _ = url.Parse(cfg.FrontendURL)
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,38 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"opm/db"
+	"opm/logger"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Check reports whether the database is reachable, along with the connection pool's
+// stats
+func Check(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+
+		status := http.StatusOK
+		resp := struct {
+			Status string       `json:"status"`
+			Pool   db.PoolStats `json:"pool"`
+		}{Status: "ok"}
+
+		if err := pool.Ping(ctx); err != nil {
+			logger.MainLogger.Printf("❌ Database ping failed: %v", err)
+			status = http.StatusServiceUnavailable
+			resp.Status = "database unavailable"
+		}
+		resp.Pool = db.Stats(pool)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
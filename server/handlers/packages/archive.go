@@ -0,0 +1,300 @@
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"opm/gitremote"
+	"opm/logger"
+	"opm/middleware"
+	"opm/models"
+	"opm/snapshot"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const snapshotColumns = `id, package_id, ref, commit_sha, sha256, size_bytes, storage_key, created_at`
+
+const (
+	// maxQueuedSnapshots caps the archives waiting to be built for anonymous requests
+	maxQueuedSnapshots = 32
+	// queuedSnapshotWorkers is how many of them are built at once
+	queuedSnapshotWorkers = 2
+	// archiveRetryAfter is what anonymous callers are told to wait for a queued archive
+	archiveRetryAfter = "10"
+)
+
+// snapshotQueue holds the archives being built for anonymous requests, so that repeated
+// requests for one commit queue a single build
+var snapshotQueue = struct {
+	sync.Mutex
+	pending map[string]bool
+	workers chan struct{}
+}{
+	pending: map[string]bool{},
+	workers: make(chan struct{}, queuedSnapshotWorkers),
+}
+
+// GetArchive serves the source archive of a package at a ref, snapshotting it on first request.
+// If the repository can no longer be reached, the most recent snapshot of that ref is served.
+// Anonymous requests for an archive that doesn't exist yet queue its build and get 202 Accepted
+// with a Retry-After; signed in users wait for the build.
+// Params: ref (tag, branch, version or commit; defaults to the latest version or default branch)
+func GetArchive(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		vars := mux.Vars(r)
+		userSlug := vars["userSlug"]
+		pkgSlug := vars["pkgSlug"]
+		ref := r.URL.Query().Get("ref")
+
+		packageID, repositoryURL, err := findRepositoryBySlugs(ctx, pool, userSlug, pkgSlug)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find package %s/%s: %v", userSlug, pkgSlug, err)
+			http.Error(w, "Failed to find package", http.StatusInternalServerError)
+			return
+		}
+
+		requirement := userSlug + "/" + pkgSlug
+		if ref != "" {
+			requirement += "@" + ref
+		}
+
+		locked, reason := newResolver(pool).resolve(ctx, requirement)
+		if reason != "" {
+			// The repository may be gone; fall back to what we stored earlier
+			snap, err := findSnapshotByRef(ctx, pool, packageID, ref)
+			if err == pgx.ErrNoRows {
+				http.Error(w, "Unable to resolve ref: "+reason, http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				logger.MainLogger.Printf("Failed to find snapshot for package %d ref %q: %v", packageID, ref, err)
+				http.Error(w, "Failed to find snapshot", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Warning", fmt.Sprintf(`199 - "serving stored snapshot: %s"`, reason))
+			serveSnapshot(w, r, pkgSlug, snap)
+			return
+		}
+
+		snap, err := findSnapshotByCommit(ctx, pool, packageID, locked.Commit)
+		if err == pgx.ErrNoRows {
+			fetchRef := locked.Ref
+			if locked.RefType == gitremote.RefTypeDefault {
+				fetchRef = ""
+			}
+
+			if _, ok := middleware.GetAuthUser(ctx); !ok {
+				if !queueSnapshot(pool, packageID, repositoryURL, ref, fetchRef, locked.Commit) {
+					w.Header().Set("Retry-After", archiveRetryAfter)
+					http.Error(w, "Too many archives are being built, try again later", http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", archiveRetryAfter)
+				w.WriteHeader(http.StatusAccepted)
+				json.NewEncoder(w).Encode(map[string]string{
+					"status":     "building",
+					"commit_sha": locked.Commit,
+				})
+				return
+			}
+
+			snap, err = createSnapshot(ctx, pool, packageID, repositoryURL, ref, fetchRef)
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to snapshot package %d at %s: %v", packageID, locked.Commit, err)
+			http.Error(w, "Failed to create archive", http.StatusBadGateway)
+			return
+		}
+
+		serveSnapshot(w, r, pkgSlug, snap)
+	}
+}
+
+// CreateSnapshot snapshots a package at a ref on demand (author only)
+// Params: ref (defaults to the default branch)
+func CreateSnapshot(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		vars := mux.Vars(r)
+		userSlug := vars["userSlug"]
+		pkgSlug := vars["pkgSlug"]
+		ref := r.URL.Query().Get("ref")
+
+		var packageID, authorID int
+		var repositoryURL string
+		err := pool.QueryRow(ctx, `
+		SELECT p.id, p.author_id, p.repository_url
+		FROM packages p
+		JOIN users u ON p.author_id = u.id
+		WHERE u.slug = $1 AND p.slug = $2`,
+			userSlug, pkgSlug,
+		).Scan(&packageID, &authorID, &repositoryURL)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find package %s/%s: %v", userSlug, pkgSlug, err)
+			http.Error(w, "Failed to find package", http.StatusInternalServerError)
+			return
+		}
+
+		if authorID != authUser.UserID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		snap, err := createSnapshot(ctx, pool, packageID, repositoryURL, ref, ref)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to snapshot package %d at %q: %v", packageID, ref, err)
+			http.Error(w, "Failed to create snapshot: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(snap)
+	}
+}
+
+// snapshotPackage snapshots the default branch in the background after a package is created
+// or its repository changes
+func snapshotPackage(pool *pgxpool.Pool, packageID int, repositoryURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if _, err := createSnapshot(ctx, pool, packageID, repositoryURL, "", ""); err != nil {
+		logger.MainLogger.Printf("Failed to snapshot package %d from %s: %v", packageID, repositoryURL, err)
+	}
+}
+
+// queueSnapshot builds the archive of commit in the background unless it is already queued.
+// It returns false when the queue is full.
+func queueSnapshot(pool *pgxpool.Pool, packageID int, repositoryURL, ref, fetchRef, commit string) bool {
+	key := strconv.Itoa(packageID) + "/" + commit
+
+	snapshotQueue.Lock()
+	defer snapshotQueue.Unlock()
+	if snapshotQueue.pending[key] {
+		return true
+	}
+	if len(snapshotQueue.pending) >= maxQueuedSnapshots {
+		return false
+	}
+	snapshotQueue.pending[key] = true
+
+	go func() {
+		defer func() {
+			snapshotQueue.Lock()
+			delete(snapshotQueue.pending, key)
+			snapshotQueue.Unlock()
+		}()
+
+		snapshotQueue.workers <- struct{}{}
+		defer func() { <-snapshotQueue.workers }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if _, err := createSnapshot(ctx, pool, packageID, repositoryURL, ref, fetchRef); err != nil {
+			logger.MainLogger.Printf("Failed to snapshot package %d at %s: %v", packageID, commit, err)
+		}
+	}()
+	return true
+}
+
+// createSnapshot builds and stores an archive of fetchRef, recording it under the requested ref.
+// Existing snapshots of the same commit are reused.
+func createSnapshot(ctx context.Context, pool *pgxpool.Pool, packageID int, repositoryURL, ref, fetchRef string) (*models.PackageSnapshot, error) {
+	archive, err := snapshot.Build(ctx, repositoryURL, fetchRef, strconv.Itoa(packageID))
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := scanSnapshot(pool.QueryRow(ctx, `
+		INSERT INTO package_snapshots (package_id, ref, commit_sha, sha256, size_bytes, storage_key)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (package_id, commit_sha) DO UPDATE SET ref = EXCLUDED.ref
+		RETURNING `+snapshotColumns,
+		packageID, ref, archive.Commit, archive.SHA256, archive.Size, archive.Key,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+func serveSnapshot(w http.ResponseWriter, r *http.Request, pkgSlug string, snap *models.PackageSnapshot) {
+	f, err := snapshot.Store.Open(r.Context(), snap.StorageKey)
+	if err == snapshot.ErrNotFound {
+		logger.MainLogger.Printf("Snapshot %d is missing from storage at %s", snap.ID, snap.StorageKey)
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.MainLogger.Printf("Failed to open snapshot %d: %v", snap.ID, err)
+		http.Error(w, "Failed to open snapshot", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	shortCommit := snap.CommitSHA
+	if len(shortCommit) > 12 {
+		shortCommit = shortCommit[:12]
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Length", strconv.FormatInt(snap.SizeBytes, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.tar.gz"`, pkgSlug, shortCommit))
+	w.Header().Set("X-Content-SHA256", snap.SHA256)
+	w.Header().Set("X-Commit-SHA", snap.CommitSHA)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, f)
+}
+
+func findSnapshotByCommit(ctx context.Context, pool *pgxpool.Pool, packageID int, commit string) (*models.PackageSnapshot, error) {
+	return scanSnapshot(pool.QueryRow(ctx, `SELECT `+snapshotColumns+`
+		FROM package_snapshots
+		WHERE package_id = $1 AND commit_sha = $2`,
+		packageID, commit,
+	))
+}
+
+// findSnapshotByRef returns the newest snapshot taken for ref, or the newest of any ref if empty
+func findSnapshotByRef(ctx context.Context, pool *pgxpool.Pool, packageID int, ref string) (*models.PackageSnapshot, error) {
+	return scanSnapshot(pool.QueryRow(ctx, `SELECT `+snapshotColumns+`
+		FROM package_snapshots
+		WHERE package_id = $1 AND ($2 = '' OR ref = $2 OR commit_sha = $2)
+		ORDER BY created_at DESC
+		LIMIT 1`,
+		packageID, ref,
+	))
+}
+
+func scanSnapshot(row pgx.Row) (*models.PackageSnapshot, error) {
+	var s models.PackageSnapshot
+	err := row.Scan(&s.ID, &s.PackageID, &s.Ref, &s.CommitSHA, &s.SHA256, &s.SizeBytes, &s.StorageKey, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
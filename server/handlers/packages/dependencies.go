@@ -0,0 +1,315 @@
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"opm/helpers"
+	"opm/logger"
+	"opm/middleware"
+	"opm/models"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultDependencyDepth = 1
+	maxDependencyDepth     = 10
+)
+
+// dependencyGraphLock is the advisory lock held while adding a dependency, so two edges
+// that together close a cycle can't both pass the cycle check
+const dependencyGraphLock int64 = 0x6f706d5f646570 // "opm_dep"
+
+// Walks the linked package_dependencies from $1 up to $2 levels deep. UNION drops rows
+// already found, so a package reached along several paths is expanded once per depth
+// rather than once per path, and each edge is returned at the depth first seen. An edge
+// is a cycle when its package can reach back to the edge's parent within the walk.
+// {from} and {to} are swapped to walk dependents instead of dependencies.
+const dependencyGraphQuery = `
+	WITH RECURSIVE graph AS (
+		SELECT d.{from} AS parent_id, d.{to} AS node_id, d.version_constraint, d.source, 1 AS depth
+		FROM package_dependencies d
+		WHERE d.{from} = $1 AND d.{to} IS NOT NULL
+		UNION
+		SELECT d.{from}, d.{to}, d.version_constraint, d.source, g.depth + 1
+		FROM package_dependencies d
+		JOIN graph g ON d.{from} = g.node_id
+		WHERE g.depth < $2 AND d.{to} IS NOT NULL
+	),
+	edges AS (
+		SELECT DISTINCT ON (parent_id, node_id) parent_id, node_id, version_constraint, source, depth
+		FROM graph
+		ORDER BY parent_id, node_id, depth
+	),
+	reach AS (
+		SELECT parent_id AS from_id, node_id AS to_id FROM edges
+		UNION
+		SELECT r.from_id, e.node_id
+		FROM reach r
+		JOIN edges e ON e.parent_id = r.to_id
+	)
+	SELECT p.id, p.slug, p.display_name, u.slug, e.version_constraint, e.source, e.depth, e.parent_id,
+	       EXISTS(SELECT 1 FROM reach r WHERE r.from_id = e.node_id AND r.to_id = e.parent_id)
+	FROM edges e
+	JOIN packages p ON p.id = e.node_id
+	JOIN users u ON p.author_id = u.id
+	ORDER BY e.depth, u.slug, p.slug`
+
+// GetDependencies returns the (transitive) dependencies of a package
+// Params: depth (default 1, max 10)
+func GetDependencies(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveDependencyGraph(w, r, pool, "package_id", "dependency_id")
+	}
+}
+
+// GetDependents returns the packages that (transitively) depend on a package
+// Params: depth (default 1, max 10)
+func GetDependents(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveDependencyGraph(w, r, pool, "dependency_id", "package_id")
+	}
+}
+
+func serveDependencyGraph(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, from, to string) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	userSlug := vars["userSlug"]
+	pkgSlug := vars["pkgSlug"]
+
+	depth := defaultDependencyDepth
+	if d, hasDepth := helpers.OptionalParamInt(r, "depth"); hasDepth {
+		depth = *d
+	}
+	if depth < 1 || depth > maxDependencyDepth {
+		http.Error(w, "depth must be between 1 and 10", http.StatusBadRequest)
+		return
+	}
+
+	packageID, _, err := findPackageBySlugs(ctx, pool, userSlug, pkgSlug)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Package not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.MainLogger.Printf("Failed to find package %s/%s: %v", userSlug, pkgSlug, err)
+		http.Error(w, "Failed to find package", http.StatusInternalServerError)
+		return
+	}
+
+	query := strings.NewReplacer("{from}", from, "{to}", to).Replace(dependencyGraphQuery)
+
+	rows, err := pool.Query(ctx, query, packageID, depth)
+	if err != nil {
+		logger.MainLogger.Printf("Failed to walk dependency graph for package %d: %v", packageID, err)
+		http.Error(w, "Failed to fetch dependencies", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	edges := []models.PackageDependency{}
+	for rows.Next() {
+		var d models.PackageDependency
+		err := rows.Scan(&d.PackageID, &d.Slug, &d.DisplayName, &d.AuthorSlug,
+			&d.VersionConstraint, &d.Source, &d.Depth, &d.ParentID, &d.Cycle)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to scan dependency: %v", err)
+			continue
+		}
+		edges = append(edges, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(edges)
+}
+
+// AddDependency declares that a package depends on another package (author only)
+func AddDependency(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		vars := mux.Vars(r)
+		userSlug := vars["userSlug"]
+		pkgSlug := vars["pkgSlug"]
+
+		// Verify ownership
+		packageID, authorID, err := findPackageBySlugs(ctx, pool, userSlug, pkgSlug)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to find package", http.StatusInternalServerError)
+			return
+		}
+
+		if authorID != authUser.UserID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var input models.AddDependencyInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		input.Dependency = strings.ToLower(strings.TrimSpace(input.Dependency))
+		depUserSlug, depPkgSlug, found := strings.Cut(input.Dependency, "/")
+		if !found || depUserSlug == "" || depPkgSlug == "" {
+			http.Error(w, "Dependency must be of the form author/package", http.StatusBadRequest)
+			return
+		}
+
+		constraint, err := helpers.ParseSemverConstraint(input.VersionConstraint)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dependencyID, _, err := findPackageBySlugs(ctx, pool, depUserSlug, depPkgSlug)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Dependency not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find dependency %s: %v", input.Dependency, err)
+			http.Error(w, "Failed to find dependency", http.StatusInternalServerError)
+			return
+		}
+
+		if dependencyID == packageID {
+			http.Error(w, "A package cannot depend on itself", http.StatusBadRequest)
+			return
+		}
+
+		createsCycle, err := insertDependency(ctx, pool, packageID, dependencyID, input.Dependency, constraint.String())
+		if err != nil {
+			logger.MainLogger.Printf("Failed to add dependency %d -> %d: %v", packageID, dependencyID, err)
+			http.Error(w, "Failed to add dependency", http.StatusInternalServerError)
+			return
+		}
+		if createsCycle {
+			http.Error(w, "Dependency would create a cycle", http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"package_id":         packageID,
+			"dependency_id":      dependencyID,
+			"version_constraint": constraint.String(),
+		})
+	}
+}
+
+// RemoveDependency removes a declared dependency (author only)
+func RemoveDependency(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		vars := mux.Vars(r)
+		userSlug := vars["userSlug"]
+		pkgSlug := vars["pkgSlug"]
+
+		// Verify ownership
+		packageID, authorID, err := findPackageBySlugs(ctx, pool, userSlug, pkgSlug)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to find package", http.StatusInternalServerError)
+			return
+		}
+
+		if authorID != authUser.UserID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// Dependencies from the manifest change with the manifest
+		name := vars["depUserSlug"] + "/" + vars["depPkgSlug"]
+		tag, err := pool.Exec(ctx,
+			"DELETE FROM package_dependencies WHERE package_id = $1 AND name = $2 AND source = 'manual'",
+			packageID, name,
+		)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to remove dependency %d -> %s: %v", packageID, name, err)
+			http.Error(w, "Failed to remove dependency", http.StatusInternalServerError)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "Dependency not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// insertDependency adds or updates the hand-added dependency of packageID on dependencyID,
+// called name, unless it would close a cycle, i.e. the dependency already reaches the
+// package. The check and the insert share a transaction holding dependencyGraphLock.
+func insertDependency(ctx context.Context, pool *pgxpool.Pool, packageID, dependencyID int, name, constraint string) (createsCycle bool, err error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", dependencyGraphLock); err != nil {
+		return false, fmt.Errorf("failed to lock dependency graph: %w", err)
+	}
+
+	err = tx.QueryRow(ctx, `
+		WITH RECURSIVE reachable AS (
+			SELECT dependency_id AS id
+			FROM package_dependencies
+			WHERE package_id = $1 AND dependency_id IS NOT NULL
+			UNION
+			SELECT d.dependency_id
+			FROM package_dependencies d
+			JOIN reachable r ON d.package_id = r.id
+			WHERE d.dependency_id IS NOT NULL
+		)
+		SELECT EXISTS(SELECT 1 FROM reachable WHERE id = $2)`,
+		dependencyID, packageID,
+	).Scan(&createsCycle)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for a cycle: %w", err)
+	}
+	if createsCycle {
+		return true, nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO package_dependencies (package_id, name, dependency_id, version_constraint, source)
+		VALUES ($1, $2, $3, $4, 'manual')
+		ON CONFLICT (package_id, name) DO UPDATE SET
+			dependency_id = EXCLUDED.dependency_id,
+			version_constraint = EXCLUDED.version_constraint,
+			source = EXCLUDED.source`,
+		packageID, name, dependencyID, constraint,
+	)
+	if err != nil {
+		return false, err
+	}
+	return false, tx.Commit(ctx)
+}
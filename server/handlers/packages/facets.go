@@ -0,0 +1,33 @@
+package packages
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"opm/store"
+)
+
+// parseFacets validates a comma separated facets parameter. "all" requests every facet.
+func parseFacets(param string) ([]string, error) {
+	if param == "all" {
+		return slices.Clone(store.Facets), nil
+	}
+
+	facets := []string{}
+	seen := map[string]bool{}
+	for _, facet := range strings.Split(param, ",") {
+		facet = strings.TrimSpace(facet)
+		if facet == "tags" {
+			facet = "tag"
+		}
+		if !slices.Contains(store.Facets, facet) {
+			return nil, fmt.Errorf("unknown facet %q", facet)
+		}
+		if !seen[facet] {
+			seen[facet] = true
+			facets = append(facets, facet)
+		}
+	}
+	return facets, nil
+}
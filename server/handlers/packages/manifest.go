@@ -0,0 +1,361 @@
+package packages
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"opm/helpers"
+	"opm/logger"
+	"opm/middleware"
+	"opm/models"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Manifest files looked up in the repository root, in order of preference
+var manifestFiles = []string{"opm.json", "mod.pkg"}
+
+const maxManifestSize = 64 * 1024
+
+var (
+	errManifestNotFound = errors.New("manifest not found")
+
+	packageSlugRegex    = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	dependencyNameRegex = regexp.MustCompile(`^[a-z0-9_-]+/[a-z0-9_-]+$`)
+	odinVersionRegex    = regexp.MustCompile(`^dev-\d{4}-\d{2}[a-z]?$`)
+)
+
+// manifestFile is the on-disk schema shared by opm.json and mod.pkg
+type manifestFile struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	Description  string            `json:"description"`
+	License      string            `json:"license"`
+	OdinVersion  string            `json:"odin_version"`
+	Dependencies map[string]string `json:"dependencies"`
+
+	// mod.pkg fields we accept but don't store
+	URL      string   `json:"url"`
+	Readme   string   `json:"readme"`
+	Keywords []string `json:"keywords"`
+}
+
+// GetManifest returns the stored manifest of a package
+func GetManifest(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		vars := mux.Vars(r)
+		userSlug := vars["userSlug"]
+		pkgSlug := vars["pkgSlug"]
+
+		packageID, _, err := findPackageBySlugs(ctx, pool, userSlug, pkgSlug)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find package %s/%s: %v", userSlug, pkgSlug, err)
+			http.Error(w, "Failed to find package", http.StatusInternalServerError)
+			return
+		}
+
+		manifest, err := getManifest(ctx, pool, packageID)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Manifest not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to fetch manifest for package %d: %v", packageID, err)
+			http.Error(w, "Failed to fetch manifest", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(manifest)
+	}
+}
+
+// RefreshManifest re-fetches and stores the manifest from the package repository (author only)
+func RefreshManifest(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		vars := mux.Vars(r)
+		userSlug := vars["userSlug"]
+		pkgSlug := vars["pkgSlug"]
+
+		var packageID, authorID int
+		var repositoryURL string
+		err := pool.QueryRow(ctx, `
+		SELECT p.id, p.author_id, p.repository_url
+		FROM packages p
+		JOIN users u ON p.author_id = u.id
+		WHERE u.slug = $1 AND p.slug = $2`,
+			userSlug, pkgSlug,
+		).Scan(&packageID, &authorID, &repositoryURL)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find package %s/%s: %v", userSlug, pkgSlug, err)
+			http.Error(w, "Failed to find package", http.StatusInternalServerError)
+			return
+		}
+
+		if authorID != authUser.UserID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		filename, content, err := fetchManifestFromRepo(repositoryURL)
+		if errors.Is(err, errManifestNotFound) {
+			http.Error(w, "No opm.json or mod.pkg found in repository root", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errFileTooLarge) {
+			http.Error(w, fmt.Sprintf("Manifest exceeds %d bytes", maxManifestSize), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to fetch manifest for package %d from %s: %v", packageID, repositoryURL, err)
+			http.Error(w, "Failed to fetch manifest", http.StatusBadGateway)
+			return
+		}
+
+		manifest, err := parseManifest(filename, content)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid %s: %v", filename, err), http.StatusUnprocessableEntity)
+			return
+		}
+		manifest.PackageID = packageID
+
+		if err := storeManifest(ctx, pool, manifest, content); err != nil {
+			logger.MainLogger.Printf("Failed to store manifest for package %d: %v", packageID, err)
+			http.Error(w, "Failed to store manifest", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(manifest)
+	}
+}
+
+// syncManifest fetches and stores the manifest in the background after a package is created or
+// its repository changes. Packages without a manifest are left alone.
+func syncManifest(pool *pgxpool.Pool, packageID int, repositoryURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filename, content, err := fetchManifestFromRepo(repositoryURL)
+	if errors.Is(err, errManifestNotFound) {
+		return
+	}
+	if err != nil {
+		logger.MainLogger.Printf("Failed to fetch manifest for package %d from %s: %v", packageID, repositoryURL, err)
+		return
+	}
+
+	manifest, err := parseManifest(filename, content)
+	if err != nil {
+		logger.MainLogger.Printf("Ignoring invalid %s for package %d: %v", filename, packageID, err)
+		return
+	}
+	manifest.PackageID = packageID
+
+	if err := storeManifest(ctx, pool, manifest, content); err != nil {
+		logger.MainLogger.Printf("Failed to store manifest for package %d: %v", packageID, err)
+	}
+}
+
+// fetchManifestFromRepo returns the name and content of the first manifest file found in the
+// repository root
+func fetchManifestFromRepo(repoURL string) (string, []byte, error) {
+	for _, filename := range manifestFiles {
+		content, err := fetchRawFileFromRepo(repoURL, filename, maxManifestSize)
+		if err == nil {
+			return filename, []byte(content), nil
+		}
+		if !errors.Is(err, errFileNotFound) {
+			return "", nil, err
+		}
+	}
+	return "", nil, errManifestNotFound
+}
+
+// parseManifest validates a manifest file against the opm.json / mod.pkg schema
+func parseManifest(filename string, content []byte) (*models.PackageManifest, error) {
+	if len(content) > maxManifestSize {
+		return nil, fmt.Errorf("manifest exceeds %d bytes", maxManifestSize)
+	}
+
+	var file manifestFile
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("malformed JSON: %w", err)
+	}
+
+	manifest := &models.PackageManifest{
+		SourceFile:   filename,
+		Dependencies: []models.ManifestDependency{},
+	}
+
+	if file.Name != "" {
+		if !packageSlugRegex.MatchString(file.Name) || len(file.Name) > 100 {
+			return nil, fmt.Errorf("name must contain only lowercase letters, numbers, hyphens and underscores")
+		}
+		manifest.Name = &file.Name
+	} else if filename == "opm.json" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	if file.Version == "" {
+		return nil, fmt.Errorf("version is required")
+	}
+	version, err := helpers.ParseSemver(file.Version)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Version = version.String()
+
+	if description := strings.TrimSpace(file.Description); description != "" {
+		manifest.Description = &description
+	}
+
+	if license := strings.TrimSpace(file.License); license != "" {
+		if len(license) > 100 {
+			return nil, fmt.Errorf("license must be at most 100 characters")
+		}
+		manifest.License = &license
+	}
+
+	if file.OdinVersion != "" {
+		if !odinVersionRegex.MatchString(file.OdinVersion) {
+			return nil, fmt.Errorf("odin_version must look like dev-YYYY-MM")
+		}
+		manifest.MinOdinVersion = &file.OdinVersion
+	}
+
+	for name, constraint := range file.Dependencies {
+		if !dependencyNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("dependency %q must be of the form author/package", name)
+		}
+		c, err := helpers.ParseSemverConstraint(constraint)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %q: %w", name, err)
+		}
+		manifest.Dependencies = append(manifest.Dependencies, models.ManifestDependency{
+			Name:              name,
+			VersionConstraint: c.String(),
+		})
+	}
+	sort.Slice(manifest.Dependencies, func(i, j int) bool {
+		return manifest.Dependencies[i].Name < manifest.Dependencies[j].Name
+	})
+
+	return manifest, nil
+}
+
+// storeManifest replaces the stored manifest of a package and the dependencies it declares.
+// Dependencies the author added by hand are kept unless the manifest declares them too.
+func storeManifest(ctx context.Context, pool *pgxpool.Pool, manifest *models.PackageManifest, raw []byte) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO package_manifests (package_id, source_file, name, version, description, license, min_odin_version, raw, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP)
+		ON CONFLICT (package_id) DO UPDATE SET
+			source_file = EXCLUDED.source_file,
+			name = EXCLUDED.name,
+			version = EXCLUDED.version,
+			description = EXCLUDED.description,
+			license = EXCLUDED.license,
+			min_odin_version = EXCLUDED.min_odin_version,
+			raw = EXCLUDED.raw,
+			fetched_at = EXCLUDED.fetched_at
+		RETURNING fetched_at`,
+		manifest.PackageID, manifest.SourceFile, manifest.Name, manifest.Version, manifest.Description,
+		manifest.License, manifest.MinOdinVersion, string(raw),
+	).Scan(&manifest.FetchedAt)
+	if err != nil {
+		return fmt.Errorf("failed to upsert manifest: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM package_dependencies WHERE package_id = $1 AND source = 'manifest'", manifest.PackageID)
+	if err != nil {
+		return fmt.Errorf("failed to clear dependencies: %w", err)
+	}
+
+	for _, dep := range manifest.Dependencies {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO package_dependencies (package_id, name, dependency_id, version_constraint, source)
+			SELECT $1, $2, (
+				SELECT p.id FROM packages p JOIN users u ON p.author_id = u.id
+				WHERE u.slug || '/' || p.slug = $2 AND p.id <> $1
+			), $3, 'manifest'
+			ON CONFLICT (package_id, name) DO UPDATE SET
+				dependency_id = EXCLUDED.dependency_id,
+				version_constraint = EXCLUDED.version_constraint,
+				source = EXCLUDED.source`,
+			manifest.PackageID, dep.Name, dep.VersionConstraint,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert dependency %s: %w", dep.Name, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func getManifest(ctx context.Context, pool *pgxpool.Pool, packageID int) (*models.PackageManifest, error) {
+	m := models.PackageManifest{Dependencies: []models.ManifestDependency{}}
+	err := pool.QueryRow(ctx, `
+		SELECT package_id, source_file, name, version, description, license, min_odin_version, fetched_at
+		FROM package_manifests
+		WHERE package_id = $1`,
+		packageID,
+	).Scan(&m.PackageID, &m.SourceFile, &m.Name, &m.Version, &m.Description, &m.License, &m.MinOdinVersion, &m.FetchedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT name, version_constraint
+		FROM package_dependencies
+		WHERE package_id = $1 AND source = 'manifest'
+		ORDER BY name`,
+		packageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dep models.ManifestDependency
+		if err := rows.Scan(&dep.Name, &dep.VersionConstraint); err != nil {
+			return nil, err
+		}
+		m.Dependencies = append(m.Dependencies, dep)
+	}
+	return &m, rows.Err()
+}
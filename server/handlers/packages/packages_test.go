@@ -0,0 +1,358 @@
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"opm/logger"
+	"opm/middleware"
+	"opm/models"
+	"opm/store"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMain(m *testing.M) {
+	logger.MainLogger = log.New(io.Discard, "", 0)
+	logger.SecurityLogger = log.New(io.Discard, "", 0)
+	os.Exit(m.Run())
+}
+
+// fixture is a memory store with an author and three packages, created a minute apart in
+// the order raylib, raygui, sokol
+type fixture struct {
+	mem      *store.Memory
+	stores   *store.Store
+	authorID int
+	readerID int
+	raylib   int
+	raygui   int
+	sokol    int
+	graphics int
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	mem := store.NewMemory()
+	f := &fixture{mem: mem, stores: mem.Store()}
+
+	f.authorID = mem.AddUser(models.User{Username: "ada", Slug: "ada"})
+	f.readerID = mem.AddUser(models.User{Username: "bob", Slug: "bob"})
+
+	created := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	add := func(slug, name string, packageType models.PackageType) int {
+		created = created.Add(time.Minute)
+		return mem.AddPackage(models.Package{
+			Slug:        slug,
+			DisplayName: name,
+			Description: name + " for Odin",
+			Type:        packageType,
+			Status:      models.PackageStatusReady,
+			AuthorID:    f.authorID,
+			CreatedAt:   created,
+		})
+	}
+	f.raylib = add("raylib", "Raylib", models.PackageTypeLibrary)
+	f.raygui = add("raygui", "Raygui", models.PackageTypeLibrary)
+	f.sokol = add("sokol", "Sokol", models.PackageTypeProject)
+
+	f.graphics = mem.AddTag(models.Tag{Name: "graphics"})
+	mem.TagPackage(f.raylib, f.graphics, 3)
+	mem.TagPackage(f.sokol, f.graphics, 1)
+	return f
+}
+
+// serve runs a handler on a request, as userID when it isn't 0 and with the given route
+// variables
+func serve(t *testing.T, handler http.HandlerFunc, method, target string, body string, userID int, vars map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if userID != 0 {
+		r = r.WithContext(middleware.WithAuthUser(r.Context(), &models.AuthUser{UserID: userID}))
+	}
+	if vars != nil {
+		r = mux.SetURLVars(r, vars)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+type packagePage struct {
+	Items       []models.Package      `json:"items"`
+	NextCursor  *string               `json:"next_cursor"`
+	Total       int                   `json:"total"`
+	Facets      *models.PackageFacets `json:"facets"`
+	Suggestions []string              `json:"suggestions"`
+}
+
+func decodePage(t *testing.T, w *httptest.ResponseRecorder) packagePage {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", w.Code, w.Body.String())
+	}
+	var page packagePage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("decode page: %v", err)
+	}
+	return page
+}
+
+func slugs(packages []models.Package) string {
+	s := make([]string, len(packages))
+	for i, p := range packages {
+		s[i] = p.Slug
+	}
+	return strings.Join(s, ",")
+}
+
+func TestListPaginatesNewestFirst(t *testing.T) {
+	f := newFixture(t)
+
+	w := serve(t, List(f.stores), "GET", "/packages?limit=2", "", 0, nil)
+	first := decodePage(t, w)
+	if got := slugs(first.Items); got != "sokol,raygui" {
+		t.Errorf("first page = %s, want sokol,raygui", got)
+	}
+	if first.Total != 3 {
+		t.Errorf("total = %d, want 3", first.Total)
+	}
+	if first.NextCursor == nil {
+		t.Fatal("first page has no next cursor")
+	}
+	if link := w.Header().Get("Link"); !strings.Contains(link, `rel="next"`) {
+		t.Errorf("Link header %q has no next page", link)
+	}
+
+	w = serve(t, List(f.stores), "GET", "/packages?limit=2&cursor="+url.QueryEscape(*first.NextCursor), "", 0, nil)
+	second := decodePage(t, w)
+	if got := slugs(second.Items); got != "raylib" {
+		t.Errorf("second page = %s, want raylib", got)
+	}
+	if second.NextCursor != nil {
+		t.Errorf("last page has a next cursor")
+	}
+}
+
+func TestListRejectsCursorOfAnotherSort(t *testing.T) {
+	f := newFixture(t)
+
+	first := decodePage(t, serve(t, List(f.stores), "GET", "/packages?limit=1", "", 0, nil))
+	w := serve(t, List(f.stores), "GET", "/packages?sort=popular&cursor="+url.QueryEscape(*first.NextCursor), "", 0, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestListFiltersByTagWithFacets(t *testing.T) {
+	f := newFixture(t)
+
+	page := decodePage(t, serve(t, List(f.stores), "GET", "/packages?tag=Graphics&facets=type,tag", "", 0, nil))
+	if got := slugs(page.Items); got != "sokol,raylib" {
+		t.Errorf("packages = %s, want sokol,raylib", got)
+	}
+	if page.Facets == nil {
+		t.Fatal("no facets")
+	}
+	if page.Facets.Type["library"] != 1 || page.Facets.Type["project"] != 1 {
+		t.Errorf("type facet = %v, want one library and one project", page.Facets.Type)
+	}
+	if len(page.Facets.Tag) != 0 {
+		t.Errorf("tag facet = %v, want the filtered tag left out", page.Facets.Tag)
+	}
+	if len(page.Items[1].Tags) != 1 || page.Items[1].Tags[0].Name != "graphics" {
+		t.Errorf("raylib tags = %+v, want graphics", page.Items[1].Tags)
+	}
+}
+
+func TestSearchMatchesWordPrefixes(t *testing.T) {
+	f := newFixture(t)
+
+	page := decodePage(t, serve(t, Search(f.stores), "GET", "/packages/search?q=ray", "", 0, nil))
+	if got := slugs(page.Items); got != "raygui,raylib" {
+		t.Errorf("results = %s, want raygui,raylib", got)
+	}
+
+	page = decodePage(t, serve(t, Search(f.stores), "GET", "/packages/search?q=vulkan", "", 0, nil))
+	if page.Total != 0 || page.Suggestions == nil {
+		t.Errorf("empty search = %+v, want no results with suggestions", page)
+	}
+}
+
+func TestGetReturnsPackageForViewer(t *testing.T) {
+	f := newFixture(t)
+	if err := f.stores.Bookmarks.Add(t.Context(), f.readerID, f.raylib); err != nil {
+		t.Fatal(err)
+	}
+	f.mem.AddVersion(models.PackageVersion{PackageID: f.raylib, Version: "1.0.0"})
+	f.mem.AddVersion(models.PackageVersion{PackageID: f.raylib, Version: "2.0.0-beta"})
+
+	vars := map[string]string{"userSlug": "ada", "pkgSlug": "raylib"}
+	w := serve(t, Get(f.stores), "GET", "/packages/ada/raylib", "", f.readerID, vars)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", w.Code, w.Body.String())
+	}
+	var p models.Package
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsBookmarked || p.BookmarkCount != 1 {
+		t.Errorf("bookmarked = %v with %d bookmarks, want true with 1", p.IsBookmarked, p.BookmarkCount)
+	}
+	if p.Author == nil || p.Author.Slug != "ada" {
+		t.Errorf("author = %+v, want ada", p.Author)
+	}
+	if p.LatestVersion == nil || p.LatestVersion.Version != "1.0.0" {
+		t.Errorf("latest version = %+v, want the stable 1.0.0", p.LatestVersion)
+	}
+}
+
+func TestGetUnknownPackage(t *testing.T) {
+	f := newFixture(t)
+
+	vars := map[string]string{"userSlug": "bob", "pkgSlug": "raylib"}
+	w := serve(t, Get(f.stores), "GET", "/packages/bob/raylib", "", 0, vars)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestFlagsQuarantineAtThreshold(t *testing.T) {
+	f := newFixture(t)
+	body := `{"package_id": ` + strconv.Itoa(f.sokol) + `, "reason": "Malicious code"}`
+
+	var quarantined bool
+	for i := 0; i < 3; i++ {
+		reporter := f.mem.AddUser(models.User{Username: "reporter" + strconv.Itoa(i), Slug: "reporter" + strconv.Itoa(i)})
+		w := serve(t, FlagPackage(f.stores), "POST", "/flags", body, reporter, nil)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("flag %d: status = %d, body %q", i, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Quarantined bool `json:"quarantined"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Quarantined != (i == 2) {
+			t.Errorf("flag %d: quarantined = %v", i, resp.Quarantined)
+		}
+		quarantined = resp.Quarantined
+
+		if i == 0 {
+			again := serve(t, FlagPackage(f.stores), "POST", "/flags", body, reporter, nil)
+			if again.Code != http.StatusConflict {
+				t.Errorf("second flag by one reporter: status = %d, want %d", again.Code, http.StatusConflict)
+			}
+		}
+	}
+	if !quarantined {
+		t.Fatal("package was not quarantined")
+	}
+
+	page := decodePage(t, serve(t, List(f.stores), "GET", "/packages", "", 0, nil))
+	if got := slugs(page.Items); got != "raygui,raylib" {
+		t.Errorf("packages = %s, want the quarantined sokol hidden", got)
+	}
+
+	// Dismissing a flag for another reason, or one of several that still cross the
+	// threshold, leaves the package quarantined
+	ctx := context.Background()
+	spammer := f.mem.AddUser(models.User{Username: "spammer", Slug: "spammer"})
+	serve(t, FlagPackage(f.stores), "POST", "/flags", `{"package_id": `+strconv.Itoa(f.sokol)+`, "reason": "Spam"}`, spammer, nil)
+	reporter := f.mem.AddUser(models.User{Username: "reporter3", Slug: "reporter3"})
+	serve(t, FlagPackage(f.stores), "POST", "/flags", body, reporter, nil)
+
+	pending, err := f.stores.Flags.ListPending(ctx, f.sokol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var malicious []int
+	for _, flag := range pending {
+		if flag.Reason == "Spam" {
+			if _, err := f.stores.Flags.Resolve(ctx, flag.ID, "dismissed", f.readerID, quarantineThresholds); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			malicious = append(malicious, flag.ID)
+		}
+	}
+	for i, id := range malicious[:2] {
+		if _, err := f.stores.Flags.Resolve(ctx, id, "dismissed", f.readerID, quarantineThresholds); err != nil {
+			t.Fatal(err)
+		}
+		// Three of the four reports stay pending after the first dismissal, two after the second
+		page := decodePage(t, serve(t, List(f.stores), "GET", "/packages", "", 0, nil))
+		want := "raygui,raylib"
+		if i == 1 {
+			want = "sokol,raygui,raylib"
+		}
+		if got := slugs(page.Items); got != want {
+			t.Errorf("after %d dismissals: packages = %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestCreatePackage(t *testing.T) {
+	f := newFixture(t)
+	// Neither a GitHub nor a GitLab URL, so the background manifest sync and snapshot
+	// give up without touching the database
+	body := `{"slug": "odin-imgui", "display_name": "Odin ImGui", "description": "Dear ImGui bindings",
+		"type": "library", "status": "ready", "repository_url": "https://example.invalid/imgui",
+		"tag_ids": [` + strconv.Itoa(f.graphics) + `]}`
+
+	w := serve(t, Create(f.stores, nil), "POST", "/packages", body, f.readerID, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %q", w.Code, w.Body.String())
+	}
+	page := decodePage(t, serve(t, List(f.stores), "GET", "/packages?tag=graphics", "", 0, nil))
+	if got := slugs(page.Items); got != "odin-imgui,sokol,raylib" {
+		t.Errorf("graphics packages = %s, want the new package tagged", got)
+	}
+
+	again := serve(t, Create(f.stores, nil), "POST", "/packages", body, f.authorID, nil)
+	if again.Code != http.StatusConflict {
+		t.Errorf("taken slug: status = %d, want %d", again.Code, http.StatusConflict)
+	}
+}
+
+func TestUpdateAndDeleteRequireAuthor(t *testing.T) {
+	f := newFixture(t)
+	vars := map[string]string{"id": strconv.Itoa(f.raygui)}
+
+	if w := serve(t, Update(f.stores, nil), "PUT", "/packages/"+vars["id"], `{"display_name": "RayGUI"}`, f.readerID, vars); w.Code != http.StatusForbidden {
+		t.Errorf("update by another user: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := serve(t, Update(f.stores, nil), "PUT", "/packages/"+vars["id"], `{}`, f.authorID, vars); w.Code != http.StatusBadRequest {
+		t.Errorf("empty update: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := serve(t, Update(f.stores, nil), "PUT", "/packages/"+vars["id"], `{"display_name": "RayGUI", "license": ""}`, f.authorID, vars); w.Code != http.StatusOK {
+		t.Fatalf("update: status = %d, body %q", w.Code, w.Body.String())
+	}
+	page := decodePage(t, serve(t, Search(f.stores), "GET", "/packages/search?q=raygui", "", 0, nil))
+	if len(page.Items) != 1 || page.Items[0].DisplayName != "RayGUI" {
+		t.Errorf("updated package = %+v, want display name RayGUI", page.Items)
+	}
+
+	if w := serve(t, Delete(f.stores), "DELETE", "/packages/"+vars["id"], "", f.readerID, vars); w.Code != http.StatusForbidden {
+		t.Errorf("delete by another user: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := serve(t, Delete(f.stores), "DELETE", "/packages/"+vars["id"], "", f.authorID, vars); w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d, body %q", w.Code, w.Body.String())
+	}
+	if w := serve(t, Delete(f.stores), "DELETE", "/packages/"+vars["id"], "", f.authorID, vars); w.Code != http.StatusNotFound {
+		t.Errorf("second delete: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	page = decodePage(t, serve(t, List(f.stores), "GET", "/packages", "", 0, nil))
+	if got := slugs(page.Items); got != "sokol,raylib" {
+		t.Errorf("packages = %s, want raygui deleted", got)
+	}
+}
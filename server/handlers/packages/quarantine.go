@@ -0,0 +1,121 @@
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"opm/logger"
+	"opm/middleware"
+	"opm/store"
+)
+
+// flagReasons are the reasons a package can be flagged for
+var flagReasons = map[string]bool{
+	"Malicious code":        true,
+	"Copyright violation":   true,
+	"Inappropriate content": true,
+	"Broken/non-functional": true,
+	"Spam":                  true,
+	"Other":                 true,
+}
+
+// quarantineThresholds is the weighted flag score per reason at which a package is
+// quarantined. Reasons without a threshold never quarantine a package.
+var quarantineThresholds = map[string]float64{
+	"Malicious code":        3,
+	"Copyright violation":   5,
+	"Inappropriate content": 5,
+	"Spam":                  5,
+}
+
+// InitQuarantineThresholds overrides the default thresholds with a comma separated list of
+// reason=weight pairs. A weight of 0 disables quarantine for that reason.
+func InitQuarantineThresholds(spec string) error {
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+
+	thresholds := make(map[string]float64, len(quarantineThresholds))
+	for reason, weight := range quarantineThresholds {
+		thresholds[reason] = weight
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		reason, value, found := strings.Cut(pair, "=")
+		reason = strings.TrimSpace(reason)
+		if !found || !flagReasons[reason] {
+			return fmt.Errorf("invalid quarantine threshold %q", pair)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 {
+			return fmt.Errorf("invalid quarantine threshold %q", pair)
+		}
+		if weight == 0 {
+			delete(thresholds, reason)
+		} else {
+			thresholds[reason] = weight
+		}
+	}
+
+	quarantineThresholds = thresholds
+	return nil
+}
+
+// checkQuarantine quarantines a package once the weighted pending flags for reason cross
+// the reason's threshold. It reports whether the package is quarantined afterwards.
+func checkQuarantine(ctx context.Context, s *store.Store, packageID int, reason string) (bool, error) {
+	threshold, ok := quarantineThresholds[reason]
+	if !ok {
+		return false, nil
+	}
+
+	check, err := s.Flags.Quarantine(ctx, packageID, reason, threshold)
+	if err != nil {
+		return false, err
+	}
+	if check.Newly {
+		logger.SecurityLogger.Printf("Package %d quarantined: %q flags reached %.2f of %.2f", packageID, reason, check.Score, threshold)
+	}
+	return check.Quarantined, nil
+}
+
+// GetQuarantinedPackages returns the packages waiting for moderator review, oldest first
+// (moderator only)
+func GetQuarantinedPackages(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		isModerator, err := s.Users.IsModerator(ctx, authUser.UserID)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to check moderator status for user %d: %v", authUser.UserID, err)
+			http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
+		if !isModerator {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		packages, err := s.Flags.ListQuarantined(ctx)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to fetch quarantined packages: %v", err)
+			http.Error(w, "Failed to fetch quarantined packages", http.StatusInternalServerError)
+			return
+		}
+		for i := range packages {
+			packages[i].Threshold = quarantineThresholds[packages[i].Reason]
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(packages)
+	}
+}
@@ -2,69 +2,83 @@ package packages
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"opm/db"
 	"opm/logger"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxReadmeSize is the largest README served
+const maxReadmeSize = 1024 * 1024
+
+var (
+	errFileNotFound = errors.New("file not found")
+	errFileTooLarge = errors.New("file too large")
 )
 
 // GetPackageReadme fetches the README content from the package's repository
-func GetPackageReadme(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	// Get package ID from query parameter
-	packageIDStr := r.URL.Query().Get("package_id")
-	if packageIDStr == "" {
-		http.Error(w, "Missing package_id parameter", http.StatusBadRequest)
-		return
-	}
+func GetPackageReadme(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		// Get package ID from query parameter
+		packageIDStr := r.URL.Query().Get("package_id")
+		if packageIDStr == "" {
+			http.Error(w, "Missing package_id parameter", http.StatusBadRequest)
+			return
+		}
 
-	var packageID int
-	if _, err := fmt.Sscanf(packageIDStr, "%d", &packageID); err != nil {
-		http.Error(w, "Invalid package_id", http.StatusBadRequest)
-		return
-	}
+		var packageID int
+		if _, err := fmt.Sscanf(packageIDStr, "%d", &packageID); err != nil {
+			http.Error(w, "Invalid package_id", http.StatusBadRequest)
+			return
+		}
 
-	// Get package repository URL
-	var repositoryURL string
-	err := db.Conn.QueryRow(ctx,
-		"SELECT repository_url FROM packages WHERE id = $1",
-		packageID,
-	).Scan(&repositoryURL)
-	if err == pgx.ErrNoRows {
-		http.Error(w, "Package not found", http.StatusNotFound)
-		return
-	}
-	if err != nil {
-		logger.MainLogger.Printf("Failed to find package %d for README: %v", packageID, err)
-		http.Error(w, "Failed to find package", http.StatusInternalServerError)
-		return
-	}
+		// Get package repository URL
+		var repositoryURL string
+		err := pool.QueryRow(ctx,
+			"SELECT repository_url FROM packages WHERE id = $1",
+			packageID,
+		).Scan(&repositoryURL)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find package %d for README: %v", packageID, err)
+			http.Error(w, "Failed to find package", http.StatusInternalServerError)
+			return
+		}
 
-	// Parse repository URL and fetch README
-	readmeContent, err := fetchReadmeFromRepo(repositoryURL)
-	if err != nil {
-		// Check if it's a "not found" error
-		if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "404") {
-			http.Error(w, "README not found", http.StatusNotFound)
+		// Parse repository URL and fetch README
+		readmeContent, err := fetchReadmeFromRepo(repositoryURL)
+		if err != nil {
+			if errors.Is(err, errFileNotFound) {
+				http.Error(w, "README not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, errFileTooLarge) {
+				http.Error(w, "README is too large", http.StatusUnprocessableEntity)
+				return
+			}
+			logger.MainLogger.Printf("Failed to fetch README for package %d from %s: %v", packageID, repositoryURL, err)
+			http.Error(w, "Failed to fetch README", http.StatusInternalServerError)
 			return
 		}
-		logger.MainLogger.Printf("Failed to fetch README for package %d from %s: %v", packageID, repositoryURL, err)
-		http.Error(w, "Failed to fetch README", http.StatusInternalServerError)
-		return
-	}
 
-	// Return README content
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"content": readmeContent,
-		"format":  "markdown",
-	})
+		// Return README content
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"content": readmeContent,
+			"format":  "markdown",
+		})
+	}
 }
 
 // fetchReadmeFromRepo fetches README content from various repository providers
@@ -107,20 +121,20 @@ func fetchGitHubReadme(repoURL string) (string, error) {
 		rawURL := fmt.Sprintf("https://raw.githubusercontent.com/%s/main/%s", repoPath, filename)
 
 		// Try main branch first
-		content, err := fetchFromURL(client, rawURL)
-		if err == nil {
-			return content, nil
+		content, err := fetchFromURL(client, rawURL, maxReadmeSize)
+		if !errors.Is(err, errFileNotFound) {
+			return content, err
 		}
 
 		// Try master branch
 		rawURL = fmt.Sprintf("https://raw.githubusercontent.com/%s/master/%s", repoPath, filename)
-		content, err = fetchFromURL(client, rawURL)
-		if err == nil {
-			return content, nil
+		content, err = fetchFromURL(client, rawURL, maxReadmeSize)
+		if !errors.Is(err, errFileNotFound) {
+			return content, err
 		}
 	}
 
-	return "", fmt.Errorf("README not found")
+	return "", fmt.Errorf("README: %w", errFileNotFound)
 }
 
 // fetchGitLabReadme fetches README from GitLab
@@ -143,40 +157,73 @@ func fetchGitLabReadme(repoURL string) (string, error) {
 		rawURL := fmt.Sprintf("https://gitlab.com/%s/-/raw/main/%s", projectPath, filename)
 
 		// Try main branch first
-		content, err := fetchFromURL(client, rawURL)
-		if err == nil {
-			return content, nil
+		content, err := fetchFromURL(client, rawURL, maxReadmeSize)
+		if !errors.Is(err, errFileNotFound) {
+			return content, err
 		}
 
 		// Try master branch
 		rawURL = fmt.Sprintf("https://gitlab.com/%s/-/raw/master/%s", projectPath, filename)
-		content, err = fetchFromURL(client, rawURL)
-		if err == nil {
-			return content, nil
+		content, err = fetchFromURL(client, rawURL, maxReadmeSize)
+		if !errors.Is(err, errFileNotFound) {
+			return content, err
+		}
+	}
+
+	return "", fmt.Errorf("README: %w", errFileNotFound)
+}
+
+// fetchRawFileFromRepo fetches a single file of at most maxSize bytes from the repository root,
+// trying the main and master branches
+func fetchRawFileFromRepo(repoURL string, filename string, maxSize int64) (string, error) {
+	repoURL = strings.TrimSuffix(repoURL, ".git")
+	repoURL = strings.TrimSuffix(repoURL, "/")
+
+	var rawURLFormat string
+	if parts := strings.Split(repoURL, "github.com/"); len(parts) == 2 {
+		rawURLFormat = "https://raw.githubusercontent.com/" + strings.TrimPrefix(parts[1], "/") + "/%s/%s"
+	} else if parts := strings.Split(repoURL, "gitlab.com/"); len(parts) == 2 {
+		rawURLFormat = "https://gitlab.com/" + strings.TrimPrefix(parts[1], "/") + "/-/raw/%s/%s"
+	} else {
+		return "", fmt.Errorf("unsupported repository provider")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for _, branch := range []string{"main", "master"} {
+		content, err := fetchFromURL(client, fmt.Sprintf(rawURLFormat, branch, filename), maxSize)
+		if !errors.Is(err, errFileNotFound) {
+			return content, err
 		}
 	}
 
-	return "", fmt.Errorf("README not found")
+	return "", fmt.Errorf("%s: %w", filename, errFileNotFound)
 }
 
-// fetchFromURL fetches content from a URL
-func fetchFromURL(client *http.Client, url string) (string, error) {
+// fetchFromURL fetches content of at most maxSize bytes from a URL. A missing file is
+// errFileNotFound; anything else that goes wrong is reported as is.
+func fetchFromURL(client *http.Client, url string, maxSize int64) (string, error) {
 	resp, err := client.Get(url)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errFileNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
 		return "", fmt.Errorf("status code: %d", resp.StatusCode)
 	}
 
-	// Limit README size to 1MB
-	limitedReader := io.LimitReader(resp.Body, 1024*1024)
-	body, err := io.ReadAll(limitedReader)
+	// Read one byte past the limit to tell a file of exactly maxSize from a larger one
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
 	if err != nil {
 		return "", err
 	}
+	if int64(len(body)) > maxSize {
+		return "", fmt.Errorf("%w: over %d bytes", errFileTooLarge, maxSize)
+	}
 
 	return string(body), nil
 }
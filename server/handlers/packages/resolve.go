@@ -0,0 +1,305 @@
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"opm/gitremote"
+	"opm/helpers"
+	"opm/logger"
+	"opm/models"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	lockfileVersion        = 1
+	maxResolveRequirements = 100
+
+	// The resolve endpoint is public, so a request may only make so many ls-remote calls,
+	// and all of them share one deadline
+	maxResolveRepositories = 20
+	resolveTimeout         = 45 * time.Second
+)
+
+var (
+	errTooManyRepositories = fmt.Errorf("more than %d repositories in one request; resolve the rest separately", maxResolveRepositories)
+	errResolveTimeout      = fmt.Errorf("resolve took longer than %s; resolve fewer packages at once", resolveTimeout)
+)
+
+// resolver resolves requirements for a single request, caching ls-remote per repository
+type resolver struct {
+	pool    *pgxpool.Pool
+	remotes map[string]*gitremote.Remote
+	errs    map[string]error
+}
+
+func newResolver(pool *pgxpool.Pool) *resolver {
+	return &resolver{
+		pool:    pool,
+		remotes: map[string]*gitremote.Remote{},
+		errs:    map[string]error{},
+	}
+}
+
+// Resolve pins a list of author/pkg[@ref] requirements to exact commits and returns a lockfile.
+// When a lockfile is posted instead, every locked package is re-resolved and compared.
+func Resolve(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), resolveTimeout)
+		defer cancel()
+
+		var input models.ResolveInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if input.Lockfile == nil && len(input.Requirements) == 0 {
+			http.Error(w, "requirements or lockfile is required", http.StatusBadRequest)
+			return
+		}
+		if len(input.Requirements) > maxResolveRequirements ||
+			(input.Lockfile != nil && len(input.Lockfile.Packages) > maxResolveRequirements) {
+			http.Error(w, fmt.Sprintf("At most %d packages can be resolved at once", maxResolveRequirements), http.StatusBadRequest)
+			return
+		}
+
+		res := newResolver(pool)
+
+		result := models.ResolveResult{
+			Lockfile: models.Lockfile{
+				LockfileVersion: lockfileVersion,
+				GeneratedAt:     time.Now().UTC(),
+				Packages:        []models.LockedPackage{},
+			},
+			Errors: []models.ResolveError{},
+		}
+
+		if input.Lockfile != nil {
+			result.Lockfile = *input.Lockfile
+			for _, locked := range input.Lockfile.Packages {
+				if reason := res.verify(ctx, locked); reason != "" {
+					result.Errors = append(result.Errors, models.ResolveError{Requirement: locked.Name, Reason: reason})
+				}
+			}
+			verified := len(result.Errors) == 0
+			result.Verified = &verified
+		} else {
+			for _, requirement := range input.Requirements {
+				locked, reason := res.resolve(ctx, requirement)
+				if reason != "" {
+					result.Errors = append(result.Errors, models.ResolveError{Requirement: requirement, Reason: reason})
+					continue
+				}
+				result.Lockfile.Packages = append(result.Lockfile.Packages, *locked)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// resolve pins a single requirement. On failure the returned reason is meant for the client.
+func (res *resolver) resolve(ctx context.Context, requirement string) (*models.LockedPackage, string) {
+	name, ref, _ := strings.Cut(strings.TrimSpace(requirement), "@")
+	userSlug, pkgSlug, found := strings.Cut(name, "/")
+	if !found || userSlug == "" || pkgSlug == "" {
+		return nil, "expected author/package[@ref]"
+	}
+
+	packageID, repositoryURL, err := findRepositoryBySlugs(ctx, res.pool, userSlug, pkgSlug)
+	if err == pgx.ErrNoRows {
+		return nil, "package not found"
+	}
+	if err != nil {
+		logger.MainLogger.Printf("Failed to find package %s for resolve: %v", name, err)
+		return nil, "failed to look up package"
+	}
+
+	locked := &models.LockedPackage{
+		Name:          name,
+		Requested:     ref,
+		RepositoryURL: repositoryURL,
+	}
+
+	// Published versions take precedence, so "1.2.0" and "^1.2" resolve through the registry
+	version, reason := matchPublishedVersion(ctx, res.pool, packageID, ref)
+	if reason != "" {
+		return nil, reason
+	}
+
+	remote, err := res.remote(ctx, repositoryURL)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	if version != nil {
+		resolved, err := remote.Resolve(version.TagName)
+		if err != nil {
+			return nil, fmt.Sprintf("tag %s of version %s is missing from the repository", version.TagName, version.Version)
+		}
+		if !strings.HasPrefix(resolved.Commit, version.CommitSHA) {
+			return nil, fmt.Sprintf("tag %s points at %s but version %s was published at %s",
+				version.TagName, resolved.Commit, version.Version, version.CommitSHA)
+		}
+		locked.Version = version.Version
+		locked.Ref = resolved.Ref
+		locked.RefType = resolved.RefType
+		locked.Commit = resolved.Commit
+		return locked, ""
+	}
+
+	resolved, err := remote.Resolve(ref)
+	if err == gitremote.ErrRefNotFound {
+		return nil, fmt.Sprintf("no tag, branch or published version named %q", ref)
+	}
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	locked.Ref = resolved.Ref
+	locked.RefType = resolved.RefType
+	locked.Commit = resolved.Commit
+	return locked, ""
+}
+
+// verify re-resolves a locked package and reports how it drifted, if it did
+func (res *resolver) verify(ctx context.Context, locked models.LockedPackage) string {
+	userSlug, pkgSlug, found := strings.Cut(locked.Name, "/")
+	if !found {
+		return "expected author/package"
+	}
+
+	_, repositoryURL, err := findRepositoryBySlugs(ctx, res.pool, userSlug, pkgSlug)
+	if err == pgx.ErrNoRows {
+		return "package not found"
+	}
+	if err != nil {
+		logger.MainLogger.Printf("Failed to find package %s for verify: %v", locked.Name, err)
+		return "failed to look up package"
+	}
+	if repositoryURL != locked.RepositoryURL {
+		return fmt.Sprintf("repository changed from %s to %s", locked.RepositoryURL, repositoryURL)
+	}
+
+	// Commits can't be re-checked through ls-remote; the repository match above is all we have
+	if locked.RefType == gitremote.RefTypeCommit {
+		return ""
+	}
+
+	remote, err := res.remote(ctx, repositoryURL)
+	if err != nil {
+		return err.Error()
+	}
+
+	ref := locked.Ref
+	if locked.RefType == gitremote.RefTypeDefault {
+		ref = ""
+	}
+	resolved, err := remote.Resolve(ref)
+	if err != nil {
+		return fmt.Sprintf("%s %s no longer exists", locked.RefType, locked.Ref)
+	}
+	if resolved.Commit != locked.Commit {
+		return fmt.Sprintf("%s %s now points at %s, locked at %s", locked.RefType, locked.Ref, resolved.Commit, locked.Commit)
+	}
+	return ""
+}
+
+func (res *resolver) remote(ctx context.Context, repositoryURL string) (*gitremote.Remote, error) {
+	if remote, ok := res.remotes[repositoryURL]; ok {
+		return remote, nil
+	}
+	if err, ok := res.errs[repositoryURL]; ok {
+		return nil, err
+	}
+	if len(res.remotes)+len(res.errs) >= maxResolveRepositories {
+		return nil, errTooManyRepositories
+	}
+	if ctx.Err() != nil {
+		return nil, errResolveTimeout
+	}
+
+	remote, err := gitremote.LsRemote(ctx, repositoryURL)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = errResolveTimeout
+	}
+	if err != nil {
+		logger.MainLogger.Printf("Failed to list refs of %s: %v", repositoryURL, err)
+		res.errs[repositoryURL] = err
+		return nil, err
+	}
+	res.remotes[repositoryURL] = remote
+	return remote, nil
+}
+
+// matchPublishedVersion picks the highest non-yanked version matching ref. An empty ref picks
+// the latest version; a ref that isn't a version or constraint returns nil so it is tried as
+// a git ref instead.
+func matchPublishedVersion(ctx context.Context, pool *pgxpool.Pool, packageID int, ref string) (*models.PackageVersion, string) {
+	if ref == "" {
+		latest, err := getLatestVersion(ctx, pool, packageID)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to get latest version for package %d: %v", packageID, err)
+			return nil, "failed to look up versions"
+		}
+		return latest, ""
+	}
+
+	constraint, err := helpers.ParseSemverConstraint(ref)
+	if err != nil {
+		return nil, ""
+	}
+
+	rows, err := pool.Query(ctx, `SELECT `+versionColumns+`
+		FROM package_versions
+		WHERE package_id = $1 AND NOT yanked`,
+		packageID,
+	)
+	if err != nil {
+		logger.MainLogger.Printf("Failed to fetch versions for package %d: %v", packageID, err)
+		return nil, "failed to look up versions"
+	}
+	defer rows.Close()
+
+	var best *models.PackageVersion
+	var bestSemver *helpers.Semver
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to scan version: %v", err)
+			continue
+		}
+		semver, err := helpers.ParseSemver(v.Version)
+		if err != nil || !constraint.Matches(*semver) {
+			continue
+		}
+		if bestSemver == nil || semver.Compare(*bestSemver) > 0 {
+			best, bestSemver = v, semver
+		}
+	}
+
+	// An exact version may still exist as an unpublished tag; ranges have nothing to fall back to
+	if best == nil && constraint.Op != "=" {
+		return nil, fmt.Sprintf("no published version matches %s", constraint.String())
+	}
+	return best, ""
+}
+
+func findRepositoryBySlugs(ctx context.Context, pool *pgxpool.Pool, userSlug, pkgSlug string) (packageID int, repositoryURL string, err error) {
+	err = pool.QueryRow(ctx, `
+		SELECT p.id, p.repository_url
+		FROM packages p
+		JOIN users u ON p.author_id = u.id
+		WHERE u.slug = $1 AND p.slug = $2`,
+		userSlug, pkgSlug,
+	).Scan(&packageID, &repositoryURL)
+	return packageID, repositoryURL, err
+}
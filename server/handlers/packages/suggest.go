@@ -0,0 +1,55 @@
+package packages
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"opm/helpers"
+	"opm/logger"
+	"opm/models"
+	"opm/store"
+)
+
+// Autocomplete sizes of Suggest
+const (
+	defaultSuggestLimit = 8
+	maxSuggestLimit     = 20
+)
+
+// Suggest autocompletes a partially typed package name. Names starting with the query come
+// first, then trigram-similar ones, so it tolerates typos like Search does.
+func Suggest(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		query, ok := helpers.RequiredParamString(r, w, "q")
+		if !ok {
+			return
+		}
+		query = strings.TrimSpace(query)
+
+		limit := defaultSuggestLimit
+		if l, hasLimit := helpers.OptionalParamInt(r, "limit"); hasLimit {
+			if *l < 1 || *l > maxSuggestLimit {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+			limit = *l
+		}
+
+		suggestions := []models.PackageSuggestion{}
+		if query != "" {
+			var err error
+			suggestions, err = s.Packages.Suggest(ctx, query, limit)
+			if err != nil {
+				logger.MainLogger.Printf("Failed to suggest packages for '%s': %v", query, err)
+				http.Error(w, "Failed to suggest packages", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(suggestions)
+	}
+}
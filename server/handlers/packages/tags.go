@@ -3,236 +3,331 @@ package packages
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
-	"opm/db"
+	"opm/audit"
+	"opm/helpers"
 	"opm/logger"
 	"opm/middleware"
+	"opm/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // AddTag adds a tag to a package
-func AddTag(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	authUser, ok := middleware.GetAuthUser(ctx)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+func AddTag(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
 
-	var input struct {
-		PackageID int    `json:"package_id"`
-		TagName   string `json:"tag_name"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
+		var input struct {
+			PackageID int                 `json:"package_id"`
+			TagName   string              `json:"tag_name"`
+			Category  *models.TagCategory `json:"category,omitempty"` // Only used when the tag is new
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
 
-	// Validate input
-	if input.PackageID == 0 || input.TagName == "" {
-		http.Error(w, "Package ID and tag name are required", http.StatusBadRequest)
-		return
-	}
+		// Validate input
+		if input.PackageID == 0 || input.TagName == "" {
+			http.Error(w, "Package ID and tag name are required", http.StatusBadRequest)
+			return
+		}
+		tagName, err := helpers.NormalizeTagName(input.TagName)
+		if err != nil {
+			http.Error(w, "Invalid tag name: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if input.Category != nil && !input.Category.IsValid() {
+			http.Error(w, "Invalid tag category", http.StatusBadRequest)
+			return
+		}
 
-	// Verify package exists
-	var packageExists bool
-	err := db.Conn.QueryRow(ctx,
-		"SELECT EXISTS(SELECT 1 FROM packages WHERE id = $1)",
-		input.PackageID,
-	).Scan(&packageExists)
-	if err != nil {
-		logger.MainLogger.Printf("Failed to check package existence for package %d: %v", input.PackageID, err)
-		http.Error(w, "Failed to check package existence", http.StatusInternalServerError)
-		return
-	}
-	if !packageExists {
-		http.Error(w, "Package not found", http.StatusNotFound)
-		return
-	}
+		// Verify package exists
+		var authorID int
+		err = pool.QueryRow(ctx,
+			"SELECT author_id FROM packages WHERE id = $1",
+			input.PackageID,
+		).Scan(&authorID)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to check package existence for package %d: %v", input.PackageID, err)
+			http.Error(w, "Failed to check package existence", http.StatusInternalServerError)
+			return
+		}
 
-	// Get or create tag
-	var tagID int
-	err = db.Conn.QueryRow(ctx,
-		"INSERT INTO tags (name, added_by) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id",
-		input.TagName, authUser.UserID,
-	).Scan(&tagID)
-	if err != nil {
-		logger.MainLogger.Printf("Failed to create tag '%s': %v", input.TagName, err)
-		http.Error(w, "Failed to create tag", http.StatusInternalServerError)
-		return
-	}
+		rank, err := helpers.GetReputationRank(ctx, pool, authUser.UserID)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to get reputation rank for user %d: %v", authUser.UserID, err)
+			http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
+		if !withinDailyVoteLimit(w, r, pool, authUser.UserID, rank) {
+			return
+		}
 
-	// Add tag to package if not already present
-	_, err = db.Conn.Exec(ctx,
-		"INSERT INTO package_tags (package_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
-		input.PackageID, tagID,
-	)
-	if err != nil {
-		logger.MainLogger.Printf("Failed to add tag %d to package %d: %v", tagID, input.PackageID, err)
-		http.Error(w, "Failed to add tag to package", http.StatusInternalServerError)
-		return
-	}
+		// Existing tags and their aliases resolve to the canonical tag
+		var tagID int
+		tag, err := helpers.ResolveTag(ctx, pool, tagName)
+		if err == nil {
+			tagID, tagName = tag.ID, tag.Name
+		} else if err != pgx.ErrNoRows {
+			logger.MainLogger.Printf("Failed to resolve tag '%s': %v", tagName, err)
+			http.Error(w, "Failed to check tag existence", http.StatusInternalServerError)
+			return
+		} else {
+			// Only established users and moderators may introduce tags nobody has used yet
+			if !helpers.RankAtLeast(rank, helpers.RankMember) {
+				isModerator, err := helpers.IsModerator(ctx, pool, authUser.UserID)
+				if err != nil {
+					logger.MainLogger.Printf("Failed to check moderator status for user %d: %v", authUser.UserID, err)
+					http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+					return
+				}
+				if !isModerator {
+					http.Error(w, "Creating new tags requires the "+helpers.RankMember+" rank", http.StatusForbidden)
+					return
+				}
+			}
+
+			err = pool.QueryRow(ctx,
+				"INSERT INTO tags (name, added_by, category) VALUES ($1, $2, $3) ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id",
+				tagName, authUser.UserID, input.Category,
+			).Scan(&tagID)
+			if err != nil {
+				logger.MainLogger.Printf("Failed to create tag '%s': %v", tagName, err)
+				http.Error(w, "Failed to create tag", http.StatusInternalServerError)
+				return
+			}
+		}
 
-	// All votes are worth 1 for now
-	voteValue := 1
+		// Add tag to package if not already present
+		_, err = pool.Exec(ctx,
+			"INSERT INTO package_tags (package_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			input.PackageID, tagID,
+		)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to add tag %d to package %d: %v", tagID, input.PackageID, err)
+			http.Error(w, "Failed to add tag to package", http.StatusInternalServerError)
+			return
+		}
 
-	// Add initial vote
-	_, err = db.Conn.Exec(ctx, `
+		// Higher ranks and the package author cast heavier votes
+		voteValue := helpers.TagVoteWeight(rank, authorID == authUser.UserID)
+
+		// Add initial vote
+		_, err = pool.Exec(ctx, `
 			INSERT INTO tag_votes (package_id, tag_id, user_id, vote_value)
 			VALUES ($1, $2, $3, $4)
 			ON CONFLICT (package_id, tag_id, user_id) 
 			DO UPDATE SET vote_value = EXCLUDED.vote_value`,
-		input.PackageID, tagID, authUser.UserID, voteValue,
-	)
-	if err != nil {
-		logger.MainLogger.Printf("Failed to add tag vote for package %d, tag %d, user %d: %v", input.PackageID, tagID, authUser.UserID, err)
-		http.Error(w, "Failed to add vote", http.StatusInternalServerError)
-		return
-	}
+			input.PackageID, tagID, authUser.UserID, voteValue,
+		)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to add tag vote for package %d, tag %d, user %d: %v", input.PackageID, tagID, authUser.UserID, err)
+			http.Error(w, "Failed to add vote", http.StatusInternalServerError)
+			return
+		}
 
-	// Update package_tags score
-	updateTagScore(ctx, input.PackageID, tagID)
+		// Update package_tags score
+		if _, _, err := updateTagScore(ctx, pool, input.PackageID, tagID); err != nil {
+			logger.MainLogger.Printf("%v", err)
+		}
+		helpers.RefreshAuthorReputationAsync(pool, input.PackageID)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"tag_id":     tagID,
-		"tag_name":   input.TagName,
-		"vote_value": voteValue,
-	})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tag_id":     tagID,
+			"tag_name":   tagName,
+			"vote_value": voteValue,
+		})
+	}
 }
 
 // VoteTag votes on a tag for a package
-func VoteTag(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	authUser, ok := middleware.GetAuthUser(ctx)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+func VoteTag(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
 
-	var input struct {
-		PackageID int `json:"package_id"`
-		TagID     int `json:"tag_id"`
-		Vote      int `json:"vote"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
+		var input struct {
+			PackageID int `json:"package_id"`
+			TagID     int `json:"tag_id"`
+			Vote      int `json:"vote"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
 
-	// Validate input
-	if input.PackageID == 0 || input.TagID == 0 {
-		http.Error(w, "Package ID and tag ID are required", http.StatusBadRequest)
-		return
-	}
+		// Validate input
+		if input.PackageID == 0 || input.TagID == 0 {
+			http.Error(w, "Package ID and tag ID are required", http.StatusBadRequest)
+			return
+		}
 
-	// Validate vote value
-	if input.Vote < -1 || input.Vote > 1 {
-		http.Error(w, "Vote must be -1, 0, or 1", http.StatusBadRequest)
-		return
-	}
+		// Validate vote value
+		if input.Vote < -1 || input.Vote > 1 {
+			http.Error(w, "Vote must be -1, 0, or 1", http.StatusBadRequest)
+			return
+		}
 
-	// Verify package exists
-	var packageExists bool
-	err := db.Conn.QueryRow(ctx,
-		"SELECT EXISTS(SELECT 1 FROM packages WHERE id = $1)",
-		input.PackageID,
-	).Scan(&packageExists)
-	if err != nil {
-		logger.MainLogger.Printf("Failed to check package existence for package %d: %v", input.PackageID, err)
-		http.Error(w, "Failed to check package existence", http.StatusInternalServerError)
-		return
-	}
-	if !packageExists {
-		http.Error(w, "Package not found", http.StatusNotFound)
-		return
-	}
+		// Verify package exists
+		var authorID int
+		err := pool.QueryRow(ctx,
+			"SELECT author_id FROM packages WHERE id = $1",
+			input.PackageID,
+		).Scan(&authorID)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to check package existence for package %d: %v", input.PackageID, err)
+			http.Error(w, "Failed to check package existence", http.StatusInternalServerError)
+			return
+		}
 
-	// Check if tag exists on package
-	var exists bool
-	err = db.Conn.QueryRow(ctx,
-		"SELECT EXISTS(SELECT 1 FROM package_tags WHERE package_id = $1 AND tag_id = $2)",
-		input.PackageID, input.TagID,
-	).Scan(&exists)
-	if err != nil {
-		logger.MainLogger.Printf("Failed to check tag existence for package %d, tag %d: %v", input.PackageID, input.TagID, err)
-		http.Error(w, "Failed to check tag existence", http.StatusInternalServerError)
-		return
-	}
-	if !exists {
-		http.Error(w, "Tag not found on package", http.StatusNotFound)
-		return
-	}
+		// Check if tag exists on package
+		var exists bool
+		err = pool.QueryRow(ctx,
+			"SELECT EXISTS(SELECT 1 FROM package_tags WHERE package_id = $1 AND tag_id = $2)",
+			input.PackageID, input.TagID,
+		).Scan(&exists)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to check tag existence for package %d, tag %d: %v", input.PackageID, input.TagID, err)
+			http.Error(w, "Failed to check tag existence", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Tag not found on package", http.StatusNotFound)
+			return
+		}
 
-	// All votes are worth their base value (1 or -1)
-	voteValue := input.Vote
+		rank, err := helpers.GetReputationRank(ctx, pool, authUser.UserID)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to get reputation rank for user %d: %v", authUser.UserID, err)
+			http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
 
-	if voteValue == 0 {
-		// Remove vote
-		_, err = db.Conn.Exec(ctx,
-			"DELETE FROM tag_votes WHERE package_id = $1 AND tag_id = $2 AND user_id = $3",
-			input.PackageID, input.TagID, authUser.UserID,
-		)
-	} else {
-		// Add or update vote
-		_, err = db.Conn.Exec(ctx, `
+		// Retracting a vote is always allowed
+		if input.Vote != 0 && !withinDailyVoteLimit(w, r, pool, authUser.UserID, rank) {
+			return
+		}
+
+		// Higher ranks and the package author cast heavier votes
+		voteValue := input.Vote * helpers.TagVoteWeight(rank, authorID == authUser.UserID)
+
+		if voteValue == 0 {
+			// Remove vote
+			_, err = pool.Exec(ctx,
+				"DELETE FROM tag_votes WHERE package_id = $1 AND tag_id = $2 AND user_id = $3",
+				input.PackageID, input.TagID, authUser.UserID,
+			)
+		} else {
+			// Add or update vote
+			_, err = pool.Exec(ctx, `
 				INSERT INTO tag_votes (package_id, tag_id, user_id, vote_value)
 				VALUES ($1, $2, $3, $4)
 				ON CONFLICT (package_id, tag_id, user_id) 
 				DO UPDATE SET vote_value = EXCLUDED.vote_value`,
-			input.PackageID, input.TagID, authUser.UserID, voteValue,
-		)
+				input.PackageID, input.TagID, authUser.UserID, voteValue,
+			)
+		}
+
+		if err != nil {
+			logger.MainLogger.Printf("Failed to update vote for package %d, tag %d, user %d: %v", input.PackageID, input.TagID, authUser.UserID, err)
+			http.Error(w, "Failed to update vote", http.StatusInternalServerError)
+			return
+		}
+
+		// Update package_tags score
+		newScore, undamped, err := updateTagScore(ctx, pool, input.PackageID, input.TagID)
+		if err != nil {
+			logger.MainLogger.Printf("%v", err)
+			http.Error(w, "Failed to update tag score", http.StatusInternalServerError)
+			return
+		}
+		helpers.RefreshAuthorReputationAsync(pool, input.PackageID)
+
+		// Remove the tag once every vote together scores <= 0. Ignoring a brigade's votes
+		// must not take a tag off that the rest of the votes keep.
+		removed := undamped <= 0
+		if removed {
+			removeTagFromPackage(ctx, pool, input.PackageID, input.TagID)
+			newScore = 0
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"vote":       input.Vote,
+			"vote_value": voteValue,
+			"net_score":  newScore,
+			"removed":    removed,
+		})
 	}
+}
 
+// withinDailyVoteLimit checks the voter's tag votes of the last 24 hours against the limit
+// of their rank, answering 429 once it is reached
+func withinDailyVoteLimit(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, userID int, rank string) bool {
+	var votes int
+	err := pool.QueryRow(r.Context(),
+		"SELECT COUNT(*) FROM tag_votes WHERE user_id = $1 AND updated_at > NOW() - INTERVAL '24 hours'",
+		userID,
+	).Scan(&votes)
 	if err != nil {
-		logger.MainLogger.Printf("Failed to update vote for package %d, tag %d, user %d: %v", input.PackageID, input.TagID, authUser.UserID, err)
-		http.Error(w, "Failed to update vote", http.StatusInternalServerError)
-		return
+		logger.MainLogger.Printf("Failed to count tag votes of user %d: %v", userID, err)
+		http.Error(w, "Failed to check vote limit", http.StatusInternalServerError)
+		return false
 	}
 
-	// Update package_tags score
-	newScore := updateTagScore(ctx, input.PackageID, input.TagID)
-
-	// If score is <= 0, remove the tag from the package
-	if newScore <= 0 {
-		removeTagFromPackage(ctx, input.PackageID, input.TagID)
-		newScore = 0 // Set to 0 to indicate removal
+	limit := helpers.DailyTagVoteLimit(rank)
+	if votes >= limit {
+		http.Error(w, fmt.Sprintf("Daily limit of %d tag votes reached", limit), http.StatusTooManyRequests)
+		return false
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"vote":       input.Vote,
-		"vote_value": voteValue,
-		"net_score":  newScore,
-		"removed":    newScore == 0,
-	})
+	return true
 }
 
-// Helper function to update tag score
-func updateTagScore(ctx context.Context, packageID, tagID int) int {
-	var score int
-	err := db.Conn.QueryRow(ctx, `
-		UPDATE package_tags 
-		SET score = (
-			SELECT COALESCE(SUM(vote_value), 0) 
-			FROM tag_votes 
-			WHERE package_id = $1 AND tag_id = $2
-		)
-		WHERE package_id = $1 AND tag_id = $2
-		RETURNING score`,
-		packageID, tagID,
-	).Scan(&score)
+// updateTagScore recomputes the stored score of a tag of a package from its votes and
+// returns it, along with the score of every vote counted
+func updateTagScore(ctx context.Context, pool *pgxpool.Pool, packageID, tagID int) (score, undamped int, err error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to update score for package %d, tag %d: %w", packageID, tagID, err)
+	}
+	defer tx.Rollback(ctx)
 
+	score, undamped, err = helpers.ScoreTag(ctx, tx, packageID, tagID)
 	if err != nil {
-		logger.MainLogger.Printf("Failed to update score for package %d, tag %d: %v", packageID, tagID, err)
-		return 0
+		return 0, 0, err
 	}
-	return score
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("failed to update score for package %d, tag %d: %w", packageID, tagID, err)
+	}
+	return score, undamped, nil
 }
 
 // Helper function to remove tag from package and clean up orphaned tags
-func removeTagFromPackage(ctx context.Context, packageID, tagID int) {
+func removeTagFromPackage(ctx context.Context, pool *pgxpool.Pool, packageID, tagID int) {
 	// Start a transaction
-	tx, err := db.Conn.Begin(ctx)
+	tx, err := pool.Begin(ctx)
 	if err != nil {
 		logger.MainLogger.Printf("Failed to start transaction for tag removal: %v", err)
 		return
@@ -250,19 +345,38 @@ func removeTagFromPackage(ctx context.Context, packageID, tagID int) {
 	}
 
 	// Remove the tag from the package
-	_, err = tx.Exec(ctx,
-		"DELETE FROM package_tags WHERE package_id = $1 AND tag_id = $2",
+	var before json.RawMessage
+	err = tx.QueryRow(ctx, `
+		DELETE FROM package_tags pt
+		USING tags t
+		WHERE t.id = pt.tag_id AND pt.package_id = $1 AND pt.tag_id = $2
+		RETURNING jsonb_build_object('package_id', pt.package_id, 'tag_id', pt.tag_id, 'tag', t.name, 'score', pt.score)`,
 		packageID, tagID,
-	)
+	).Scan(&before)
+	if err == pgx.ErrNoRows {
+		return
+	}
 	if err != nil {
 		logger.MainLogger.Printf("Failed to remove tag %d from package %d: %v", tagID, packageID, err)
 		return
 	}
 
+	err = audit.Record(ctx, tx, audit.Event{
+		Action:     audit.ActionTagRemove,
+		TargetType: audit.TargetPackageTag,
+		TargetID:   fmt.Sprintf("%d/%d", packageID, tagID),
+		Before:     before,
+	})
+	if err != nil {
+		logger.MainLogger.Printf("%v", err)
+		return
+	}
+
 	// Check if this tag is used by any other packages
 	var isUsed bool
-	err = tx.QueryRow(ctx,
-		"SELECT EXISTS(SELECT 1 FROM package_tags WHERE tag_id = $1)",
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM package_tags WHERE tag_id = $1)
+		    OR EXISTS(SELECT 1 FROM tags WHERE canonical_id = $1 OR (id = $1 AND category IS NOT NULL))`,
 		tagID,
 	).Scan(&isUsed)
 	if err != nil {
@@ -270,7 +384,8 @@ func removeTagFromPackage(ctx context.Context, packageID, tagID int) {
 		return
 	}
 
-	// If tag is not used by any other packages, delete it
+	// If tag is not used by any other packages, delete it. Curated tags (with a category
+	// or aliases) stay.
 	if !isUsed {
 		_, err = tx.Exec(ctx, "DELETE FROM tags WHERE id = $1", tagID)
 		if err != nil {
@@ -0,0 +1,56 @@
+package packages
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"opm/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RefreshTrending recomputes the package_trending materialized view without blocking
+// readers. The refresh is bounded by ctx rather than the statement timeout.
+func RefreshTrending(ctx context.Context, pool *pgxpool.Pool) error {
+	err := pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY package_trending")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refresh trending packages: %w", err)
+	}
+	return nil
+}
+
+// StartTrendingRefresh refreshes the trending scores every interval until ctx is done
+func StartTrendingRefresh(ctx context.Context, pool *pgxpool.Pool, interval string) error {
+	every, err := time.ParseDuration(interval)
+	if err != nil || every <= 0 {
+		return fmt.Errorf("invalid trending refresh interval %q", interval)
+	}
+
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+
+		for {
+			refreshCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			if err := RefreshTrending(refreshCtx, pool); err != nil && ctx.Err() == nil {
+				logger.MainLogger.Printf("%v", err)
+			}
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,256 @@
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"opm/helpers"
+	"opm/logger"
+	"opm/middleware"
+	"opm/models"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var commitSHARegex = regexp.MustCompile(`^[0-9a-f]{7,64}$`)
+
+const versionColumns = `id, package_id, version, tag_name, commit_sha, release_notes,
+		       yanked, yanked_at, published_by, published_at`
+
+// Stable releases sort above prereleases of the same version; prereleases sort by
+// semver precedence through their prerelease_key.
+const versionOrder = `major DESC, minor DESC, patch DESC, (prerelease = '') DESC, prerelease_key DESC, published_at DESC`
+
+// ListVersions returns all published versions of a package, newest first
+func ListVersions(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		vars := mux.Vars(r)
+		userSlug := vars["userSlug"]
+		pkgSlug := vars["pkgSlug"]
+
+		packageID, _, err := findPackageBySlugs(ctx, pool, userSlug, pkgSlug)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find package %s/%s: %v", userSlug, pkgSlug, err)
+			http.Error(w, "Failed to find package", http.StatusInternalServerError)
+			return
+		}
+
+		query := `SELECT ` + versionColumns + `
+		FROM package_versions
+		WHERE package_id = $1
+		ORDER BY ` + versionOrder
+
+		rows, err := pool.Query(ctx, query, packageID)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to fetch versions for package %d: %v", packageID, err)
+			http.Error(w, "Failed to fetch versions", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		versions := []models.PackageVersion{}
+		for rows.Next() {
+			v, err := scanVersion(rows)
+			if err != nil {
+				logger.MainLogger.Printf("Failed to scan version: %v", err)
+				continue
+			}
+			versions = append(versions, *v)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(versions)
+	}
+}
+
+// PublishVersion records a new release of a package (author only)
+func PublishVersion(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		vars := mux.Vars(r)
+		userSlug := vars["userSlug"]
+		pkgSlug := vars["pkgSlug"]
+
+		packageID, authorID, err := findPackageBySlugs(ctx, pool, userSlug, pkgSlug)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find package %s/%s: %v", userSlug, pkgSlug, err)
+			http.Error(w, "Failed to find package", http.StatusInternalServerError)
+			return
+		}
+
+		if authorID != authUser.UserID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var input models.PublishVersionInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		semver, err := helpers.ParseSemver(input.Version)
+		if err != nil {
+			http.Error(w, "Version must be a valid semantic version (e.g. 1.2.3)", http.StatusBadRequest)
+			return
+		}
+		// 1.2.3+a and 1.2.3+b are the same version, so build metadata can't tell releases apart
+		if semver.Build != "" {
+			http.Error(w, "Version must not have build metadata (+...)", http.StatusBadRequest)
+			return
+		}
+
+		commitSHA := strings.ToLower(strings.TrimSpace(input.CommitSHA))
+		if !commitSHARegex.MatchString(commitSHA) {
+			http.Error(w, "Commit SHA must be a hexadecimal git object id", http.StatusBadRequest)
+			return
+		}
+
+		tagName := "v" + semver.String()
+		if input.TagName != nil && strings.TrimSpace(*input.TagName) != "" {
+			tagName = strings.TrimSpace(*input.TagName)
+		}
+
+		query := `
+		INSERT INTO package_versions (package_id, version, major, minor, patch, prerelease, prerelease_key,
+		                              tag_name, commit_sha, release_notes, published_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING ` + versionColumns
+
+		version, err := scanVersion(pool.QueryRow(ctx, query,
+			packageID, semver.String(), semver.Major, semver.Minor, semver.Patch, semver.Prerelease, semver.PrereleaseKey(),
+			tagName, commitSHA, input.ReleaseNotes, authUser.UserID,
+		))
+		if err != nil {
+			if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+				http.Error(w, "Version or tag already published", http.StatusConflict)
+				return
+			}
+			logger.MainLogger.Printf("Failed to publish version %s for package %d: %v", input.Version, packageID, err)
+			http.Error(w, "Failed to publish version", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(version)
+	}
+}
+
+// YankVersion marks a published version as yanked, or restores it (author only)
+func YankVersion(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		vars := mux.Vars(r)
+		userSlug := vars["userSlug"]
+		pkgSlug := vars["pkgSlug"]
+
+		semver, err := helpers.ParseSemver(vars["version"])
+		if err != nil {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+		// Versions are published without build metadata
+		semver.Build = ""
+
+		packageID, authorID, err := findPackageBySlugs(ctx, pool, userSlug, pkgSlug)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find package %s/%s: %v", userSlug, pkgSlug, err)
+			http.Error(w, "Failed to find package", http.StatusInternalServerError)
+			return
+		}
+
+		if authorID != authUser.UserID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		input := struct {
+			Yanked bool `json:"yanked"`
+		}{Yanked: true}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+
+		query := `
+		UPDATE package_versions
+		SET yanked = $1, yanked_at = CASE WHEN $1 THEN CURRENT_TIMESTAMP ELSE NULL END
+		WHERE package_id = $2 AND version = $3
+		RETURNING ` + versionColumns
+
+		version, err := scanVersion(pool.QueryRow(ctx, query, input.Yanked, packageID, semver.String()))
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to yank version %s for package %d: %v", semver.String(), packageID, err)
+			http.Error(w, "Failed to update version", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(version)
+	}
+}
+
+// getLatestVersion returns the highest non-yanked version of a package, preferring
+// stable releases over prereleases. Returns nil if nothing has been published.
+func getLatestVersion(ctx context.Context, pool *pgxpool.Pool, packageID int) (*models.PackageVersion, error) {
+	query := `SELECT ` + versionColumns + `
+		FROM package_versions
+		WHERE package_id = $1 AND NOT yanked
+		ORDER BY (prerelease = '') DESC, ` + versionOrder + `
+		LIMIT 1`
+
+	v, err := scanVersion(pool.QueryRow(ctx, query, packageID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return v, err
+}
+
+func scanVersion(row pgx.Row) (*models.PackageVersion, error) {
+	var v models.PackageVersion
+	err := row.Scan(
+		&v.ID, &v.PackageID, &v.Version, &v.TagName, &v.CommitSHA, &v.ReleaseNotes,
+		&v.Yanked, &v.YankedAt, &v.PublishedBy, &v.PublishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
@@ -0,0 +1,359 @@
+package tags
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"opm/audit"
+	"opm/helpers"
+	"opm/logger"
+	"opm/middleware"
+	"opm/models"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// tagAuditState is the audited part of a tags row aliased t
+var tagAuditState = tagAuditStateOf("t")
+
+func tagAuditStateOf(alias string) string {
+	return fmt.Sprintf(`jsonb_build_object('name', %[1]s.name, 'category', %[1]s.category,
+		'canonical_id', %[1]s.canonical_id, 'usage_count', %[1]s.usage_count)`, alias)
+}
+
+// UpdateTag sets or clears the category of a tag (moderator only)
+func UpdateTag(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !requireModerator(w, r, pool) {
+			return
+		}
+
+		tagID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+			return
+		}
+
+		var input struct {
+			Category *models.TagCategory `json:"category"` // null clears the category
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if input.Category != nil && !input.Category.IsValid() {
+			http.Error(w, "Invalid tag category", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to start transaction for tag %d: %v", tagID, err)
+			http.Error(w, "Failed to update tag", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var before, after json.RawMessage
+		err = tx.QueryRow(ctx, `
+		UPDATE tags t SET category = $2
+		FROM tags old
+		WHERE t.id = $1 AND old.id = t.id AND t.canonical_id IS NULL
+		RETURNING `+tagAuditStateOf("old")+`, `+tagAuditState,
+			tagID, input.Category,
+		).Scan(&before, &after)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			err = audit.Record(ctx, tx, audit.Event{
+				Action:     audit.ActionTagUpdate,
+				TargetType: audit.TargetTag,
+				TargetID:   strconv.Itoa(tagID),
+				Before:     before,
+				After:      after,
+			})
+		}
+		if err == nil {
+			err = tx.Commit(ctx)
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to update tag %d: %v", tagID, err)
+			http.Error(w, "Failed to update tag", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":       tagID,
+			"category": input.Category,
+		})
+	}
+}
+
+// AddAlias makes another name resolve to a tag (moderator only). Names already used by a tag
+// have to be merged instead.
+func AddAlias(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !requireModerator(w, r, pool) {
+			return
+		}
+
+		tagID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+			return
+		}
+
+		var input struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		name, err := helpers.NormalizeTagName(input.Name)
+		if err != nil {
+			http.Error(w, "Invalid alias: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		existing, err := helpers.ResolveTag(ctx, pool, name)
+		if err == nil {
+			if existing.ID == tagID {
+				http.Error(w, "Alias already resolves to this tag", http.StatusConflict)
+			} else {
+				http.Error(w, "A tag named like this already exists; merge it instead", http.StatusConflict)
+			}
+			return
+		}
+		if err != pgx.ErrNoRows {
+			logger.MainLogger.Printf("Failed to resolve tag '%s': %v", name, err)
+			http.Error(w, "Failed to add alias", http.StatusInternalServerError)
+			return
+		}
+
+		authUser, _ := middleware.GetAuthUser(ctx)
+
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to start transaction for alias of tag %d: %v", tagID, err)
+			http.Error(w, "Failed to add alias", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var aliasID int
+		var after json.RawMessage
+		err = tx.QueryRow(ctx, `
+		INSERT INTO tags AS t (name, added_by, canonical_id)
+		SELECT $1, $2, id FROM tags WHERE id = $3 AND canonical_id IS NULL
+		RETURNING t.id, `+tagAuditState,
+			name, authUser.UserID, tagID,
+		).Scan(&aliasID, &after)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			err = audit.Record(ctx, tx, audit.Event{
+				Action:     audit.ActionTagAlias,
+				TargetType: audit.TargetTag,
+				TargetID:   strconv.Itoa(aliasID),
+				After:      after,
+			})
+		}
+		if err == nil {
+			err = tx.Commit(ctx)
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to add alias '%s' to tag %d: %v", name, tagID, err)
+			http.Error(w, "Failed to add alias", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":           aliasID,
+			"name":         name,
+			"canonical_id": tagID,
+		})
+	}
+}
+
+// MergeTag folds a tag into another one (moderator only). Its packages and votes move to the
+// target, where a voter voted on both the target's vote wins, and its name becomes an alias
+// of the target.
+func MergeTag(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !requireModerator(w, r, pool) {
+			return
+		}
+
+		sourceID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+			return
+		}
+
+		var input struct {
+			Into int `json:"into"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if input.Into == 0 || input.Into == sourceID {
+			http.Error(w, "into must be the ID of another tag", http.StatusBadRequest)
+			return
+		}
+		targetID := input.Into
+
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to start transaction for merging tag %d: %v", sourceID, err)
+			http.Error(w, "Failed to merge tags", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		// Lock both tags in id order so concurrent merges can't deadlock
+		rows, err := tx.Query(ctx, `
+		SELECT t.id, t.canonical_id IS NOT NULL, `+tagAuditState+`
+		FROM tags t WHERE t.id IN ($1, $2)
+		ORDER BY t.id FOR UPDATE`,
+			sourceID, targetID,
+		)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to lock tags %d and %d: %v", sourceID, targetID, err)
+			http.Error(w, "Failed to merge tags", http.StatusInternalServerError)
+			return
+		}
+		states := map[int]json.RawMessage{}
+		isAlias := false
+		for rows.Next() {
+			var id int
+			var alias bool
+			var state json.RawMessage
+			if err := rows.Scan(&id, &alias, &state); err != nil {
+				rows.Close()
+				logger.MainLogger.Printf("Failed to scan tag: %v", err)
+				http.Error(w, "Failed to merge tags", http.StatusInternalServerError)
+				return
+			}
+			states[id] = state
+			isAlias = isAlias || alias
+		}
+		rows.Close()
+		if len(states) != 2 {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return
+		}
+		if isAlias {
+			http.Error(w, "Aliases cannot be merged; merge their canonical tag", http.StatusBadRequest)
+			return
+		}
+
+		// Every package of the source ends up with the target and needs a fresh score
+		var packageIDs []int
+		err = tx.QueryRow(ctx, "SELECT COALESCE(array_agg(package_id), '{}') FROM package_tags WHERE tag_id = $1", sourceID).Scan(&packageIDs)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to list packages of tag %d: %v", sourceID, err)
+			http.Error(w, "Failed to merge tags", http.StatusInternalServerError)
+			return
+		}
+
+		statements := []string{
+			`INSERT INTO package_tags (package_id, tag_id, score, created_at)
+		 SELECT package_id, $2, score, created_at FROM package_tags WHERE tag_id = $1
+		 ON CONFLICT DO NOTHING`,
+			`INSERT INTO tag_votes (package_id, tag_id, user_id, vote_value, created_at, updated_at)
+		 SELECT package_id, $2, user_id, vote_value, created_at, updated_at FROM tag_votes WHERE tag_id = $1
+		 ON CONFLICT (package_id, tag_id, user_id) DO NOTHING`,
+			`DELETE FROM tag_votes WHERE tag_id = $1`,
+			`DELETE FROM package_tags WHERE tag_id = $1`,
+
+			// The source and its aliases now resolve to the target
+			`UPDATE tags SET canonical_id = $2, category = NULL WHERE id = $1 OR canonical_id = $1`,
+
+			// The package_tags trigger already moved the counts; recounting keeps them exact
+			`UPDATE tags t SET usage_count = (SELECT COUNT(*) FROM package_tags WHERE tag_id = t.id)
+		 WHERE t.id IN ($1, $2)`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(ctx, stmt, sourceID, targetID); err != nil {
+				logger.MainLogger.Printf("Failed to merge tag %d into %d: %v", sourceID, targetID, err)
+				http.Error(w, "Failed to merge tags", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Packages that had both tags get the combined votes, damped like any other vote
+		for _, packageID := range packageIDs {
+			if _, _, err := helpers.ScoreTag(ctx, tx, packageID, targetID); err != nil {
+				logger.MainLogger.Printf("Failed to rescore tag %d of package %d: %v", targetID, packageID, err)
+				http.Error(w, "Failed to merge tags", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		var after json.RawMessage
+		var usageCount int
+		err = tx.QueryRow(ctx, "SELECT "+tagAuditState+", t.usage_count FROM tags t WHERE t.id = $1", targetID).Scan(&after, &usageCount)
+		if err == nil {
+			err = audit.Record(ctx, tx, audit.Event{
+				Action:     audit.ActionTagMerge,
+				TargetType: audit.TargetTag,
+				TargetID:   strconv.Itoa(targetID),
+				Before:     map[string]json.RawMessage{"source": states[sourceID], "target": states[targetID]},
+				After:      after,
+			})
+		}
+		if err == nil {
+			err = tx.Commit(ctx)
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to merge tag %d into %d: %v", sourceID, targetID, err)
+			http.Error(w, "Failed to merge tags", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":          targetID,
+			"merged_id":   sourceID,
+			"usage_count": usageCount,
+		})
+	}
+}
+
+// requireModerator writes an error response and returns false unless the caller is a
+// moderator
+func requireModerator(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool) bool {
+	authUser, ok := middleware.GetAuthUser(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	isModerator, err := helpers.IsModerator(r.Context(), pool, authUser.UserID)
+	if err != nil {
+		logger.MainLogger.Printf("Failed to check moderator status for user %d: %v", authUser.UserID, err)
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return false
+	}
+	if !isModerator {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -1,66 +1,42 @@
 package tags
 
 import (
-	"encoding/json"
 	"net/http"
-	"opm/db"
+	"opm/helpers"
 	"opm/logger"
 	"opm/models"
-	"strconv"
+	"opm/store"
 )
 
 // List returns all tags or search for tags
-func List(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+func List(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 
-	// Get query parameters
-	search := r.URL.Query().Get("q")
-	limitStr := r.URL.Query().Get("limit")
-
-	limit := 50
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
-			limit = l
+		page, err := helpers.ParsePage(r, "usage", store.TagSortOrder, 50, 100)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-	}
-
-	query := `
-			SELECT id, name, usage_count, created_at
-			FROM tags
-			WHERE 1=1`
-
-	args := []interface{}{}
-	argIndex := 1
-
-	if search != "" {
-		query += " AND search_vector @@ plainto_tsquery('english', $" + strconv.Itoa(argIndex) + ")"
-		args = append(args, search)
-		argIndex++
-	}
-
-	query += " ORDER BY usage_count DESC, name"
-	query += " LIMIT $" + strconv.Itoa(argIndex)
-	args = append(args, limit)
 
-	rows, err := db.Conn.Query(ctx, query, args...)
-	if err != nil {
-		logger.MainLogger.Printf("Failed to fetch tags - Query: %s, Args: %v, Error: %v", query, args, err)
-		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
+		q := store.TagQuery{Search: r.URL.Query().Get("q"), Page: page}
+		if category := r.URL.Query().Get("category"); category != "" {
+			c := models.TagCategory(category)
+			if !c.IsValid() {
+				http.Error(w, "Invalid category parameter", http.StatusBadRequest)
+				return
+			}
+			q.Category = &c
+		}
 
-	tags := []models.Tag{}
-	for rows.Next() {
-		var t models.Tag
-		err := rows.Scan(&t.ID, &t.Name, &t.UsageCount, &t.CreatedAt)
+		result, err := s.Tags.List(ctx, q)
 		if err != nil {
-			logger.MainLogger.Printf("Failed to scan tag: %v", err)
-			continue
+			logger.MainLogger.Printf("Failed to fetch tags: %v", err)
+			http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+			return
 		}
-		tags = append(tags, t)
-	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(tags)
+		count, nextCursor := page.NextPage(result.Keys)
+		helpers.WritePage(w, r, result.Tags[:count], nextCursor, result.Total, nil)
+	}
 }
@@ -0,0 +1,116 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"opm/helpers"
+	"opm/logger"
+	"opm/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+// ListAuditEvents returns the moderation audit log, newest first (Moderator only).
+// Filters: actor_id, action, target_type, target_id, since, until (RFC 3339).
+func ListAuditEvents(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if _, ok := requireModerator(w, r, pool); !ok {
+			return
+		}
+
+		query := `
+		SELECT e.id, e.actor_id, u.username, e.action, e.target_type, e.target_id,
+		       e.before, e.after, e.request_id, e.created_at
+		FROM audit_events e
+		LEFT JOIN users u ON e.actor_id = u.id
+		WHERE 1=1`
+
+		args := []interface{}{}
+		argIndex := 1
+
+		if actorID, hasActor := helpers.OptionalParamInt(r, "actor_id"); hasActor {
+			query += fmt.Sprintf(" AND e.actor_id = $%d", argIndex)
+			args = append(args, *actorID)
+			argIndex++
+		}
+
+		for _, column := range []string{"action", "target_type", "target_id"} {
+			if value, has := helpers.OptionalParamString(r, column); has {
+				query += fmt.Sprintf(" AND e.%s = $%d", column, argIndex)
+				args = append(args, value)
+				argIndex++
+			}
+		}
+
+		for _, bound := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+			value, has := helpers.OptionalParamString(r, bound.param)
+			if !has {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "Invalid "+bound.param+" parameter, expected RFC 3339", http.StatusBadRequest)
+				return
+			}
+			query += fmt.Sprintf(" AND e.created_at %s $%d", bound.op, argIndex)
+			args = append(args, t)
+			argIndex++
+		}
+
+		limit := defaultAuditLimit
+		if l, hasLimit := helpers.OptionalParamInt(r, "limit"); hasLimit {
+			limit = *l
+		}
+		if limit < 1 || limit > maxAuditLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+		offset := 0
+		if o, hasOffset := helpers.OptionalParamInt(r, "offset"); hasOffset {
+			offset = *o
+		}
+		if offset < 0 {
+			http.Error(w, "offset must not be negative", http.StatusBadRequest)
+			return
+		}
+
+		query += fmt.Sprintf(" ORDER BY e.created_at DESC, e.id DESC LIMIT $%d OFFSET $%d", argIndex, argIndex+1)
+		args = append(args, limit, offset)
+
+		rows, err := pool.Query(ctx, query, args...)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to fetch audit events: %v", err)
+			http.Error(w, "Failed to fetch audit events", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		events := []models.AuditEvent{}
+		for rows.Next() {
+			var e models.AuditEvent
+			err := rows.Scan(&e.ID, &e.ActorID, &e.ActorUsername, &e.Action, &e.TargetType, &e.TargetID,
+				&e.Before, &e.After, &e.RequestID, &e.CreatedAt)
+			if err != nil {
+				logger.MainLogger.Printf("Failed to scan audit event: %v", err)
+				continue
+			}
+			events = append(events, e)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"events": events,
+			"limit":  limit,
+			"offset": offset,
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package users
+
+import (
+	"net/http"
+	"opm/helpers"
+	"opm/logger"
+	"opm/middleware"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UnlinkIdentity removes a linked GitHub or Discord identity from the authenticated user.
+// The last remaining identity can't be removed.
+func UnlinkIdentity(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		provider := mux.Vars(r)["provider"]
+
+		err := helpers.UnlinkIdentity(ctx, pool, authUser.UserID, provider)
+		switch err {
+		case nil:
+		case helpers.ErrUnknownProvider:
+			http.Error(w, "Unknown provider", http.StatusBadRequest)
+			return
+		case pgx.ErrNoRows:
+			http.Error(w, "Identity not linked", http.StatusNotFound)
+			return
+		case helpers.ErrLastIdentity:
+			http.Error(w, "Link another account before removing your only login", http.StatusConflict)
+			return
+		default:
+			logger.MainLogger.Printf("Failed to unlink %s from user %d: %v", provider, authUser.UserID, err)
+			http.Error(w, "Failed to unlink identity", http.StatusInternalServerError)
+			return
+		}
+
+		logger.SecurityLogger.Printf("User %d unlinked their %s identity", authUser.UserID, provider)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,264 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"opm/audit"
+	"opm/helpers"
+	"opm/logger"
+	"opm/middleware"
+	"opm/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	maxBanReasonLen       = 1000
+	maxSuspensionDuration = 365 * 24 // hours
+)
+
+// BanUser permanently bans a user (moderator only)
+// Body: reason, hide_packages
+func BanUser(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		applyBan(w, r, pool, "ban")
+	}
+}
+
+// SuspendUser bans a user until duration_hours from now (moderator only)
+// Body: reason, duration_hours, hide_packages
+func SuspendUser(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		applyBan(w, r, pool, "suspend")
+	}
+}
+
+// UnbanUser lifts a ban or suspension (moderator only)
+// Body: reason
+func UnbanUser(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		applyBan(w, r, pool, "unban")
+	}
+}
+
+// ListUserBans returns a user's ban history (moderator only)
+func ListUserBans(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if _, ok := requireModerator(w, r, pool); !ok {
+			return
+		}
+
+		userSlug := mux.Vars(r)["userSlug"]
+		var userID int
+		err := pool.QueryRow(ctx, "SELECT id FROM users WHERE slug = $1", userSlug).Scan(&userID)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find user %s: %v", userSlug, err)
+			http.Error(w, "Failed to find user", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := pool.Query(ctx, `
+		SELECT b.id, b.user_id, b.moderator_id, m.username, b.action, b.reason,
+		       b.expires_at, b.hide_packages, b.created_at
+		FROM user_bans b
+		LEFT JOIN users m ON b.moderator_id = m.id
+		WHERE b.user_id = $1
+		ORDER BY b.created_at DESC`,
+			userID,
+		)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to fetch bans for user %d: %v", userID, err)
+			http.Error(w, "Failed to fetch bans", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		bans := []models.UserBan{}
+		for rows.Next() {
+			var b models.UserBan
+			err := rows.Scan(&b.ID, &b.UserID, &b.ModeratorID, &b.ModeratorUsername, &b.Action, &b.Reason,
+				&b.ExpiresAt, &b.HidePackages, &b.CreatedAt)
+			if err != nil {
+				logger.MainLogger.Printf("Failed to scan ban: %v", err)
+				continue
+			}
+			bans = append(bans, b)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bans)
+	}
+}
+
+func applyBan(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, action string) {
+	ctx := r.Context()
+	moderatorID, ok := requireModerator(w, r, pool)
+	if !ok {
+		return
+	}
+
+	var input models.BanUserInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	reason := strings.TrimSpace(input.Reason)
+	if reason == "" {
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+	if len(reason) > maxBanReasonLen {
+		http.Error(w, fmt.Sprintf("Reason must be at most %d characters", maxBanReasonLen), http.StatusBadRequest)
+		return
+	}
+
+	var until *time.Time
+	if action == "suspend" {
+		if input.DurationHours < 1 || input.DurationHours > maxSuspensionDuration {
+			http.Error(w, fmt.Sprintf("duration_hours must be between 1 and %d", maxSuspensionDuration), http.StatusBadRequest)
+			return
+		}
+		t := time.Now().Add(time.Duration(input.DurationHours) * time.Hour)
+		until = &t
+	}
+
+	userSlug := mux.Vars(r)["userSlug"]
+	var userID int
+	var targetIsModerator bool
+	err := pool.QueryRow(ctx,
+		"SELECT id, is_moderator FROM users WHERE slug = $1",
+		userSlug,
+	).Scan(&userID, &targetIsModerator)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.MainLogger.Printf("Failed to find user %s: %v", userSlug, err)
+		http.Error(w, "Failed to find user", http.StatusInternalServerError)
+		return
+	}
+	if action != "unban" && (userID == moderatorID || targetIsModerator) {
+		http.Error(w, "Moderators cannot be banned", http.StatusForbidden)
+		return
+	}
+
+	hidePackages := input.HidePackages && action != "unban"
+	if err := recordBan(ctx, pool, userID, moderatorID, action, reason, until, hidePackages); err != nil {
+		logger.MainLogger.Printf("Failed to %s user %d: %v", action, userID, err)
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+
+	logger.SecurityLogger.Printf("Moderator %d applied %s to user %d: %s", moderatorID, action, userID, reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"action":       action,
+		"banned_until": until,
+	})
+}
+
+// banAuditState is the ban-related part of a user row as recorded in the audit log
+const banAuditState = `jsonb_build_object('is_banned', is_banned, 'banned_until', banned_until,
+	'ban_reason', ban_reason, 'packages_hidden', packages_hidden)`
+
+var banAuditActions = map[string]string{
+	"ban":     audit.ActionUserBan,
+	"suspend": audit.ActionUserSuspend,
+	"unban":   audit.ActionUserUnban,
+}
+
+// recordBan updates the user's ban state and appends to the history in one transaction.
+// Banning revokes the user's sessions through the users_ban_revoke_sessions trigger.
+func recordBan(ctx context.Context, pool *pgxpool.Pool, userID, moderatorID int, action, reason string, until *time.Time, hidePackages bool) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var before json.RawMessage
+	err = tx.QueryRow(ctx, "SELECT "+banAuditState+" FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&before)
+	if err != nil {
+		return err
+	}
+
+	var after json.RawMessage
+	if action == "unban" {
+		err = tx.QueryRow(ctx, `
+			UPDATE users SET is_banned = FALSE, banned_until = NULL, ban_reason = NULL, packages_hidden = FALSE
+			WHERE id = $1
+			RETURNING `+banAuditState,
+			userID,
+		).Scan(&after)
+	} else {
+		err = tx.QueryRow(ctx, `
+			UPDATE users SET is_banned = TRUE, banned_until = $2, ban_reason = $3, packages_hidden = $4
+			WHERE id = $1
+			RETURNING `+banAuditState,
+			userID, until, reason, hidePackages,
+		).Scan(&after)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_bans (user_id, moderator_id, action, reason, expires_at, hide_packages)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		userID, moderatorID, action, reason, until, hidePackages,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = audit.Record(ctx, tx, audit.Event{
+		ActorID:    moderatorID,
+		Action:     banAuditActions[action],
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.Itoa(userID),
+		Before:     before,
+		After:      after,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// requireModerator writes an error and returns false unless the caller is a moderator
+func requireModerator(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool) (int, bool) {
+	authUser, ok := middleware.GetAuthUser(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	isModerator, err := helpers.IsModerator(r.Context(), pool, authUser.UserID)
+	if err != nil {
+		logger.MainLogger.Printf("Failed to check moderator status for user %d: %v", authUser.UserID, err)
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return 0, false
+	}
+	if !isModerator {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	return authUser.UserID, true
+}
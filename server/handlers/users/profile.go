@@ -2,16 +2,13 @@ package users
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"opm/db"
 	"opm/logger"
 	"opm/middleware"
 	"opm/models"
+	"opm/store"
 	"regexp"
 	"strings"
-
-	"github.com/jackc/pgx/v5"
 )
 
 var slugRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-_]*[a-z0-9]$`)
@@ -27,177 +24,153 @@ func isValidSlug(slug string) bool {
 }
 
 // UpdateProfile updates the authenticated user's profile
-func UpdateProfile(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	authUser, ok := middleware.GetAuthUser(ctx)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	var input models.UpdateUserInput
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Build update query dynamically
-	updateFields := []string{}
-	args := []interface{}{}
-	argIndex := 1
-
-	// Validate and update slug
-	if input.Slug != nil {
-		// Validate slug format
-		slug := strings.TrimSpace(*input.Slug)
-		if slug == "" {
-			http.Error(w, "Slug cannot be empty", http.StatusBadRequest)
+func UpdateProfile(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		if len(slug) < 3 || len(slug) > 50 {
-			http.Error(w, "Slug must be between 3 and 50 characters", http.StatusBadRequest)
+
+		var input models.UpdateUserInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
-		// Check if slug matches required pattern (lowercase letters, numbers, hyphens, underscores)
-		// Must be URL-safe without encoding
-		if !isValidSlug(slug) {
-			http.Error(w, "Slug must contain only lowercase letters, numbers, hyphens, and underscores", http.StatusBadRequest)
-			return
+
+		// The validated, trimmed fields to update
+		update := models.UpdateUserInput{}
+
+		// Validate and update slug
+		if input.Slug != nil {
+			// Validate slug format
+			slug := strings.TrimSpace(*input.Slug)
+			if slug == "" {
+				http.Error(w, "Slug cannot be empty", http.StatusBadRequest)
+				return
+			}
+			if len(slug) < 3 || len(slug) > 50 {
+				http.Error(w, "Slug must be between 3 and 50 characters", http.StatusBadRequest)
+				return
+			}
+			// Check if slug matches required pattern (lowercase letters, numbers, hyphens, underscores)
+			// Must be URL-safe without encoding
+			if !isValidSlug(slug) {
+				http.Error(w, "Slug must contain only lowercase letters, numbers, hyphens, and underscores", http.StatusBadRequest)
+				return
+			}
+
+			// Check if slug is already taken by another user
+			taken, err := s.Users.SlugTaken(ctx, slug, authUser.UserID)
+			if err != nil {
+				logger.MainLogger.Printf("Failed to check slug availability for user %d: %v", authUser.UserID, err)
+				http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+				return
+			}
+			if taken {
+				http.Error(w, "Slug is already taken", http.StatusConflict)
+				return
+			}
+			update.Slug = &slug
 		}
 
-		// Check if slug is already taken by another user
-		var existingUserID int
-		err := db.Conn.QueryRow(ctx,
-			"SELECT id FROM users WHERE slug = $1 AND id != $2",
-			slug, authUser.UserID,
-		).Scan(&existingUserID)
-		if err != pgx.ErrNoRows {
-			http.Error(w, "Slug is already taken", http.StatusConflict)
-			return
+		// Update display name
+		if input.DisplayName != nil {
+			displayName := strings.TrimSpace(*input.DisplayName)
+			if len(displayName) > 255 {
+				http.Error(w, "Display name must be less than 255 characters", http.StatusBadRequest)
+				return
+			}
+			update.DisplayName = &displayName
 		}
 
-		updateFields = append(updateFields, fmt.Sprintf("slug = $%d", argIndex))
-		args = append(args, slug)
-		argIndex++
-	}
+		// Update avatar URL
+		if input.AvatarURL != nil {
+			avatarURL := strings.TrimSpace(*input.AvatarURL)
+			if avatarURL != "" && !strings.HasPrefix(avatarURL, "http") {
+				http.Error(w, "Avatar URL must be a valid URL", http.StatusBadRequest)
+				return
+			}
+			update.AvatarURL = &avatarURL
+		}
 
-	// Update display name
-	if input.DisplayName != nil {
-		displayName := strings.TrimSpace(*input.DisplayName)
-		if len(displayName) > 255 {
-			http.Error(w, "Display name must be less than 255 characters", http.StatusBadRequest)
+		if update.Slug == nil && update.DisplayName == nil && update.AvatarURL == nil {
+			http.Error(w, "No fields to update", http.StatusBadRequest)
 			return
 		}
-		updateFields = append(updateFields, fmt.Sprintf("display_name = $%d", argIndex))
-		args = append(args, displayName)
-		argIndex++
-	}
 
-	// Update avatar URL
-	if input.AvatarURL != nil {
-		avatarURL := strings.TrimSpace(*input.AvatarURL)
-		if avatarURL != "" && !strings.HasPrefix(avatarURL, "http") {
-			http.Error(w, "Avatar URL must be a valid URL", http.StatusBadRequest)
+		if err := s.Users.UpdateProfile(ctx, authUser.UserID, update); err != nil {
+			logger.MainLogger.Printf("Failed to update profile for user %d: %v", authUser.UserID, err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
 			return
 		}
-		updateFields = append(updateFields, fmt.Sprintf("avatar_url = $%d", argIndex))
-		args = append(args, avatarURL)
-		argIndex++
-	}
 
-	if len(updateFields) == 0 {
-		http.Error(w, "No fields to update", http.StatusBadRequest)
-		return
+		// Return success response
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Profile updated successfully",
+		})
 	}
+}
 
-	// Add user ID as last argument
-	args = append(args, authUser.UserID)
+// CheckSlugAvailability checks if an slug is available
+func CheckSlugAvailability(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		slug := r.URL.Query().Get("slug")
 
-	query := fmt.Sprintf(
-		"UPDATE users SET %s, updated_at = CURRENT_TIMESTAMP WHERE id = $%d",
-		strings.Join(updateFields, ", "),
-		argIndex,
-	)
+		if slug == "" {
+			http.Error(w, "Slug parameter is required", http.StatusBadRequest)
+			return
+		}
 
-	_, err := db.Conn.Exec(ctx, query, args...)
-	if err != nil {
-		logger.MainLogger.Printf("Failed to update profile for user %d: %v", authUser.UserID, err)
-		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
-		return
-	}
+		// Validate slug format
+		if !isValidSlug(slug) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"available": false,
+				"reason":    "Invalid format. Use lowercase letters, numbers, hyphens, and underscores. Must start and end with a letter or number.",
+			})
+			return
+		}
 
-	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Profile updated successfully",
-	})
-}
+		// Check if slug is too short or too long
+		if len(slug) < 3 || len(slug) > 50 {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"available": false,
+				"reason":    "Slug must be between 3 and 50 characters",
+			})
+			return
+		}
 
-// CheckSlugAvailability checks if an slug is available
-func CheckSlugAvailability(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	slug := r.URL.Query().Get("slug")
+		// Get current user ID if authenticated, whose own slug counts as available
+		var currentUserID int
+		if authUser, ok := middleware.GetAuthUser(ctx); ok {
+			currentUserID = authUser.UserID
+		}
 
-	if slug == "" {
-		http.Error(w, "Slug parameter is required", http.StatusBadRequest)
-		return
-	}
+		taken, err := s.Users.SlugTaken(ctx, slug, currentUserID)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to check slug availability of %s: %v", slug, err)
+			http.Error(w, "Failed to check slug availability", http.StatusInternalServerError)
+			return
+		}
 
-	// Validate slug format
-	if !isValidSlug(slug) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"available": false,
-			"reason":    "Invalid format. Use lowercase letters, numbers, hyphens, and underscores. Must start and end with a letter or number.",
-		})
-		return
-	}
+		if !taken {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"available": true,
+			})
+			return
+		}
 
-	// Check if slug is too short or too long
-	if len(slug) < 3 || len(slug) > 50 {
-		w.Header().Set("Content-Type", "application/json")
+		// Slug is taken
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"available": false,
-			"reason":    "Slug must be between 3 and 50 characters",
-		})
-		return
-	}
-
-	// Get current user ID if authenticated
-	var currentUserID int
-	if authUser, ok := middleware.GetAuthUser(ctx); ok {
-		currentUserID = authUser.UserID
-	}
-
-	// Check if slug exists (excluding current user)
-	var existingID int
-	var query string
-	var args []interface{}
-	
-	if currentUserID > 0 {
-		query = "SELECT id FROM users WHERE slug = $1 AND id != $2"
-		args = []interface{}{slug, currentUserID}
-	} else {
-		query = "SELECT id FROM users WHERE slug = $1"
-		args = []interface{}{slug}
-	}
-	
-	err := db.Conn.QueryRow(ctx, query, args...).Scan(&existingID)
-	
-	if err == pgx.ErrNoRows {
-		// Slug is available
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"available": true,
+			"reason":    "This slug is already taken",
 		})
-		return
 	}
-
-	// Slug is taken
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"available": false,
-		"reason":    "This slug is already taken",
-	})
 }
@@ -0,0 +1,67 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"opm/middleware"
+	"opm/models"
+	"opm/store"
+)
+
+// request builds a request made by userID, anonymous when it is 0
+func request(method, target, body string, userID int) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if userID != 0 {
+		r = r.WithContext(middleware.WithAuthUser(r.Context(), &models.AuthUser{UserID: userID}))
+	}
+	return r
+}
+
+func TestUpdateProfile(t *testing.T) {
+	mem := store.NewMemory()
+	stores := mem.Store()
+	ada := mem.AddUser(models.User{Username: "ada", Slug: "ada"})
+	mem.AddUser(models.User{Username: "bob", Slug: "bob"})
+
+	tests := []struct {
+		name   string
+		userID int
+		body   string
+		status int
+	}{
+		{"anonymous", 0, `{"slug": "ada-l"}`, http.StatusUnauthorized},
+		{"no fields", ada, `{}`, http.StatusBadRequest},
+		{"invalid slug", ada, `{"slug": "Ada L"}`, http.StatusBadRequest},
+		{"taken slug", ada, `{"slug": "bob"}`, http.StatusConflict},
+		{"own slug", ada, `{"slug": "ada"}`, http.StatusOK},
+		{"new slug", ada, `{"slug": " ada-l ", "display_name": " Ada "}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			UpdateProfile(stores)(w, request("PUT", "/users/me", tt.body, tt.userID))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d (body %q)", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+
+	// The trimmed slug is now taken for everyone but ada
+	for userID, available := range map[int]bool{0: false, ada: true} {
+		w := httptest.NewRecorder()
+		CheckSlugAvailability(stores)(w, request("GET", "/users/check-user-slug?slug=ada-l", "", userID))
+		var resp struct {
+			Available bool `json:"available"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Available != available {
+			t.Errorf("ada-l available to user %d = %v, want %v", userID, resp.Available, available)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"opm/helpers"
+	"opm/logger"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// GetReputation returns how a user's reputation adds up, refreshing the stored reputation
+// and rank on the way
+func GetReputation(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userSlug := mux.Vars(r)["userSlug"]
+
+		var userID int
+		err := pool.QueryRow(ctx, "SELECT id FROM users WHERE slug = $1", userSlug).Scan(&userID)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find user %s: %v", userSlug, err)
+			http.Error(w, "Failed to find user", http.StatusInternalServerError)
+			return
+		}
+
+		breakdown, err := helpers.RefreshReputation(ctx, pool, userID)
+		if err != nil {
+			logger.MainLogger.Printf("%v", err)
+			http.Error(w, "Failed to compute reputation", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(breakdown)
+	}
+}
@@ -0,0 +1,120 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"opm/logger"
+	"opm/middleware"
+	"opm/models"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ListSessions returns the authenticated user's active sessions, marking the current one
+func ListSessions(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		rows, err := pool.Query(ctx, `
+		SELECT id, user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC`,
+			authUser.UserID,
+		)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to fetch sessions for user %d: %v", authUser.UserID, err)
+			http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		sessions := []models.Session{}
+		for rows.Next() {
+			var s models.Session
+			if err := rows.Scan(&s.ID, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+				logger.MainLogger.Printf("Failed to scan session: %v", err)
+				continue
+			}
+			s.Current = s.ID == authUser.SessionID
+			sessions = append(sessions, s)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+	}
+}
+
+// RevokeSessions logs the user out everywhere
+// Params: keep_current (true to stay logged in on this device)
+func RevokeSessions(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		keepCurrent := ""
+		if r.URL.Query().Get("keep_current") == "true" {
+			keepCurrent = authUser.SessionID
+		}
+
+		result, err := pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL AND id <> $2`,
+			authUser.UserID, keepCurrent,
+		)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to revoke sessions for user %d: %v", authUser.UserID, err)
+			http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+
+		logger.SecurityLogger.Printf("User %d revoked %d sessions", authUser.UserID, result.RowsAffected())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"revoked": result.RowsAffected(),
+		})
+	}
+}
+
+// RevokeSession logs out a single session
+func RevokeSession(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		sessionID := mux.Vars(r)["id"]
+
+		result, err := pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+			sessionID, authUser.UserID,
+		)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to revoke session for user %d: %v", authUser.UserID, err)
+			http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+		if result.RowsAffected() == 0 {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
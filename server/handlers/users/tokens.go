@@ -0,0 +1,273 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"opm/helpers"
+	"opm/logger"
+	"opm/middleware"
+	"opm/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	maxAPITokensPerUser = 25
+	maxAPITokenNameLen  = 100
+	maxAPITokenLifetime = 365 // days
+	apiTokenColumns     = `id, name, token_prefix, scopes, last_used_at, expires_at, created_at`
+)
+
+// ListTokens returns the authenticated user's active API tokens
+func ListTokens(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		rows, err := pool.Query(ctx, `SELECT `+apiTokenColumns+`
+		FROM api_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`,
+			authUser.UserID,
+		)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to fetch API tokens for user %d: %v", authUser.UserID, err)
+			http.Error(w, "Failed to fetch tokens", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		tokens := []models.APIToken{}
+		for rows.Next() {
+			token, err := scanAPIToken(rows)
+			if err != nil {
+				logger.MainLogger.Printf("Failed to scan API token: %v", err)
+				continue
+			}
+			tokens = append(tokens, *token)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokens)
+	}
+}
+
+// CreateToken creates an API token. The token is only included in this response.
+func CreateToken(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var input models.CreateAPITokenInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		name, msg := validateTokenName(input.Name)
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		if len(input.Scopes) == 0 {
+			http.Error(w, "At least one scope is required", http.StatusBadRequest)
+			return
+		}
+		scopes := []string{}
+		for _, scope := range input.Scopes {
+			if !isValidScope(scope) {
+				http.Error(w, fmt.Sprintf("Unknown scope %q; valid scopes are %s", scope, strings.Join(models.APITokenScopes, ", ")), http.StatusBadRequest)
+				return
+			}
+			if !containsString(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		var expiresAt *time.Time
+		if input.ExpiresInDays != nil {
+			days := *input.ExpiresInDays
+			if days < 1 || days > maxAPITokenLifetime {
+				http.Error(w, fmt.Sprintf("expires_in_days must be between 1 and %d", maxAPITokenLifetime), http.StatusBadRequest)
+				return
+			}
+			t := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+			expiresAt = &t
+		}
+
+		var count int
+		err := pool.QueryRow(ctx,
+			"SELECT COUNT(*) FROM api_tokens WHERE user_id = $1 AND revoked_at IS NULL",
+			authUser.UserID,
+		).Scan(&count)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to count API tokens for user %d: %v", authUser.UserID, err)
+			http.Error(w, "Failed to create token", http.StatusInternalServerError)
+			return
+		}
+		if count >= maxAPITokensPerUser {
+			http.Error(w, fmt.Sprintf("You can have at most %d active tokens", maxAPITokensPerUser), http.StatusConflict)
+			return
+		}
+
+		plaintext, prefix, err := helpers.GenerateAPIToken()
+		if err != nil {
+			logger.MainLogger.Printf("Failed to generate API token: %v", err)
+			http.Error(w, "Failed to create token", http.StatusInternalServerError)
+			return
+		}
+
+		token, err := scanAPIToken(pool.QueryRow(ctx, `
+		INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+apiTokenColumns,
+			authUser.UserID, name, middleware.HashAPIToken(plaintext), prefix, scopes, expiresAt,
+		))
+		if err != nil {
+			logger.MainLogger.Printf("Failed to create API token for user %d: %v", authUser.UserID, err)
+			http.Error(w, "Failed to create token", http.StatusInternalServerError)
+			return
+		}
+		token.Token = plaintext
+
+		logger.SecurityLogger.Printf("User %d created API token %d with scopes %v", authUser.UserID, token.ID, scopes)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(token)
+	}
+}
+
+// UpdateToken renames an API token
+func UpdateToken(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		tokenID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid token ID", http.StatusBadRequest)
+			return
+		}
+
+		var input models.UpdateAPITokenInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		name, msg := validateTokenName(input.Name)
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		token, err := scanAPIToken(pool.QueryRow(ctx, `
+		UPDATE api_tokens SET name = $1
+		WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+		RETURNING `+apiTokenColumns,
+			name, tokenID, authUser.UserID,
+		))
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.MainLogger.Printf("Failed to rename API token %d: %v", tokenID, err)
+			http.Error(w, "Failed to update token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(token)
+	}
+}
+
+// RevokeToken revokes an API token; it stops working immediately
+func RevokeToken(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser, ok := middleware.GetAuthUser(ctx)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		tokenID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid token ID", http.StatusBadRequest)
+			return
+		}
+
+		result, err := pool.Exec(ctx, `
+		UPDATE api_tokens SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+			tokenID, authUser.UserID,
+		)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to revoke API token %d: %v", tokenID, err)
+			http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+			return
+		}
+		if result.RowsAffected() == 0 {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+
+		logger.SecurityLogger.Printf("User %d revoked API token %d", authUser.UserID, tokenID)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func validateTokenName(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", "Token name is required"
+	}
+	if len(name) > maxAPITokenNameLen {
+		return "", fmt.Sprintf("Token name must be at most %d characters", maxAPITokenNameLen)
+	}
+	return name, ""
+}
+
+func isValidScope(scope string) bool {
+	return containsString(models.APITokenScopes, scope)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func scanAPIToken(row pgx.Row) (*models.APIToken, error) {
+	var t models.APIToken
+	err := row.Scan(&t.ID, &t.Name, &t.TokenPrefix, &t.Scopes, &t.LastUsedAt, &t.ExpiresAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,21 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"opm/middleware"
+)
+
+// apiTokenPrefixLength is how much of a token is kept in clear text for display
+const apiTokenPrefixLength = 12
+
+// GenerateAPIToken creates a new personal API token, returning the token and its display prefix
+func GenerateAPIToken() (token string, prefix string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = middleware.APITokenPrefix + hex.EncodeToString(b)
+	return token, token[:apiTokenPrefixLength], nil
+}
@@ -2,30 +2,140 @@ package helpers
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
 	"time"
 )
 
-// GenerateState creates a secure state token for OAuth flows
-func GenerateState(secret string) string {
-	// Create a unique state with timestamp
-	timestamp := time.Now().Unix()
-	data := fmt.Sprintf("state-%d", timestamp)
-	
-	// Create HMAC signature
+// StateTTL is how long a user has to complete an OAuth flow
+const StateTTL = 10 * time.Minute
+
+var (
+	ErrStateInvalid  = errors.New("state is malformed or its signature does not match")
+	ErrStateExpired  = errors.New("state has expired")
+	ErrStateMismatch = errors.New("state does not belong to this browser")
+	ErrStateReplayed = errors.New("state has already been used")
+)
+
+// now is swapped out by tests
+var now = time.Now
+
+// OAuthState is what an OAuth flow carries from the login redirect to the callback
+type OAuthState struct {
+	ReturnPath string `json:"r,omitempty"` // Frontend path to land on after login
+	LinkUserID int    `json:"l,omitempty"` // Set when attaching the identity to an existing account
+	Merge      bool   `json:"m,omitempty"` // Merge the account that already owns the identity into LinkUserID
+}
+
+// statePayload is the signed part of an OAuth state token
+type statePayload struct {
+	OAuthState
+	Nonce     string `json:"n"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// GenerateState creates a signed OAuth state token. The returned nonce must be stored in a
+// short-lived cookie and passed back to ValidateState, binding the flow to this browser.
+func GenerateState(secret string, st OAuthState) (state string, nonce string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	nonce = base64.RawURLEncoding.EncodeToString(b)
+
+	st.ReturnPath = SanitizeReturnPath(st.ReturnPath)
+	payload, err := json.Marshal(statePayload{
+		OAuthState: st,
+		Nonce:      nonce,
+		ExpiresAt:  now().Add(StateTTL).Unix(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	data := base64.RawURLEncoding.EncodeToString(payload)
+	return data + "." + signState(data, secret), nonce, nil
+}
+
+// ValidateState verifies the signature, expiry and nonce of a state token, marks it used and
+// returns what the flow carries.
+func ValidateState(state, nonce, secret string) (*OAuthState, error) {
+	data, signature, found := strings.Cut(state, ".")
+	if !found || data == "" || signature == "" {
+		return nil, ErrStateInvalid
+	}
+	if !hmac.Equal([]byte(signature), []byte(signState(data, secret))) {
+		return nil, ErrStateInvalid
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, ErrStateInvalid
+	}
+	var payload statePayload
+	if err := json.Unmarshal(raw, &payload); err != nil || payload.Nonce == "" {
+		return nil, ErrStateInvalid
+	}
+
+	expiresAt := time.Unix(payload.ExpiresAt, 0)
+	if !now().Before(expiresAt) {
+		return nil, ErrStateExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(payload.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrStateMismatch
+	}
+	if !usedStates.consume(payload.Nonce, expiresAt) {
+		return nil, ErrStateReplayed
+	}
+
+	payload.ReturnPath = SanitizeReturnPath(payload.ReturnPath)
+	return &payload.OAuthState, nil
+}
+
+// SanitizeReturnPath only allows same-site absolute paths, so the state can't be used as an
+// open redirect. Anything else becomes "".
+func SanitizeReturnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.ContainsAny(path, "\\\r\n") {
+		return ""
+	}
+	return path
+}
+
+func signState(data, secret string) string {
 	h := hmac.New(sha256.New, []byte(secret))
 	h.Write([]byte(data))
-	signature := base64.URLEncoding.EncodeToString(h.Sum(nil))
-	
-	// Combine data and signature
-	return fmt.Sprintf("%s.%s", data, signature)
-}
-
-// ValidateState verifies the state token
-func ValidateState(state, secret string) bool {
-	// For now, just check it's not empty
-	// TODO: Implement proper validation with HMAC
-	return state != ""
-}
\ No newline at end of file
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// stateNonces remembers consumed nonces until their state would have expired anyway
+type stateNonces struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+var usedStates = &stateNonces{used: map[string]time.Time{}}
+
+// consume records nonce as used, returning false if it already was
+func (s *stateNonces) consume(nonce string, expiresAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	for n, exp := range s.used {
+		if !current.Before(exp) {
+			delete(s.used, n)
+		}
+	}
+
+	if _, ok := s.used[nonce]; ok {
+		return false
+	}
+	s.used[nonce] = expiresAt
+	return true
+}
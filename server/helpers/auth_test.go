@@ -0,0 +1,182 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+func TestValidateStateRoundTrip(t *testing.T) {
+	state, nonce, err := GenerateState(testSecret, OAuthState{ReturnPath: "/packages/new?draft=1"})
+	if err != nil {
+		t.Fatalf("GenerateState: %v", err)
+	}
+
+	st, err := ValidateState(state, nonce, testSecret)
+	if err != nil {
+		t.Fatalf("ValidateState: %v", err)
+	}
+	if st.ReturnPath != "/packages/new?draft=1" {
+		t.Errorf("return path = %q, want %q", st.ReturnPath, "/packages/new?draft=1")
+	}
+	if st.LinkUserID != 0 || st.Merge {
+		t.Errorf("login state carries link intent: %+v", st)
+	}
+}
+
+func TestValidateStateCarriesLinkIntent(t *testing.T) {
+	state, nonce, err := GenerateState(testSecret, OAuthState{LinkUserID: 42, Merge: true, ReturnPath: "//evil.example"})
+	if err != nil {
+		t.Fatalf("GenerateState: %v", err)
+	}
+
+	st, err := ValidateState(state, nonce, testSecret)
+	if err != nil {
+		t.Fatalf("ValidateState: %v", err)
+	}
+	if st.LinkUserID != 42 || !st.Merge {
+		t.Errorf("state = %+v, want LinkUserID 42 with Merge", st)
+	}
+	if st.ReturnPath != "" {
+		t.Errorf("unsafe return path survived: %q", st.ReturnPath)
+	}
+}
+
+func TestGenerateStateUsesFreshNonces(t *testing.T) {
+	state1, nonce1, _ := GenerateState(testSecret, OAuthState{})
+	state2, nonce2, _ := GenerateState(testSecret, OAuthState{})
+	if nonce1 == nonce2 || state1 == state2 {
+		t.Fatal("two states share a nonce")
+	}
+}
+
+func TestValidateStateRejectsTampering(t *testing.T) {
+	state, nonce, err := GenerateState(testSecret, OAuthState{ReturnPath: "/"})
+	if err != nil {
+		t.Fatalf("GenerateState: %v", err)
+	}
+	data, signature, _ := strings.Cut(state, ".")
+
+	// Re-encode the payload with a different return path but keep the old signature
+	raw, _ := base64.RawURLEncoding.DecodeString(data)
+	forged := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(raw), `"r":"/"`, `"r":"/admin"`, 1)))
+
+	// Flip the last character of the signature
+	last := signature[len(signature)-1]
+	flipped := byte('A')
+	if last == 'A' {
+		flipped = 'B'
+	}
+
+	cases := map[string]string{
+		"empty":             "",
+		"no signature":      data,
+		"garbage":           "not-a-state",
+		"modified payload":  forged + "." + signature,
+		"modified sig":      data + "." + signature[:len(signature)-1] + string(flipped),
+		"signature swapped": signature + "." + data,
+	}
+	for name, tampered := range cases {
+		if _, err := ValidateState(tampered, nonce, testSecret); err != ErrStateInvalid {
+			t.Errorf("%s: err = %v, want ErrStateInvalid", name, err)
+		}
+	}
+
+	if _, err := ValidateState(state, nonce, "other-secret"); err != ErrStateInvalid {
+		t.Errorf("wrong secret: err = %v, want ErrStateInvalid", err)
+	}
+
+	// The untouched state is still usable after the failed attempts
+	if _, err := ValidateState(state, nonce, testSecret); err != nil {
+		t.Errorf("original state: %v", err)
+	}
+}
+
+func TestValidateStateRejectsWrongNonce(t *testing.T) {
+	state, _, err := GenerateState(testSecret, OAuthState{})
+	if err != nil {
+		t.Fatalf("GenerateState: %v", err)
+	}
+	_, otherNonce, _ := GenerateState(testSecret, OAuthState{})
+
+	if _, err := ValidateState(state, otherNonce, testSecret); err != ErrStateMismatch {
+		t.Errorf("other nonce: err = %v, want ErrStateMismatch", err)
+	}
+	if _, err := ValidateState(state, "", testSecret); err != ErrStateMismatch {
+		t.Errorf("missing nonce: err = %v, want ErrStateMismatch", err)
+	}
+}
+
+func TestValidateStateExpiry(t *testing.T) {
+	start := time.Now()
+	now = func() time.Time { return start }
+	defer func() { now = time.Now }()
+
+	state, nonce, err := GenerateState(testSecret, OAuthState{})
+	if err != nil {
+		t.Fatalf("GenerateState: %v", err)
+	}
+
+	now = func() time.Time { return start.Add(StateTTL + time.Second) }
+	if _, err := ValidateState(state, nonce, testSecret); err != ErrStateExpired {
+		t.Fatalf("expired state: err = %v, want ErrStateExpired", err)
+	}
+
+	now = func() time.Time { return start.Add(StateTTL - time.Minute) }
+	if _, err := ValidateState(state, nonce, testSecret); err != nil {
+		t.Fatalf("state within TTL: %v", err)
+	}
+}
+
+func TestValidateStateIsSingleUse(t *testing.T) {
+	state, nonce, err := GenerateState(testSecret, OAuthState{})
+	if err != nil {
+		t.Fatalf("GenerateState: %v", err)
+	}
+
+	if _, err := ValidateState(state, nonce, testSecret); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := ValidateState(state, nonce, testSecret); err != ErrStateReplayed {
+		t.Fatalf("second use: err = %v, want ErrStateReplayed", err)
+	}
+}
+
+func TestUsedStatesForgetExpiredNonces(t *testing.T) {
+	start := time.Now()
+	now = func() time.Time { return start }
+	defer func() { now = time.Now }()
+
+	nonces := &stateNonces{used: map[string]time.Time{}}
+	if !nonces.consume("a", start.Add(time.Minute)) {
+		t.Fatal("first consume of a failed")
+	}
+
+	now = func() time.Time { return start.Add(2 * time.Minute) }
+	nonces.consume("b", start.Add(3*time.Minute))
+	if _, ok := nonces.used["a"]; ok {
+		t.Error("expired nonce was not swept")
+	}
+}
+
+func TestSanitizeReturnPath(t *testing.T) {
+	cases := map[string]string{
+		"":                       "",
+		"/":                      "/",
+		"/packages/foo":          "/packages/foo",
+		"/search?q=raylib":       "/search?q=raylib",
+		"//evil.example":         "",
+		"/\\evil.example":        "",
+		"https://evil.example":   "",
+		"packages/foo":           "",
+		"/ok\r\nSet-Cookie: x=y": "",
+	}
+	for in, want := range cases {
+		if got := SanitizeReturnPath(in); got != want {
+			t.Errorf("SanitizeReturnPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"opm/audit"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrUnknownProvider    = errors.New("unknown identity provider")
+	ErrIdentityInUse      = errors.New("identity belongs to another account")
+	ErrProviderLinked     = errors.New("a different identity of this provider is already linked")
+	ErrLastIdentity       = errors.New("cannot remove the only identity of an account")
+	ErrMergeConflict      = errors.New("both accounts have an identity for the same provider")
+	ErrMergeBannedAccount = errors.New("cannot merge a banned account")
+	ErrMergeSameAccount   = errors.New("cannot merge an account into itself")
+)
+
+// providerColumn maps a provider to its users column; it is never built from user input
+func providerColumn(provider string) (string, error) {
+	switch provider {
+	case "github":
+		return "github_id", nil
+	case "discord":
+		return "discord_id", nil
+	default:
+		return "", ErrUnknownProvider
+	}
+}
+
+// FindUserByIdentity returns the user owning a provider identity
+func FindUserByIdentity(ctx context.Context, pool *pgxpool.Pool, provider, providerID string) (int, error) {
+	column, err := providerColumn(provider)
+	if err != nil {
+		return 0, err
+	}
+	var userID int
+	err = pool.QueryRow(ctx, "SELECT id FROM users WHERE "+column+" = $1", providerID).Scan(&userID)
+	return userID, err
+}
+
+// LinkIdentity attaches a provider identity to userID. Linking an identity the user already
+// has is a no-op.
+func LinkIdentity(ctx context.Context, pool *pgxpool.Pool, userID int, provider, providerID string) error {
+	column, err := providerColumn(provider)
+	if err != nil {
+		return err
+	}
+
+	ownerID, err := FindUserByIdentity(ctx, pool, provider, providerID)
+	if err == nil {
+		if ownerID == userID {
+			return nil
+		}
+		return ErrIdentityInUse
+	}
+	if err != pgx.ErrNoRows {
+		return fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	result, err := pool.Exec(ctx,
+		"UPDATE users SET "+column+" = $1 WHERE id = $2 AND "+column+" IS NULL",
+		providerID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrProviderLinked
+	}
+	return nil
+}
+
+// UnlinkIdentity removes a provider identity from userID, refusing to remove the last one
+func UnlinkIdentity(ctx context.Context, pool *pgxpool.Pool, userID int, provider string) error {
+	column, err := providerColumn(provider)
+	if err != nil {
+		return err
+	}
+
+	// users_has_oauth would reject this too; checking first gives a clear error
+	result, err := pool.Exec(ctx, `
+		UPDATE users SET `+column+` = NULL
+		WHERE id = $1 AND `+column+` IS NOT NULL
+		  AND (CASE WHEN github_id IS NOT NULL THEN 1 ELSE 0 END +
+		       CASE WHEN discord_id IS NOT NULL THEN 1 ELSE 0 END) > 1`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to unlink identity: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		var linked bool
+		err := pool.QueryRow(ctx, "SELECT "+column+" IS NOT NULL FROM users WHERE id = $1", userID).Scan(&linked)
+		if err != nil {
+			return fmt.Errorf("failed to check identity: %w", err)
+		}
+		if !linked {
+			return pgx.ErrNoRows
+		}
+		return ErrLastIdentity
+	}
+	return nil
+}
+
+// MergeUsers moves everything owned by mergeID (packages, bookmarks, votes, flags, views, ban
+// history and identities) onto keepID and deletes mergeID, recording the merge in the audit log
+func MergeUsers(ctx context.Context, pool *pgxpool.Pool, keepID, mergeID int) error {
+	if keepID == mergeID {
+		return ErrMergeSameAccount
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	type account struct {
+		githubID, discordID             *string
+		isModerator, isBanned           bool
+		githubVerified, discordVerified bool
+		reputation                      int
+	}
+	load := func(id int) (*account, error) {
+		var a account
+		err := tx.QueryRow(ctx, `
+			SELECT github_id, discord_id, is_moderator, is_banned,
+			       COALESCE(github_verified, FALSE), COALESCE(discord_verified, FALSE), reputation
+			FROM users WHERE id = $1 FOR UPDATE`,
+			id,
+		).Scan(&a.githubID, &a.discordID, &a.isModerator, &a.isBanned, &a.githubVerified, &a.discordVerified, &a.reputation)
+		return &a, err
+	}
+
+	keep, err := load(keepID)
+	if err != nil {
+		return err
+	}
+	merge, err := load(mergeID)
+	if err != nil {
+		return err
+	}
+	if merge.isBanned {
+		return ErrMergeBannedAccount
+	}
+	if (keep.githubID != nil && merge.githubID != nil) || (keep.discordID != nil && merge.discordID != nil) {
+		return ErrMergeConflict
+	}
+
+	// For the audit event, and the tags whose score changes with the merged votes
+	var packageIDs []int
+	err = tx.QueryRow(ctx, "SELECT COALESCE(array_agg(id ORDER BY id), '{}') FROM packages WHERE author_id = $1", mergeID).Scan(&packageIDs)
+	if err != nil {
+		return fmt.Errorf("failed to list packages of user %d: %w", mergeID, err)
+	}
+	rows, err := tx.Query(ctx, "SELECT package_id, tag_id FROM tag_votes WHERE user_id = $1", mergeID)
+	if err != nil {
+		return fmt.Errorf("failed to list tag votes of user %d: %w", mergeID, err)
+	}
+	votedTags, err := pgx.CollectRows(rows, pgx.RowToStructByPos[struct{ PackageID, TagID int }])
+	if err != nil {
+		return fmt.Errorf("failed to list tag votes of user %d: %w", mergeID, err)
+	}
+
+	statements := []string{
+		`UPDATE packages SET author_id = $1 WHERE author_id = $2`,
+		`UPDATE package_versions SET published_by = $1 WHERE published_by = $2`,
+		`UPDATE tags SET added_by = $1 WHERE added_by = $2`,
+
+		`INSERT INTO bookmarks (user_id, package_id, created_at)
+		 SELECT $1, package_id, created_at FROM bookmarks WHERE user_id = $2
+		 ON CONFLICT DO NOTHING`,
+		`DELETE FROM bookmarks WHERE user_id = $2`,
+
+		// Where both accounts voted on the same tag, the surviving account's vote wins
+		`UPDATE tag_votes v SET user_id = $1
+		 WHERE v.user_id = $2 AND NOT EXISTS (
+		     SELECT 1 FROM tag_votes k
+		     WHERE k.user_id = $1 AND k.package_id = v.package_id AND k.tag_id = v.tag_id)`,
+		`DELETE FROM tag_votes WHERE user_id = $2`,
+
+		`UPDATE flags SET user_id = $1 WHERE user_id = $2`,
+		`UPDATE flags SET resolved_by = $1 WHERE resolved_by = $2`,
+
+		`UPDATE package_views v SET user_id = $1
+		 WHERE v.user_id = $2 AND NOT EXISTS (
+		     SELECT 1 FROM package_views k
+		     WHERE k.user_id = $1 AND k.package_id = v.package_id AND k.viewed_at = v.viewed_at)`,
+		`DELETE FROM package_views WHERE user_id = $2`,
+
+		`UPDATE user_bans SET user_id = $1 WHERE user_id = $2`,
+		`UPDATE user_bans SET moderator_id = $1 WHERE moderator_id = $2`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(ctx, stmt, keepID, mergeID); err != nil {
+			return fmt.Errorf("failed to merge user %d into %d: %w", mergeID, keepID, err)
+		}
+	}
+
+	// Sessions and API tokens of the merged account go with it
+	if _, err := tx.Exec(ctx, "DELETE FROM users WHERE id = $1", mergeID); err != nil {
+		return fmt.Errorf("failed to delete merged user %d: %w", mergeID, err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET
+			github_id = COALESCE(github_id, $2),
+			discord_id = COALESCE(discord_id, $3),
+			is_moderator = is_moderator OR $4,
+			github_verified = COALESCE(github_verified, FALSE) OR $5,
+			discord_verified = COALESCE(discord_verified, FALSE) OR $6,
+			reputation = reputation + $7
+		WHERE id = $1`,
+		keepID, merge.githubID, merge.discordID, merge.isModerator, merge.githubVerified, merge.discordVerified, merge.reputation,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to move identities to user %d: %w", keepID, err)
+	}
+
+	// Dropped duplicate votes and the votes' new account age both change scores
+	for _, t := range votedTags {
+		if _, _, err := ScoreTag(ctx, tx, t.PackageID, t.TagID); err != nil {
+			return err
+		}
+	}
+
+	err = audit.Record(ctx, tx, audit.Event{
+		Action:     audit.ActionUserMerge,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.Itoa(keepID),
+		Before: map[string]interface{}{
+			"merged_user_id": mergeID,
+			"github_id":      merge.githubID,
+			"discord_id":     merge.discordID,
+			"is_moderator":   merge.isModerator,
+			"package_ids":    packageIDs,
+		},
+		After: map[string]interface{}{
+			"user_id":      keepID,
+			"is_moderator": keep.isModerator || merge.isModerator,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	// The sum above is only an estimate until the moved activity is tallied again
+	RefreshReputationAsync(pool, keepID)
+	return nil
+}
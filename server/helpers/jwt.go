@@ -7,16 +7,17 @@ import (
 	"opm/middleware"
 )
 
-// GenerateJWT creates a new JWT token for a user
-func GenerateJWT(userID int, secret string) (string, error) {
+// GenerateJWT creates a new JWT token for a user, identified by the session ID as its jti
+func GenerateJWT(userID int, sessionID string, secret string) (string, error) {
 	claims := &middleware.Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)), // 7 days
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(SessionTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(secret))
-}
\ No newline at end of file
+}
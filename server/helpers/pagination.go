@@ -0,0 +1,241 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidLimit  = errors.New("limit is out of range")
+	ErrInvalidCursor = errors.New("cursor is malformed or belongs to another sort order")
+)
+
+// SortColumn is one key of a keyset sort order. Expr must be selectable in the paginated
+// query and Type is its SQL type, used to cast cursor values back.
+type SortColumn struct {
+	Expr string
+	Type string
+	Desc bool
+}
+
+// Page is a parsed limit/cursor pair for one sort order. Queries select KeyColumn, filter
+// with KeysetCondition, order with OrderBy and fetch FetchLimit rows; NextPage then decides
+// whether there is a next page.
+type Page struct {
+	Limit   int
+	sort    string
+	columns []SortColumn
+	after   []string // Sort key of the last row of the previous page, nil on the first page
+}
+
+// pageCursor is what an opaque cursor encodes
+type pageCursor struct {
+	Sort string   `json:"s"`
+	Key  []string `json:"k"`
+}
+
+// ParsePage reads the limit and cursor parameters for a listing sorted by columns, which
+// must end with a unique column so rows never tie. sort names the order the cursor is
+// tied to.
+func ParsePage(r *http.Request, sort string, columns []SortColumn, defaultLimit, maxLimit int) (*Page, error) {
+	page := &Page{Limit: defaultLimit, sort: sort, columns: columns}
+
+	if limit, hasLimit := OptionalParamInt(r, "limit"); hasLimit {
+		if *limit < 1 || *limit > maxLimit {
+			return nil, fmt.Errorf("%w: must be between 1 and %d", ErrInvalidLimit, maxLimit)
+		}
+		page.Limit = *limit
+	}
+
+	if cursor, hasCursor := OptionalParamString(r, "cursor"); hasCursor && cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		var c pageCursor
+		if err := json.Unmarshal(raw, &c); err != nil || c.Sort != sort || len(c.Key) != len(columns) {
+			return nil, ErrInvalidCursor
+		}
+		for i, v := range c.Key {
+			if !validKeyValue(columns[i].Type, v) {
+				return nil, ErrInvalidCursor
+			}
+		}
+		page.after = c.Key
+	}
+	return page, nil
+}
+
+// keyTimeLayouts are the timestamp formats of a TIMESTAMPTZ cast to TEXT, by PostgreSQL
+// with the ISO DateStyle and by the memory store
+var keyTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	time.RFC3339Nano,
+}
+
+// validKeyValue reports whether a cursor value casts to sqlType, so a tampered cursor is
+// rejected before it reaches the query
+func validKeyValue(sqlType, v string) bool {
+	var err error
+	switch sqlType {
+	case "INTEGER":
+		_, err = strconv.ParseInt(v, 10, 32)
+	case "BIGINT":
+		_, err = strconv.ParseInt(v, 10, 64)
+	case "REAL":
+		_, err = strconv.ParseFloat(v, 32)
+	case "DOUBLE PRECISION":
+		_, err = strconv.ParseFloat(v, 64)
+	case "TIMESTAMPTZ":
+		for _, layout := range keyTimeLayouts {
+			if _, err = time.Parse(layout, v); err == nil {
+				break
+			}
+		}
+	default:
+		// PostgreSQL text can't hold NUL characters
+		return !strings.ContainsRune(v, 0)
+	}
+	return err == nil
+}
+
+// After returns the sort key of the last row of the previous page, or nil on the first page
+func (p *Page) After() []string {
+	return p.after
+}
+
+// Columns returns the sort order of the page
+func (p *Page) Columns() []SortColumn {
+	return p.columns
+}
+
+// KeyColumn selects a row's sort key, to be scanned into a []string and passed to NextPage
+func (p *Page) KeyColumn() string {
+	exprs := make([]string, len(p.columns))
+	for i, c := range p.columns {
+		exprs[i] = "(" + c.Expr + ")::TEXT"
+	}
+	return "ARRAY[" + strings.Join(exprs, ", ") + "]"
+}
+
+// OrderBy returns the ORDER BY clause, without the keywords
+func (p *Page) OrderBy() string {
+	terms := make([]string, len(p.columns))
+	for i, c := range p.columns {
+		terms[i] = c.Expr + " ASC"
+		if c.Desc {
+			terms[i] = c.Expr + " DESC"
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+// KeysetCondition restricts a query to rows after the cursor, numbering its parameters from
+// argIndex. It returns "TRUE" and no arguments on the first page.
+func (p *Page) KeysetCondition(argIndex int) (string, []interface{}) {
+	if p.after == nil {
+		return "TRUE", nil
+	}
+
+	args := make([]interface{}, len(p.after))
+	values := make([]string, len(p.after))
+	for i, v := range p.after {
+		args[i] = v
+		values[i] = fmt.Sprintf("$%d::TEXT::%s", argIndex+i, p.columns[i].Type)
+	}
+
+	// (a, b, c) after (x, y, z) is a > x OR (a = x AND (b > y OR (b = y AND c > z))),
+	// with < for descending columns
+	condition := ""
+	for i := len(p.columns) - 1; i >= 0; i-- {
+		op := ">"
+		if p.columns[i].Desc {
+			op = "<"
+		}
+		term := fmt.Sprintf("%s %s %s", p.columns[i].Expr, op, values[i])
+		if condition != "" {
+			term = fmt.Sprintf("%s OR (%s = %s AND (%s))", term, p.columns[i].Expr, values[i], condition)
+		}
+		condition = term
+	}
+	return "(" + condition + ")", args
+}
+
+// FetchLimit is how many rows to fetch: one more than the page holds, to tell whether there
+// is a next page
+func (p *Page) FetchLimit() int {
+	return p.Limit + 1
+}
+
+// NextPage returns how many of the fetched rows belong on this page and the cursor of the
+// next page, or nil on the last page. keys are the KeyColumn values of the fetched rows.
+func (p *Page) NextPage(keys [][]string) (int, *string) {
+	if len(keys) <= p.Limit {
+		return len(keys), nil
+	}
+
+	raw, err := json.Marshal(pageCursor{Sort: p.sort, Key: keys[p.Limit-1]})
+	if err != nil {
+		return p.Limit, nil
+	}
+	cursor := base64.RawURLEncoding.EncodeToString(raw)
+	return p.Limit, &cursor
+}
+
+// PageEnvelope is the response of a paginated listing
+type PageEnvelope struct {
+	Items      interface{} `json:"items"`
+	NextCursor *string     `json:"next_cursor"`
+	Total      int         `json:"total"`
+}
+
+// SetPageLinks sets the RFC 5988 Link header pointing to the first and, if there is one,
+// the next page of the current request
+func SetPageLinks(w http.ResponseWriter, r *http.Request, nextCursor *string) {
+	link := func(cursor *string, rel string) string {
+		u := *r.URL
+		q := u.Query()
+		q.Del("cursor")
+		if cursor != nil {
+			q.Set("cursor", *cursor)
+		}
+		u.RawQuery = q.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+
+	links := []string{link(nil, "first")}
+	if nextCursor != nil {
+		links = append(links, link(nextCursor, "next"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// WritePage writes a page of items in the PageEnvelope along with its Link header. extra
+// adds fields next to the envelope's, such as facets.
+func WritePage(w http.ResponseWriter, r *http.Request, items interface{}, nextCursor *string, total int, extra map[string]interface{}) {
+	SetPageLinks(w, r, nextCursor)
+	w.Header().Set("Content-Type", "application/json")
+
+	envelope := PageEnvelope{Items: items, NextCursor: nextCursor, Total: total}
+	if len(extra) == 0 {
+		json.NewEncoder(w).Encode(envelope)
+		return
+	}
+
+	body := map[string]interface{}{
+		"items":       envelope.Items,
+		"next_cursor": envelope.NextCursor,
+		"total":       envelope.Total,
+	}
+	for k, v := range extra {
+		body[k] = v
+	}
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,51 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParsePageValidatesCursorKeys(t *testing.T) {
+	columns := []SortColumn{
+		{Expr: "p.created_at", Type: "TIMESTAMPTZ", Desc: true},
+		{Expr: "p.score", Type: "REAL", Desc: true},
+		{Expr: "p.id", Type: "INTEGER", Desc: true},
+	}
+	cursor := func(key ...string) string {
+		raw, err := json.Marshal(pageCursor{Sort: "newest", Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		key   []string
+		valid bool
+	}{
+		{[]string{"2026-01-01 12:00:00.123456+00", "1.5", "42"}, true},
+		{[]string{"2026-01-01 12:00:00+05:30", "0", "1"}, true},
+		{[]string{"2026-01-01T12:00:00.5Z", "-3", "7"}, true},
+		{[]string{"yesterday", "1.5", "42"}, false},
+		{[]string{"2026-01-01 12:00:00+00", "high", "42"}, false},
+		{[]string{"2026-01-01 12:00:00+00", "1.5", "4.2"}, false},
+		{[]string{"2026-01-01 12:00:00+00", "1.5", "2147483648"}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/?cursor="+url.QueryEscape(cursor(tt.key...)), nil)
+		page, err := ParsePage(r, "newest", columns, 10, 100)
+		if tt.valid && err != nil {
+			t.Errorf("key %q: %v", tt.key, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("key %q: err = %v, want %v", tt.key, err, ErrInvalidCursor)
+		}
+		if tt.valid && err == nil && len(page.After()) != len(columns) {
+			t.Errorf("key %q: after = %q", tt.key, page.After())
+		}
+	}
+}
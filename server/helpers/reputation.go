@@ -0,0 +1,222 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"opm/logger"
+	"opm/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Reputation ranks, lowest first
+const (
+	RankNewbie      = "newbie"
+	RankMember      = "member"
+	RankContributor = "contributor"
+	RankTrusted     = "trusted"
+)
+
+// ReputationRanks maps each rank to the reputation it starts at, lowest first
+var ReputationRanks = []struct {
+	Name      string
+	MinPoints int
+}{
+	{RankNewbie, 0},
+	{RankMember, 25},
+	{RankContributor, 100},
+	{RankTrusted, 500},
+}
+
+// Points awarded or deducted per event. Only events caused by other users count.
+const (
+	pointsPerBookmark    = 5   // One of the user's packages was bookmarked
+	pointsPerTagVote     = 1   // Per up- or downvote on a tag of one of the user's packages
+	pointsPerUpheldFlag  = 10  // A flag the user filed was resolved by a moderator
+	pointsPerDismissed   = -5  // A flag the user filed was dismissed
+	pointsPerFlagAgainst = -20 // A flag against one of the user's packages was upheld
+	viewsPerPoint        = 100 // Package views needed for one point
+	maxPointsFromViews   = 200
+)
+
+// reputationSourcesQuery counts each source of reputation for user $1. Views are the
+// daily ones in package_views, less the author's own; anonymous views count.
+const reputationSourcesQuery = `
+	SELECT
+		(SELECT COUNT(*) FROM bookmarks b JOIN packages p ON b.package_id = p.id
+		 WHERE p.author_id = $1 AND b.user_id <> $1),
+		(SELECT COALESCE(SUM(SIGN(v.vote_value)), 0)::BIGINT FROM tag_votes v JOIN packages p ON v.package_id = p.id
+		 WHERE p.author_id = $1 AND v.user_id <> $1),
+		(SELECT COUNT(*) FROM flags WHERE user_id = $1 AND status = 'resolved'),
+		(SELECT COUNT(*) FROM flags WHERE user_id = $1 AND status = 'dismissed'),
+		(SELECT COUNT(*) FROM flags f JOIN packages p ON f.package_id = p.id
+		 WHERE p.author_id = $1 AND f.status = 'resolved'),
+		(SELECT COUNT(*) FROM package_views v JOIN packages p ON v.package_id = p.id
+		 WHERE p.author_id = $1 AND v.user_id IS DISTINCT FROM $1)`
+
+// RankFor returns the rank reached with points
+func RankFor(points int) string {
+	rank := RankNewbie
+	for _, r := range ReputationRanks {
+		if points >= r.MinPoints {
+			rank = r.Name
+		}
+	}
+	return rank
+}
+
+// RankAtLeast reports whether rank is min or higher. Unknown ranks count as the lowest.
+func RankAtLeast(rank, min string) bool {
+	return rankIndex(rank) >= rankIndex(min)
+}
+
+func rankIndex(rank string) int {
+	for i, r := range ReputationRanks {
+		if r.Name == rank {
+			return i
+		}
+	}
+	return 0
+}
+
+// ComputeReputation tallies a user's reputation from bookmarks, tag votes, flags and views
+func ComputeReputation(ctx context.Context, pool *pgxpool.Pool, userID int) (*models.ReputationBreakdown, error) {
+	var bookmarks, tagVotes, upheld, dismissed, flaggedAgainst, views int
+	err := pool.QueryRow(ctx, reputationSourcesQuery, userID).Scan(
+		&bookmarks, &tagVotes, &upheld, &dismissed, &flaggedAgainst, &views,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute reputation for user %d: %w", userID, err)
+	}
+
+	viewPoints := views / viewsPerPoint
+	if viewPoints > maxPointsFromViews {
+		viewPoints = maxPointsFromViews
+	}
+
+	sources := []models.ReputationSource{
+		{Source: "bookmarks_received", Count: bookmarks, Points: bookmarks * pointsPerBookmark},
+		{Source: "tag_votes_received", Count: tagVotes, Points: tagVotes * pointsPerTagVote},
+		{Source: "flags_upheld", Count: upheld, Points: upheld * pointsPerUpheldFlag},
+		{Source: "flags_dismissed", Count: dismissed, Points: dismissed * pointsPerDismissed},
+		{Source: "flags_against_packages", Count: flaggedAgainst, Points: flaggedAgainst * pointsPerFlagAgainst},
+		{Source: "package_views", Count: views, Points: viewPoints},
+	}
+
+	breakdown := &models.ReputationBreakdown{UserID: userID, Sources: sources}
+	for _, s := range sources {
+		breakdown.Reputation += s.Points
+	}
+	if breakdown.Reputation < 0 {
+		breakdown.Reputation = 0
+	}
+	breakdown.Rank = RankFor(breakdown.Reputation)
+	if i := rankIndex(breakdown.Rank); i+1 < len(ReputationRanks) {
+		next := ReputationRanks[i+1]
+		breakdown.NextRank = &next.Name
+		breakdown.NextRankAt = &next.MinPoints
+	}
+	return breakdown, nil
+}
+
+// RefreshReputation recomputes a user's reputation and stores it along with the rank
+func RefreshReputation(ctx context.Context, pool *pgxpool.Pool, userID int) (*models.ReputationBreakdown, error) {
+	breakdown, err := ComputeReputation(ctx, pool, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = pool.Exec(ctx,
+		"UPDATE users SET reputation = $2, reputation_rank = $3 WHERE id = $1 AND (reputation <> $2 OR reputation_rank <> $3)",
+		userID, breakdown.Reputation, breakdown.Rank,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store reputation for user %d: %w", userID, err)
+	}
+	return breakdown, nil
+}
+
+// RefreshReputationAsync refreshes reputations in the background, after the request that
+// changed them has been answered
+func RefreshReputationAsync(pool *pgxpool.Pool, userIDs ...int) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		for _, userID := range userIDs {
+			if _, err := RefreshReputation(ctx, pool, userID); err != nil {
+				logger.MainLogger.Printf("%v", err)
+			}
+		}
+	}()
+}
+
+// RefreshAuthorReputationAsync refreshes the reputation of a package's author in the
+// background
+func RefreshAuthorReputationAsync(pool *pgxpool.Pool, packageID int) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var authorID int
+		err := pool.QueryRow(ctx, "SELECT author_id FROM packages WHERE id = $1", packageID).Scan(&authorID)
+		if err != nil {
+			logger.MainLogger.Printf("Failed to find author of package %d: %v", packageID, err)
+			return
+		}
+		if _, err := RefreshReputation(ctx, pool, authorID); err != nil {
+			logger.MainLogger.Printf("%v", err)
+		}
+	}()
+}
+
+// GetReputationRank returns a user's stored rank
+func GetReputationRank(ctx context.Context, pool *pgxpool.Pool, userID int) (string, error) {
+	var rank string
+	err := pool.QueryRow(ctx, "SELECT reputation_rank FROM users WHERE id = $1", userID).Scan(&rank)
+	return rank, err
+}
+
+// MaxTagVoteWeight is the heaviest a single tag vote can be, matching the tag_votes CHECK
+const MaxTagVoteWeight = 10
+
+// authorTagVoteWeight is the least an author's vote on their own package weighs, since they
+// know best what it is about
+const authorTagVoteWeight = 4
+
+// TagVoteWeight is how much a user's tag vote counts, by rank and whether they wrote the
+// package
+func TagVoteWeight(rank string, isAuthor bool) int {
+	var weight int
+	switch {
+	case RankAtLeast(rank, RankTrusted):
+		weight = 6
+	case RankAtLeast(rank, RankContributor):
+		weight = 4
+	case RankAtLeast(rank, RankMember):
+		weight = 2
+	default:
+		weight = 1
+	}
+	if isAuthor && weight < authorTagVoteWeight {
+		weight = authorTagVoteWeight
+	}
+	if weight > MaxTagVoteWeight {
+		weight = MaxTagVoteWeight
+	}
+	return weight
+}
+
+// DailyTagVoteLimit is how many tag votes a user may cast per 24 hours, by rank
+func DailyTagVoteLimit(rank string) int {
+	switch {
+	case RankAtLeast(rank, RankContributor):
+		return 200
+	case RankAtLeast(rank, RankMember):
+		return 60
+	default:
+		return 20
+	}
+}
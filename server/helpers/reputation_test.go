@@ -0,0 +1,91 @@
+package helpers
+
+import "testing"
+
+func TestRankFor(t *testing.T) {
+	tests := []struct {
+		points int
+		want   string
+	}{
+		{-10, RankNewbie},
+		{0, RankNewbie},
+		{24, RankNewbie},
+		{25, RankMember},
+		{99, RankMember},
+		{100, RankContributor},
+		{499, RankContributor},
+		{500, RankTrusted},
+		{100000, RankTrusted},
+	}
+	for _, tt := range tests {
+		if got := RankFor(tt.points); got != tt.want {
+			t.Errorf("RankFor(%d) = %s, want %s", tt.points, got, tt.want)
+		}
+	}
+}
+
+func TestRankAtLeast(t *testing.T) {
+	tests := []struct {
+		rank, min string
+		want      bool
+	}{
+		{RankTrusted, RankContributor, true},
+		{RankMember, RankMember, true},
+		{RankMember, RankContributor, false},
+		{RankNewbie, RankNewbie, true},
+		// Unknown ranks are the lowest
+		{"legend", RankMember, false},
+		{"legend", RankNewbie, true},
+	}
+	for _, tt := range tests {
+		if got := RankAtLeast(tt.rank, tt.min); got != tt.want {
+			t.Errorf("RankAtLeast(%s, %s) = %v, want %v", tt.rank, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestTagVoteWeight(t *testing.T) {
+	tests := []struct {
+		rank     string
+		isAuthor bool
+		want     int
+	}{
+		{RankNewbie, false, 1},
+		{RankMember, false, 2},
+		{RankContributor, false, 4},
+		{RankTrusted, false, 6},
+		{"", false, 1},
+		// Authors weigh at least authorTagVoteWeight on their own packages
+		{RankNewbie, true, authorTagVoteWeight},
+		{RankMember, true, authorTagVoteWeight},
+		{RankContributor, true, 4},
+		{RankTrusted, true, 6},
+	}
+	for _, tt := range tests {
+		got := TagVoteWeight(tt.rank, tt.isAuthor)
+		if got != tt.want {
+			t.Errorf("TagVoteWeight(%s, author %v) = %d, want %d", tt.rank, tt.isAuthor, got, tt.want)
+		}
+		if got < 1 || got > MaxTagVoteWeight {
+			t.Errorf("TagVoteWeight(%s, author %v) = %d, outside 1..%d", tt.rank, tt.isAuthor, got, MaxTagVoteWeight)
+		}
+	}
+}
+
+func TestDailyTagVoteLimit(t *testing.T) {
+	tests := []struct {
+		rank string
+		want int
+	}{
+		{RankNewbie, 20},
+		{RankMember, 60},
+		{RankContributor, 200},
+		{RankTrusted, 200},
+		{"", 20},
+	}
+	for _, tt := range tests {
+		if got := DailyTagVoteLimit(tt.rank); got != tt.want {
+			t.Errorf("DailyTagVoteLimit(%s) = %d, want %d", tt.rank, got, tt.want)
+		}
+	}
+}
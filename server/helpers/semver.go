@@ -0,0 +1,212 @@
+package helpers
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Semver is a parsed semantic version (https://semver.org)
+type Semver struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+}
+
+var semverRegex = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
+
+// ParseSemver parses a version string such as "1.2.3", "v1.2.3" or "1.2.3-beta.1+build"
+func ParseSemver(version string) (*Semver, error) {
+	m := semverRegex.FindStringSubmatch(strings.TrimSpace(version))
+	if m == nil {
+		return nil, fmt.Errorf("invalid semantic version: %q", version)
+	}
+
+	// The components are stored in INTEGER columns
+	var parts [3]int
+	for i := range parts {
+		n, err := strconv.ParseInt(m[i+1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version: %q has a component larger than %d", version, math.MaxInt32)
+		}
+		parts[i] = int(n)
+	}
+
+	return &Semver{
+		Major:      parts[0],
+		Minor:      parts[1],
+		Patch:      parts[2],
+		Prerelease: m[4],
+		Build:      m[5],
+	}, nil
+}
+
+// String returns the canonical form of the version, without a leading "v"
+func (v Semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 depending on whether v is lower, equal or higher than other.
+// Build metadata is ignored, as required by the spec.
+func (v Semver) Compare(other Semver) int {
+	if c := compareInt(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+
+	// A version without prerelease has higher precedence than one with
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	}
+
+	a := strings.Split(v.Prerelease, ".")
+	b := strings.Split(other.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ai, aErr := strconv.Atoi(a[i])
+		bi, bErr := strconv.Atoi(b[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := compareInt(ai, bi); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			// Numeric identifiers have lower precedence than alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(len(a), len(b))
+}
+
+// PrereleaseKey returns a string that sorts prereleases of the same version in precedence
+// order when compared bytewise (COLLATE "C" in PostgreSQL), so "rc.9" comes before "rc.10".
+// Numeric identifiers are zero-padded and marked to sort below alphanumeric ones, and
+// identifiers are joined by a space, which sorts below every identifier character, so a
+// shorter list of identifiers sorts first.
+func (v Semver) PrereleaseKey() string {
+	if v.Prerelease == "" {
+		return ""
+	}
+	identifiers := strings.Split(v.Prerelease, ".")
+	for i, id := range identifiers {
+		if n, err := strconv.Atoi(id); err == nil {
+			identifiers[i] = fmt.Sprintf("0%020d", n)
+		} else {
+			identifiers[i] = "1" + id
+		}
+	}
+	return strings.Join(identifiers, " ")
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// SemverConstraint is a single version requirement such as "^1.2.0", ">=0.3.0" or "*"
+type SemverConstraint struct {
+	Op      string // One of "*", "=", "^", "~", ">", ">=", "<", "<="
+	Version Semver
+}
+
+var constraintOps = []string{">=", "<=", "^", "~", ">", "<", "="}
+
+// ParseSemverConstraint parses a version requirement. An empty string or "*" matches any version,
+// a bare version matches exactly.
+func ParseSemverConstraint(constraint string) (*SemverConstraint, error) {
+	constraint = strings.TrimSpace(constraint)
+	if constraint == "" || constraint == "*" {
+		return &SemverConstraint{Op: "*"}, nil
+	}
+
+	op := ""
+	for _, candidate := range constraintOps {
+		if strings.HasPrefix(constraint, candidate) {
+			op = candidate
+			break
+		}
+	}
+
+	v, err := ParseSemver(strings.TrimSpace(strings.TrimPrefix(constraint, op)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint: %q", constraint)
+	}
+	if op == "" {
+		op = "="
+	}
+	return &SemverConstraint{Op: op, Version: *v}, nil
+}
+
+// Matches reports whether v satisfies the constraint
+func (c SemverConstraint) Matches(v Semver) bool {
+	cmp := v.Compare(c.Version)
+	switch c.Op {
+	case "*":
+		return true
+	case "=":
+		return cmp == 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "~":
+		// Same major and minor, at least the given patch
+		return cmp >= 0 && v.Major == c.Version.Major && v.Minor == c.Version.Minor
+	case "^":
+		// Compatible with the left-most non-zero component
+		if cmp < 0 {
+			return false
+		}
+		if c.Version.Major != 0 {
+			return v.Major == c.Version.Major
+		}
+		if c.Version.Minor != 0 {
+			return v.Major == 0 && v.Minor == c.Version.Minor
+		}
+		return v.Major == 0 && v.Minor == 0 && v.Patch == c.Version.Patch
+	}
+	return false
+}
+
+// String returns the constraint in its canonical form
+func (c SemverConstraint) String() string {
+	if c.Op == "*" {
+		return "*"
+	}
+	return c.Op + c.Version.String()
+}
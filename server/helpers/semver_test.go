@@ -0,0 +1,95 @@
+package helpers
+
+import (
+	"sort"
+	"testing"
+)
+
+// Versions in increasing precedence, from the examples of the semver spec
+var semverOrder = []string{
+	"1.0.0-alpha",
+	"1.0.0-alpha.1",
+	"1.0.0-alpha.beta",
+	"1.0.0-beta",
+	"1.0.0-beta.2",
+	"1.0.0-beta.11",
+	"1.0.0-rc.1",
+	"1.0.0-rc.9",
+	"1.0.0-rc.10",
+	"1.0.0",
+}
+
+func TestSemverCompare(t *testing.T) {
+	for i := range semverOrder {
+		for j := range semverOrder {
+			a, b := mustParseSemver(t, semverOrder[i]), mustParseSemver(t, semverOrder[j])
+			if got, want := a.Compare(*b), compareInt(i, j); got != want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", semverOrder[i], semverOrder[j], got, want)
+			}
+		}
+	}
+
+	// Build metadata doesn't count
+	a, b := mustParseSemver(t, "1.2.3+a"), mustParseSemver(t, "1.2.3+b")
+	if a.Compare(*b) != 0 {
+		t.Errorf("1.2.3+a and 1.2.3+b compare as %d, want 0", a.Compare(*b))
+	}
+}
+
+func TestSemverPrereleaseKeySortsByPrecedence(t *testing.T) {
+	prereleases := semverOrder[:len(semverOrder)-1]
+	keys := make([]string, len(prereleases))
+	byKey := map[string]string{}
+	for i, version := range prereleases {
+		keys[i] = mustParseSemver(t, version).PrereleaseKey()
+		byKey[keys[i]] = version
+	}
+
+	// Bytewise order, as COLLATE "C" compares
+	sort.Strings(keys)
+	for i, key := range keys {
+		if byKey[key] != prereleases[i] {
+			t.Errorf("key %d sorts %s, want %s", i, byKey[key], prereleases[i])
+		}
+	}
+
+	if key := mustParseSemver(t, "1.0.0").PrereleaseKey(); key != "" {
+		t.Errorf("key of a release = %q, want empty", key)
+	}
+}
+
+func mustParseSemver(t *testing.T, version string) *Semver {
+	t.Helper()
+	v, err := ParseSemver(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string // "" when the version is invalid
+	}{
+		{"1.2.3", "1.2.3"},
+		{"v1.2.3-beta.1+build", "1.2.3-beta.1+build"},
+		{"2147483647.0.0", "2147483647.0.0"},
+		{"2147483648.0.0", ""},
+		{"1.99999999999999999999.0", ""},
+		{"01.2.3", ""},
+		{"1.2", ""},
+	}
+	for _, tt := range tests {
+		v, err := ParseSemver(tt.version)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParseSemver(%q) = %s, want an error", tt.version, v)
+			}
+			continue
+		}
+		if err != nil || v.String() != tt.want {
+			t.Errorf("ParseSemver(%q) = %v, %v; want %s", tt.version, v, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"opm/middleware"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SessionTTL is how long a login lasts
+const SessionTTL = 7 * 24 * time.Hour
+
+// CreateSession records a new login for userID and returns the signed JWT for it
+func CreateSession(ctx context.Context, pool *pgxpool.Pool, r *http.Request, userID int, secret string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	sessionID := hex.EncodeToString(b)
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+
+	_, err := pool.Exec(ctx, `
+		INSERT INTO sessions (id, user_id, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		sessionID, userID, userAgent, middleware.ClientIP(r), time.Now().Add(SessionTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return GenerateJWT(userID, sessionID, secret)
+}
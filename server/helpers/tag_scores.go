@@ -0,0 +1,89 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+
+	"opm/logger"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Anti-brigading: once brigadeNewAccounts accounts younger than newAccountAge have voted on
+// a tag of a package within brigadeWindow, votes of young accounts on that tag of that
+// package stop counting
+const (
+	newAccountAge      = "7 days"
+	brigadeWindow      = "24 hours"
+	brigadeNewAccounts = 5
+)
+
+// tagVote is a vote on a tag of a package, as counted by tagScore
+type tagVote struct {
+	value      int
+	newAccount bool // Cast by an account younger than newAccountAge
+	recent     bool // Cast or changed within brigadeWindow
+}
+
+// tagScore sums the votes on a tag of a package. score leaves out the votes of new accounts
+// while they are brigading the tag; undamped counts every vote.
+func tagScore(votes []tagVote) (score, undamped int, brigaded bool) {
+	// Each user has a single vote per tag, so votes are voters
+	newVoters := 0
+	for _, v := range votes {
+		if v.newAccount && v.recent {
+			newVoters++
+		}
+	}
+	brigaded = newVoters >= brigadeNewAccounts
+
+	for _, v := range votes {
+		undamped += v.value
+		if !(brigaded && v.newAccount) {
+			score += v.value
+		}
+	}
+	return score, undamped, brigaded
+}
+
+// ScoreTag recomputes the stored score of a tag of a package from its votes within tx and
+// returns it, along with the score of every vote counted. The package_tags row stays locked
+// until tx ends, so concurrent votes on the tag are scored one after the other.
+func ScoreTag(ctx context.Context, tx pgx.Tx, packageID, tagID int) (score, undamped int, err error) {
+	_, err = tx.Exec(ctx, "SELECT 1 FROM package_tags WHERE package_id = $1 AND tag_id = $2 FOR UPDATE", packageID, tagID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to lock package %d, tag %d: %w", packageID, tagID, err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT v.vote_value,
+		       u.created_at > NOW() - INTERVAL '`+newAccountAge+`',
+		       v.updated_at > NOW() - INTERVAL '`+brigadeWindow+`'
+		FROM tag_votes v
+		JOIN users u ON v.user_id = u.id
+		WHERE v.package_id = $1 AND v.tag_id = $2`,
+		packageID, tagID,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to fetch votes for package %d, tag %d: %w", packageID, tagID, err)
+	}
+	votes, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (tagVote, error) {
+		var v tagVote
+		err := row.Scan(&v.value, &v.newAccount, &v.recent)
+		return v, err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to scan votes for package %d, tag %d: %w", packageID, tagID, err)
+	}
+
+	score, undamped, brigaded := tagScore(votes)
+	_, err = tx.Exec(ctx, "UPDATE package_tags SET score = $3 WHERE package_id = $1 AND tag_id = $2", packageID, tagID, score)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to update score for package %d, tag %d: %w", packageID, tagID, err)
+	}
+
+	if brigaded {
+		logger.SecurityLogger.Printf("Possible vote brigading on package %d, tag %d: votes of new accounts are ignored", packageID, tagID)
+	}
+	return score, undamped, nil
+}
@@ -0,0 +1,47 @@
+package helpers
+
+import "testing"
+
+func TestTagScore(t *testing.T) {
+	established := func(value int) tagVote { return tagVote{value: value, recent: true} }
+	newcomer := func(value int) tagVote { return tagVote{value: value, newAccount: true, recent: true} }
+	repeat := func(n int, v tagVote) []tagVote {
+		votes := make([]tagVote, n)
+		for i := range votes {
+			votes[i] = v
+		}
+		return votes
+	}
+
+	tests := []struct {
+		name         string
+		votes        []tagVote
+		wantScore    int
+		wantUndamped int
+		wantBrigaded bool
+	}{
+		{"no votes", nil, 0, 0, false},
+		{"weighted votes add up", []tagVote{established(6), established(-2), established(4)}, 8, 8, false},
+		{"new accounts count below the threshold",
+			append([]tagVote{established(4)}, repeat(brigadeNewAccounts-1, newcomer(-1))...),
+			4 - (brigadeNewAccounts - 1), 4 - (brigadeNewAccounts - 1), false},
+		{"new accounts are ignored from the threshold on",
+			append([]tagVote{established(4)}, repeat(brigadeNewAccounts, newcomer(-1))...),
+			4, 4 - brigadeNewAccounts, true},
+		{"upvoting brigades are ignored too",
+			append([]tagVote{established(-2)}, repeat(brigadeNewAccounts, newcomer(1))...),
+			-2, brigadeNewAccounts - 2, true},
+		{"old votes of new accounts don't make a brigade",
+			append([]tagVote{established(4)}, repeat(brigadeNewAccounts, tagVote{value: -1, newAccount: true})...),
+			4 - brigadeNewAccounts, 4 - brigadeNewAccounts, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, undamped, brigaded := tagScore(tt.votes)
+			if score != tt.wantScore || undamped != tt.wantUndamped || brigaded != tt.wantBrigaded {
+				t.Errorf("tagScore = %d, %d, %v; want %d, %d, %v",
+					score, undamped, brigaded, tt.wantScore, tt.wantUndamped, tt.wantBrigaded)
+			}
+		})
+	}
+}
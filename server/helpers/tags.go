@@ -0,0 +1,98 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"opm/models"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MaxTagNameLength matches tags.name
+const MaxTagNameLength = 50
+
+var ErrInvalidTagName = errors.New("tag names must contain letters or digits and be at most 50 characters")
+
+// NormalizeTagName turns a tag name into its canonical form: lowercase letters and digits of
+// any script separated by single hyphens. "Game Dev", "game_dev" and "--Game-Dev" all become
+// "game-dev", and "Über Shader" becomes "über-shader".
+func NormalizeTagName(name string) (string, error) {
+	var b strings.Builder
+	pendingHyphen := false
+	// NFC, so a letter typed as a base and a combining accent is the precomposed letter
+	for _, c := range norm.NFC.String(strings.ToLower(strings.TrimSpace(name))) {
+		switch {
+		case unicode.IsLetter(c), unicode.IsDigit(c):
+			if pendingHyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingHyphen = false
+			b.WriteRune(c)
+		case unicode.IsMark(c):
+			// Marks that don't compose, as in many Indic scripts, belong to the letter before
+			if b.Len() > 0 && !pendingHyphen {
+				b.WriteRune(c)
+			}
+		case c == '-' || c == '_' || c == '.' || c == '/' || unicode.IsSpace(c):
+			pendingHyphen = true
+		case c == '+' || c == '#':
+			// Keep "c++" and "c#" apart from "c"
+			if pendingHyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingHyphen = false
+			if c == '+' {
+				b.WriteString("plus")
+			} else {
+				b.WriteString("sharp")
+			}
+		}
+	}
+
+	// tags.name is VARCHAR(50), which counts characters
+	normalized := b.String()
+	if normalized == "" || utf8.RuneCountInString(normalized) > MaxTagNameLength {
+		return "", ErrInvalidTagName
+	}
+	return normalized, nil
+}
+
+// TagMatchKey is what two tag names must share to be considered the same tag, so "gamedev"
+// finds an existing "game-dev". It expects a normalized name.
+func TagMatchKey(normalized string) string {
+	return strings.ReplaceAll(normalized, "-", "")
+}
+
+// tagMatchKeySQL is TagMatchKey for a tags row aliased t, backed by idx_tags_match_key
+const tagMatchKeySQL = "replace(t.name, '-', '')"
+
+// ResolveTag finds the tag matching a normalized name, following aliases to their canonical
+// tag. It returns pgx.ErrNoRows when there is no such tag.
+func ResolveTag(ctx context.Context, pool *pgxpool.Pool, normalized string) (*models.Tag, error) {
+	var t models.Tag
+	err := pool.QueryRow(ctx, `
+		SELECT c.id, c.name, c.category, c.usage_count, c.created_at
+		FROM tags t
+		JOIN tags c ON c.id = COALESCE(t.canonical_id, t.id)
+		WHERE `+tagMatchKeySQL+` = $1
+		ORDER BY t.canonical_id IS NOT NULL, t.id
+		LIMIT 1`,
+		TagMatchKey(normalized),
+	).Scan(&t.ID, &t.Name, &t.Category, &t.UsageCount, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CanonicalTagIDsSQL selects the canonical tag ids for the match keys in the parameter list
+// params, for filtering packages by tag name or alias
+func CanonicalTagIDsSQL(params string) string {
+	return "SELECT COALESCE(t.canonical_id, t.id) FROM tags t WHERE " + tagMatchKeySQL + " IN (" + params + ")"
+}
@@ -0,0 +1,88 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTagName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"graphics", "graphics"},
+		{"Game Dev", "game-dev"},
+		{"game_dev", "game-dev"},
+		{"--Game-Dev", "game-dev"},
+		{"  game . dev / tools  ", "game-dev-tools"},
+		{"OpenGL 4.6", "opengl-4-6"},
+		{"C++", "cplusplus"},
+		{"C#", "csharp"},
+		{"objective c++", "objective-cplusplus"},
+		{"#hashtag", "sharphashtag"},
+		{"emoji 🎮 games", "emoji-games"},
+		// Letters and digits of every script are kept, lowercased
+		{"Über Shader", "über-shader"},
+		{"ÉCOLE", "école"},
+		{"日本語", "日本語"},
+		{"Физика 2D", "физика-2d"},
+		{"ゲーム　エンジン", "ゲーム-エンジン"},
+		{"١٢٣", "١٢٣"},
+		// A base letter and a combining accent are the precomposed letter
+		{"cafe\u0301", "caf\u00e9"},
+		// Marks that don't compose stay with their letter
+		{"हिन्दी", "हिन्दी"},
+		{strings.Repeat("é", MaxTagNameLength), strings.Repeat("é", MaxTagNameLength)},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeTagName(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeTagName(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTagNameRejects(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"   ",
+		"---",
+		"🎮🎲",
+		"\u0301",
+		strings.Repeat("a", MaxTagNameLength+1),
+		strings.Repeat("é", MaxTagNameLength+1),
+	} {
+		if got, err := NormalizeTagName(in); err != ErrInvalidTagName {
+			t.Errorf("NormalizeTagName(%q) = %q, %v; want ErrInvalidTagName", in, got, err)
+		}
+	}
+}
+
+func TestTagMatchKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"gamedev", "game-dev", true},
+		{"Game Dev", "game_dev", true},
+		{"über-shader", "Übershader", true},
+		{"opengl-4-6", "opengl46", true},
+		{"c", "c++", false},
+		{"c++", "c#", false},
+		{"game-dev", "game-devs", false},
+		{"école", "ecole", false},
+	}
+	for _, tt := range tests {
+		a, err := NormalizeTagName(tt.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := NormalizeTagName(tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if same := TagMatchKey(a) == TagMatchKey(b); same != tt.same {
+			t.Errorf("TagMatchKey(%q) == TagMatchKey(%q) is %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
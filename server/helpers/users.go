@@ -6,14 +6,14 @@ import (
 	"strings"
 	"time"
 
-	"opm/db"
 	"opm/models"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // FindOrCreateUser finds an existing user or creates a new one
-func FindOrCreateUser(ctx context.Context, provider string, providerID string, username string, displayName string, avatarURL string) (*models.User, error) {
+func FindOrCreateUser(ctx context.Context, pool *pgxpool.Pool, provider string, providerID string, username string, displayName string, avatarURL string) (*models.User, error) {
 	// Create a new context with a longer timeout for database operations
 	dbCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -37,7 +37,7 @@ func FindOrCreateUser(ctx context.Context, provider string, providerID string, u
 	}
 
 	// Try to find existing user
-	err := db.QueryRow(dbCtx, query, args...).Scan(
+	err := pool.QueryRow(dbCtx, query, args...).Scan(
 		&user.ID,
 		&user.GitHubID,
 		&user.DiscordID,
@@ -52,7 +52,7 @@ func FindOrCreateUser(ctx context.Context, provider string, providerID string, u
 	if err == nil {
 		// User exists, update their info
 		updateQuery := `UPDATE users SET avatar_url = $1, updated_at = NOW() WHERE id = $2`
-		_, err = db.Exec(dbCtx, updateQuery, avatarURL, user.ID)
+		_, err = pool.Exec(dbCtx, updateQuery, avatarURL, user.ID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to update user: %w", err)
 		}
@@ -72,7 +72,7 @@ func FindOrCreateUser(ctx context.Context, provider string, providerID string, u
 
 	for {
 		var exists bool
-		err = db.QueryRow(dbCtx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", uniqueUsername).Scan(&exists)
+		err = pool.QueryRow(dbCtx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", uniqueUsername).Scan(&exists)
 		if err != nil {
 			return nil, fmt.Errorf("failed to check username: %w", err)
 		}
@@ -90,7 +90,7 @@ func FindOrCreateUser(ctx context.Context, provider string, providerID string, u
 
 	for {
 		var exists bool
-		err = db.QueryRow(dbCtx, "SELECT EXISTS(SELECT 1 FROM users WHERE slug = $1)", uniqueSlug).Scan(&exists)
+		err = pool.QueryRow(dbCtx, "SELECT EXISTS(SELECT 1 FROM users WHERE slug = $1)", uniqueSlug).Scan(&exists)
 		if err != nil {
 			return nil, fmt.Errorf("failed to check slug: %w", err)
 		}
@@ -115,7 +115,7 @@ func FindOrCreateUser(ctx context.Context, provider string, providerID string, u
 		args = []interface{}{providerID, uniqueUsername, uniqueSlug, displayName, avatarURL}
 	}
 
-	err = db.QueryRow(dbCtx, insertQuery, args...).Scan(
+	err = pool.QueryRow(dbCtx, insertQuery, args...).Scan(
 		&user.ID,
 		&user.GitHubID,
 		&user.DiscordID,
@@ -133,3 +133,10 @@ func FindOrCreateUser(ctx context.Context, provider string, providerID string, u
 
 	return &user, nil
 }
+
+// IsModerator reports whether userID is a moderator
+func IsModerator(ctx context.Context, pool *pgxpool.Pool, userID int) (bool, error) {
+	var isModerator bool
+	err := pool.QueryRow(ctx, "SELECT is_moderator FROM users WHERE id = $1", userID).Scan(&isModerator)
+	return isModerator, err
+}
@@ -6,19 +6,12 @@ import (
 	"net/http"
 	"opm/config"
 	"opm/db"
-	"opm/handlers/auth"
-	"opm/handlers/packages"
-	"opm/handlers/tags"
-	"opm/handlers/users"
 	"opm/logger"
-	"opm/middleware"
+	"opm/server"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
-
-	"github.com/gorilla/mux"
-	"github.com/rs/cors"
 )
 
 func main() {
@@ -33,86 +26,37 @@ func main() {
 
 	mainLogger.Printf("🚀 Starting OPM server in %s mode", cfg.Env)
 
-	db.InitPool(cfg.DatabaseURL)
-	defer db.Close()
-
-	r := mux.NewRouter()
-
-	r.Use(middleware.Logger)
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RateLimit(cfg.RateLimit, cfg.RateWindow))
-
-	authApi := r.NewRoute().Subrouter()
-	authApi.Use(middleware.RequireAuthMiddleware)
-
-	// Public routes with optional auth (for bookmark/vote status)
-	optionalAuthApi := r.NewRoute().Subrouter()
-	optionalAuthApi.Use(middleware.OptionalAuthMiddleware)
-
-	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok"}`))
-	}).Methods("GET")
-
-	// Auth routes (these don't require authentication)
-	r.HandleFunc("/auth/github", auth.GitHubLogin(cfg)).Methods("GET")
-	r.HandleFunc("/auth/github/callback", auth.GitHubCallback(cfg)).Methods("GET")
-	r.HandleFunc("/auth/discord", auth.DiscordLogin(cfg)).Methods("GET")
-	r.HandleFunc("/auth/discord/callback", auth.DiscordCallback(cfg)).Methods("GET")
-	r.HandleFunc("/auth/logout", auth.Logout()).Methods("POST")
-	authApi.HandleFunc("/auth/me", users.GetCurrentUser).Methods("GET")
-
-	// Package routes
-	optionalAuthApi.HandleFunc("/readme", packages.GetPackageReadme).Methods("GET")
-	authApi.HandleFunc("/repository/metadata", packages.GetRepositoryMetadata).Methods("GET")
-	optionalAuthApi.HandleFunc("/packages", packages.List).Methods("GET")
-	optionalAuthApi.HandleFunc("/packages/search", packages.Search).Methods("GET")
-	authApi.HandleFunc("/packages", packages.Create).Methods("POST")
-	authApi.HandleFunc("/packages/bookmark", packages.Bookmark).Methods("POST")     // param: package_id
-	authApi.HandleFunc("/packages/bookmark", packages.Unbookmark).Methods("DELETE") // param: package_id
-	// MUST BE BELOW OTHER ROUTES DUE TO WILDCARD MUX:
-	optionalAuthApi.HandleFunc("/packages/{userSlug}/{pkgSlug}", packages.Get).Methods("GET")
-	authApi.HandleFunc("/packages/{id}", packages.Update).Methods("PUT")
-	authApi.HandleFunc("/packages/{id}", packages.Delete).Methods("DELETE")
-
-	// Tag routes (require auth)
-	authApi.HandleFunc("/tags", packages.AddTag).Methods("POST")
-	authApi.HandleFunc("/tags/vote", packages.VoteTag).Methods("POST") // param: package_id
-
-	// Flag/moderation routes
-	authApi.HandleFunc("/flags", packages.FlagPackage).Methods("POST")
-	optionalAuthApi.HandleFunc("/flags", packages.GetPackageFlags).Methods("GET")    // param: package_id
-	optionalAuthApi.HandleFunc("/flags/stats", packages.GetFlagStats).Methods("GET") // param: package_id
-	authApi.HandleFunc("/flags/all", packages.GetAllFlags).Methods("GET")            // Moderator only
-	authApi.HandleFunc("/users/me/flags", packages.GetUserFlags).Methods("GET")
-	authApi.HandleFunc("/flags/{id}/resolve", packages.ResolveFlag).Methods("PUT") // Moderator only
-	authApi.HandleFunc("/flags/{id}", packages.DeleteFlag).Methods("DELETE")
-
-	// Tags
-	r.HandleFunc("/tags", tags.List).Methods("GET")
+	// "opm-server migrate up|down|status" manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		pool, err := db.New(context.Background(), cfg)
+		if err != nil {
+			mainLogger.Fatalf("❌ Failed to connect to database: %v", err)
+		}
+		defer pool.Close()
+		if err := runMigrate(context.Background(), pool, os.Args[2:]); err != nil {
+			mainLogger.Fatalf("❌ %v", err)
+		}
+		return
+	}
 
-	// User routes
-	authApi.HandleFunc("/users/me/packages", users.ListUserPackages).Methods("GET")
-	authApi.HandleFunc("/users/me", users.UpdateProfile).Methods("PUT")
-	authApi.HandleFunc("/users/check-user-slug", users.CheckSlugAvailability).Methods("GET")
-	// authApi.HandleFunc("/users/me/bookmarks", users.ListBookmarks).Methods("GET")
+	app, err := server.New(cfg)
+	if err != nil {
+		mainLogger.Fatalf("❌ %v", err)
+	}
+	defer app.Close()
 
-	// CORS
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:9000", "https://pkg-odin.org"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "Cookie", "Content-Disposition"},
-		ExposedHeaders:   []string{"Set-Cookie", "Content-Disposition"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	})
+	// Background jobs stop with the server
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
 
-	handler := c.Handler(r)
+	if err := app.StartJobs(jobsCtx); err != nil {
+		mainLogger.Fatalf("❌ %v", err)
+	}
 
 	// Create server
 	srv := &http.Server{
 		Addr:         ":" + cfg.Port,
-		Handler:      handler,
+		Handler:      app.Handler,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -132,6 +76,7 @@ func main() {
 	<-quit
 
 	mainLogger.Println("Shutting down server...")
+	stopJobs()
 
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
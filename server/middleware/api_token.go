@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"opm/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// APITokenPrefix marks personal API tokens, so they can be told apart from session JWTs
+const APITokenPrefix = "opm_"
+
+// IsAPIToken reports whether token looks like a personal API token
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// HashAPIToken returns the hex SHA-256 of a token, which is all the database stores
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateAPIToken looks up an unrevoked, unexpired API token and records its use
+func authenticateAPIToken(ctx context.Context, pool *pgxpool.Pool, token string) (*models.AuthUser, error) {
+	authUser := &models.AuthUser{Token: token}
+	err := pool.QueryRow(ctx, `
+		UPDATE api_tokens t SET last_used_at = NOW()
+		FROM users u
+		WHERE u.id = t.user_id
+		  AND t.token_hash = $1
+		  AND t.revoked_at IS NULL
+		  AND (t.expires_at IS NULL OR t.expires_at > NOW())
+		RETURNING t.id, t.user_id, t.scopes, `+banColumns,
+		HashAPIToken(token),
+	).Scan(&authUser.APITokenID, &authUser.UserID, &authUser.Scopes, &authUser.Ban.Banned, &authUser.Ban.Until, &authUser.Ban.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return authUser, nil
+}
+
+// RequireScope rejects API tokens that were not granted scope. Browser sessions always pass.
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authUser, ok := GetAuthUser(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !authUser.HasScope(scope) {
+			http.Error(w, "API token is missing the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RequireSession rejects API tokens outright, for account and moderation routes
+func RequireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authUser, ok := GetAuthUser(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if authUser.APITokenID != 0 {
+			http.Error(w, "This endpoint requires a browser session", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
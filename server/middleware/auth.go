@@ -6,17 +6,29 @@ import (
 	"os"
 	"strings"
 
+	"opm/logger"
 	"opm/models"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type contextKey string
 
 const userContextKey contextKey = "user"
 
+// Auth holds the auth middlewares, which check API tokens and sessions against the database
+type Auth struct {
+	pool *pgxpool.Pool
+}
+
+// NewAuth returns the auth middlewares backed by pool
+func NewAuth(pool *pgxpool.Pool) *Auth {
+	return &Auth{pool: pool}
+}
+
 // RequireAuthMiddleware ensures the request has a valid JWT token
-func RequireAuthMiddleware(next http.Handler) http.Handler {
+func (a *Auth) RequireAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get JWT secret from environment
 		jwtSecret := os.Getenv("JWT_SECRET")
@@ -45,6 +57,20 @@ func RequireAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Personal API tokens are looked up in the database instead of parsed
+		if IsAPIToken(token) {
+			authUser, err := authenticateAPIToken(r.Context(), a.pool, token)
+			if err != nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			if rejectBanned(w, r, authUser) {
+				return
+			}
+			serveAuthenticated(w, r, next, authUser)
+			return
+		}
+
 		// Parse and validate token
 		claims := &Claims{}
 		jwtToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
@@ -58,8 +84,23 @@ func RequireAuthMiddleware(next http.Handler) http.Handler {
 
 		// Add user info to context
 		authUser := &models.AuthUser{
-			UserID: claims.UserID,
-			Token:  token,
+			UserID:    claims.UserID,
+			Token:     token,
+			SessionID: claims.ID,
+		}
+
+		// Check the session hasn't been revoked
+		if err := checkSession(r.Context(), a.pool, claims, authUser); err == errSessionRevoked {
+			http.Error(w, "Session expired or revoked", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			logger.MainLogger.Printf("Failed to check session for user %d: %v", claims.UserID, err)
+			http.Error(w, "Failed to check session", http.StatusInternalServerError)
+			return
+		}
+
+		if rejectBanned(w, r, authUser) {
+			return
 		}
 		ctx := context.WithValue(r.Context(), userContextKey, authUser)
 		
@@ -73,7 +114,7 @@ func RequireAuthMiddleware(next http.Handler) http.Handler {
 }
 
 // OptionalAuthMiddleware extracts auth info if present but doesn't require it
-func OptionalAuthMiddleware(next http.Handler) http.Handler {
+func (a *Auth) OptionalAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get JWT secret from environment
 		jwtSecret := os.Getenv("JWT_SECRET")
@@ -105,13 +146,24 @@ func OptionalAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Personal API tokens; an unknown or revoked one means no auth
+		if IsAPIToken(token) {
+			authUser, err := authenticateAPIToken(r.Context(), a.pool, token)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			serveAuthenticated(w, r, next, authUser)
+			return
+		}
+
 		// Parse and validate token
 		claims := &Claims{}
 		jwtToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
 			return []byte(jwtSecret), nil
 		})
 
-		// If token is invalid, continue without auth
+		// If token is invalid or its session was revoked, continue without auth
 		if err != nil || !jwtToken.Valid {
 			next.ServeHTTP(w, r)
 			return
@@ -119,8 +171,16 @@ func OptionalAuthMiddleware(next http.Handler) http.Handler {
 
 		// Add user info to context
 		authUser := &models.AuthUser{
-			UserID: claims.UserID,
-			Token:  token,
+			UserID:    claims.UserID,
+			Token:     token,
+			SessionID: claims.ID,
+		}
+		if err := checkSession(r.Context(), a.pool, claims, authUser); err != nil {
+			if err != errSessionRevoked {
+				logger.MainLogger.Printf("Failed to check session for user %d: %v", claims.UserID, err)
+			}
+			next.ServeHTTP(w, r)
+			return
 		}
 		ctx := context.WithValue(r.Context(), userContextKey, authUser)
 		
@@ -133,6 +193,23 @@ func OptionalAuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// serveAuthenticated stores authUser in the request context and calls next
+func serveAuthenticated(w http.ResponseWriter, r *http.Request, next http.Handler, authUser *models.AuthUser) {
+	ctx := WithAuthUser(r.Context(), authUser)
+
+	// Set user ID in response writer for logging
+	if rw, ok := r.Context().Value("responseWriter").(*responseWriter); ok {
+		rw.SetUserID(authUser.UserID)
+	}
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// WithAuthUser returns a context carrying authUser, as the auth middlewares set it
+func WithAuthUser(ctx context.Context, authUser *models.AuthUser) context.Context {
+	return context.WithValue(ctx, userContextKey, authUser)
+}
+
 // GetAuthUser retrieves the authenticated user from context
 func GetAuthUser(ctx context.Context) (*models.AuthUser, bool) {
 	user, ok := ctx.Value(userContextKey).(*models.AuthUser)
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"opm/models"
+)
+
+// banColumns selects a user's ban status from "users u": whether a ban or unexpired
+// suspension is in effect, when it ends, and why
+const banColumns = `u.is_banned AND (u.banned_until IS NULL OR u.banned_until > NOW()), u.banned_until, u.ban_reason`
+
+// rejectBanned answers write requests from banned or suspended users with 403. Banned users
+// can still read and log out.
+func rejectBanned(w http.ResponseWriter, r *http.Request, authUser *models.AuthUser) bool {
+	if !authUser.Ban.Banned {
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+
+	msg := "Your account is banned"
+	if authUser.Ban.Until != nil {
+		msg = fmt.Sprintf("Your account is suspended until %s", authUser.Ban.Until.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	if authUser.Ban.Reason != nil && *authUser.Ban.Reason != "" {
+		msg += ": " + *authUser.Ban.Reason
+	}
+	http.Error(w, msg, http.StatusForbidden)
+	return true
+}
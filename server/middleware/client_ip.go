@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// maxClientIPLength is the size of sessions.ip_address
+const maxClientIPLength = 64
+
+const clientIPContextKey contextKey = "client_ip"
+
+// TrustedProxies are the reverse proxies, from TRUSTED_PROXIES, whose X-Forwarded-For is
+// believed
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses comma separated IPs and CIDR ranges
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// ClientIPs middleware works out the client address of each request once, for ClientIP.
+// It must run before the logger and anything else that reads it.
+func (p TrustedProxies) ClientIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPContextKey, p.clientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the client address found by the ClientIPs middleware, or the address of
+// the peer when it didn't run
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+	return TrustedProxies(nil).clientIP(r)
+}
+
+// clientIP returns the address of the peer, or the address it forwarded for when it is a
+// trusted proxy. X-Forwarded-For is read from the right, past any further trusted proxies,
+// since clients can put anything at its start.
+func (p TrustedProxies) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		// Not an IP, e.g. a unix socket; it still has to fit the sessions table
+		if len(host) > maxClientIPLength {
+			host = host[:maxClientIPLength]
+		}
+		return host
+	}
+	if !p.trusted(peer) {
+		return peer.Unmap().WithZone("").String()
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		peer = addr
+		if !p.trusted(addr) {
+			break
+		}
+	}
+	return peer.Unmap().WithZone("").String()
+}
+
+func (p TrustedProxies) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"port stripped", "192.0.2.1:1000", "", "192.0.2.1"},
+		{"ipv6 port stripped", "[2001:db8::1]:1000", "", "2001:db8::1"},
+		{"zone dropped", "[fe80::1%eth0]:1000", "", "fe80::1"},
+		{"ipv4 mapped", "[::ffff:192.0.2.1]:1000", "", "192.0.2.1"},
+		{"untrusted proxy", "192.0.2.1:1000", "198.51.100.7", "192.0.2.1"},
+		{"trusted proxy", "127.0.0.1:1000", "198.51.100.7", "198.51.100.7"},
+		{"spoofed start", "127.0.0.1:1000", "203.0.113.9, 198.51.100.7", "198.51.100.7"},
+		{"oversized header", "127.0.0.1:1000", strings.Repeat("a", 100), "127.0.0.1"},
+		{"not an address", "@" + strings.Repeat("s", 100), "", "@" + strings.Repeat("s", 63)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			var got string
+			proxies.ClientIPs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+			if len(got) > maxClientIPLength {
+				t.Errorf("ClientIP is %d characters, more than sessions.ip_address holds", len(got))
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutMiddleware(t *testing.T) {
+	// Nothing forwarded is trusted, but the port still goes
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:1000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	if got := ClientIP(r); got != "127.0.0.1" {
+		t.Errorf("ClientIP = %q, want 127.0.0.1", got)
+	}
+}
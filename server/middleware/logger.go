@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 	"opm/logger"
-	"strings"
 	"time"
 )
 
@@ -35,16 +34,7 @@ func Logger(next http.Handler) http.Handler {
 		start := time.Now()
 		
 		// Get client IP
-		clientIP := r.Header.Get("X-Forwarded-For")
-		if clientIP == "" {
-			clientIP = r.Header.Get("X-Real-IP")
-		}
-		if clientIP == "" {
-			clientIP = r.RemoteAddr
-		} else {
-			// Take first IP if multiple
-			clientIP = strings.Split(clientIP, ",")[0]
-		}
+		clientIP := ClientIP(r)
 		
 		// Skip noisy endpoints
 		if r.URL.Path == "/health" {
@@ -1,61 +1,227 @@
 package middleware
 
 import (
+	"fmt"
+	"hash/maphash"
+	"math"
 	"net/http"
+	"net/netip"
+	"opm/config"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// Cost is how many tokens a request takes from its client's bucket
+type Cost int
+
+// Cost classes; a route costs CostRead or CostWrite by its method unless it is given a
+// class of its own with SetCost. CostSearch is for searches and other reads that are
+// expensive to serve.
+const (
+	CostRead   Cost = 1
+	CostSearch Cost = 3
+	CostWrite  Cost = 5
 )
 
-// Simple in-memory rate limiter
-type rateLimiter struct {
-	requests map[string][]time.Time
-	mu       sync.Mutex
-	limit    int
+// rateLimitShards spreads the buckets over this many locks
+const rateLimitShards = 32
+
+// RateLimiter is a token-bucket rate limiter keyed by signed-in user, or by client IP for
+// everyone else. Each bucket holds API_RATE_LIMIT tokens and refills completely over
+// API_RATE_WINDOW.
+type RateLimiter struct {
+	capacity float64
+	rate     float64 // tokens per second
 	window   time.Duration
+
+	jwtSecret []byte
+	proxies   TrustedProxies
+	costs     map[string]Cost // by route path template
+
+	seed   maphash.Seed
+	shards [rateLimitShards]rateLimitShard
+
+	now func() time.Time
+}
+
+type rateLimitShard struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a rate limiter from API_RATE_LIMIT, API_RATE_WINDOW and
+// TRUSTED_PROXIES
+func NewRateLimiter(cfg *config.Config) (*RateLimiter, error) {
+	limit, err := strconv.Atoi(cfg.RateLimit)
+	if err != nil || limit < 1 {
+		return nil, fmt.Errorf("invalid API_RATE_LIMIT %q", cfg.RateLimit)
+	}
+	window, err := time.ParseDuration(cfg.RateWindow)
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid API_RATE_WINDOW %q", cfg.RateWindow)
+	}
+	proxies, err := ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	rl := &RateLimiter{
+		capacity:  float64(limit),
+		rate:      float64(limit) / window.Seconds(),
+		window:    window,
+		jwtSecret: []byte(cfg.JWTSecret),
+		proxies:   proxies,
+		costs:     make(map[string]Cost),
+		seed:      maphash.MakeSeed(),
+		now:       time.Now,
+	}
+	for i := range rl.shards {
+		rl.shards[i].buckets = make(map[string]*bucket)
+	}
+	return rl, nil
+}
+
+// SetCost charges cost for every request to the routes with the given path templates. A
+// template may start with a method, as in "GET /archive", to leave the route's other
+// methods at their default cost.
+func (rl *RateLimiter) SetCost(cost Cost, pathTemplates ...string) {
+	for _, path := range pathTemplates {
+		rl.costs[path] = cost
+	}
+}
+
+// Middleware rejects requests once their client's bucket runs dry. It must run after
+// routing, as router middleware does, to see the route's cost class.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, remaining, reset, retryAfter := rl.take(rl.key(r), rl.cost(r))
+
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", int(rl.capacity), int(math.Ceil(rl.window.Seconds()))))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(int(rl.capacity)))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(reset)))
+
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
-// RateLimit creates a rate limiting middleware
-func RateLimit(limit string, window string) func(http.Handler) http.Handler {
-	// Parse limit and window
-	// TODO: Proper parsing
-	rl := &rateLimiter{
-		requests: make(map[string][]time.Time),
-		limit:    100, // Default to 100 requests
-		window:   time.Minute, // Default to 1 minute
-	}
-
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := r.RemoteAddr
-			
-			rl.mu.Lock()
-			defer rl.mu.Unlock()
-			
-			now := time.Now()
-			cutoff := now.Add(-rl.window)
-			
-			// Get existing requests for this IP
-			requests := rl.requests[ip]
-			
-			// Filter out old requests
-			var validRequests []time.Time
-			for _, reqTime := range requests {
-				if reqTime.After(cutoff) {
-					validRequests = append(validRequests, reqTime)
-				}
+// seconds rounds d up to whole seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// cost returns the cost class of the matched route, or of the method otherwise
+func (rl *RateLimiter) cost(r *http.Request) Cost {
+	if route := mux.CurrentRoute(r); route != nil {
+		if path, err := route.GetPathTemplate(); err == nil {
+			if cost, ok := rl.costs[r.Method+" "+path]; ok {
+				return cost
 			}
-			
-			// Check if limit exceeded
-			if len(validRequests) >= rl.limit {
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-				return
+			if cost, ok := rl.costs[path]; ok {
+				return cost
 			}
-			
-			// Add current request
-			validRequests = append(validRequests, now)
-			rl.requests[ip] = validRequests
-			
-			next.ServeHTTP(w, r)
-		})
-	}
-}
\ No newline at end of file
+		}
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return CostRead
+	}
+	return CostWrite
+}
+
+// key identifies the client by the user of a valid session token, so users behind one
+// address don't share a bucket. Anything else, including personal API tokens, which can
+// only be checked against the database, counts against the client IP.
+func (rl *RateLimiter) key(r *http.Request) string {
+	if token := requestToken(r); token != "" && !IsAPIToken(token) {
+		claims := &Claims{}
+		jwtToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+			return rl.jwtSecret, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		if err == nil && jwtToken.Valid && claims.UserID != 0 {
+			return "user:" + strconv.Itoa(claims.UserID)
+		}
+	}
+	ip := rl.proxies.clientIP(r)
+	// An IPv6 client usually has a whole /64 to pick addresses from
+	if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() {
+		prefix, _ := addr.Prefix(64)
+		return "ip:" + prefix.String()
+	}
+	return "ip:" + ip
+}
+
+// requestToken returns the token of the cookie or Authorization header
+func requestToken(r *http.Request) string {
+	if cookie, err := r.Cookie("token"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// take takes cost tokens from the bucket of key, reporting whether there were enough, the
+// tokens left, when the bucket will be full again and, when refused, when there will be
+// enough
+func (rl *RateLimiter) take(key string, cost Cost) (allowed bool, remaining int, reset, retryAfter time.Duration) {
+	// A cost above the limit could never be paid
+	need := math.Min(float64(cost), rl.capacity)
+
+	shard := &rl.shards[maphash.String(rl.seed, key)%rateLimitShards]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(shard, now)
+
+	b, ok := shard.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.capacity, last: now}
+		shard.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(rl.capacity, b.tokens+elapsed*rl.rate)
+	}
+	b.last = now
+
+	allowed = b.tokens >= need
+	if allowed {
+		b.tokens -= need
+	} else {
+		retryAfter = rl.refillTime(need - b.tokens)
+	}
+	return allowed, int(b.tokens), rl.refillTime(rl.capacity - b.tokens), retryAfter
+}
+
+func (rl *RateLimiter) refillTime(tokens float64) time.Duration {
+	return time.Duration(tokens / rl.rate * float64(time.Second))
+}
+
+// sweep drops the buckets of a shard that have refilled completely, which are the same as
+// no bucket, at most once per window. The caller holds shard.mu.
+func (rl *RateLimiter) sweep(shard *rateLimitShard, now time.Time) {
+	if now.Sub(shard.lastSweep) < rl.window {
+		return
+	}
+	shard.lastSweep = now
+	for key, b := range shard.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.capacity {
+			delete(shard.buckets, key)
+		}
+	}
+}
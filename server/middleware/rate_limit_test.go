@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"opm/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+const testSecret = "test-secret"
+
+// newTestLimiter returns a limiter of limit tokens per minute on a clock moved by the
+// returned function, serving every method on /items, /search and /archive
+func newTestLimiter(t *testing.T, limit int, trustedProxies string) (*RateLimiter, http.Handler, func(time.Duration)) {
+	t.Helper()
+	rl, err := NewRateLimiter(&config.Config{
+		RateLimit:      strconv.Itoa(limit),
+		RateWindow:     "1m",
+		TrustedProxies: trustedProxies,
+		JWTSecret:      testSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	rl.now = func() time.Time { return now }
+	rl.SetCost(CostSearch, "/search", "GET /archive")
+
+	r := mux.NewRouter()
+	r.Use(rl.Middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.HandleFunc("/items", ok)
+	r.HandleFunc("/search", ok)
+	r.HandleFunc("/archive", ok)
+	return rl, r, func(d time.Duration) { now = now.Add(d) }
+}
+
+func send(handler http.Handler, method, target, remoteAddr, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.RemoteAddr = remoteAddr
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func sessionToken(t *testing.T, userID int, secret string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "session",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestNewRateLimiterRejectsBadConfig(t *testing.T) {
+	for _, cfg := range []config.Config{
+		{RateLimit: "lots", RateWindow: "1m"},
+		{RateLimit: "0", RateWindow: "1m"},
+		{RateLimit: "100", RateWindow: "60"},
+		{RateLimit: "100", RateWindow: "1m", TrustedProxies: "10.0.0.0/33"},
+		{RateLimit: "100", RateWindow: "1m", TrustedProxies: "proxy.local"},
+	} {
+		if _, err := NewRateLimiter(&cfg); err == nil {
+			t.Errorf("NewRateLimiter(%+v) succeeded", cfg)
+		}
+	}
+}
+
+func TestRateLimitRefillsOverWindow(t *testing.T) {
+	_, handler, advance := newTestLimiter(t, 3, "")
+
+	for i := 0; i < 3; i++ {
+		w := send(handler, "GET", "/items", "192.0.2.1:1000", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i, w.Code)
+		}
+		if got, want := w.Header().Get("RateLimit-Remaining"), strconv.Itoa(2-i); got != want {
+			t.Errorf("request %d: RateLimit-Remaining = %s, want %s", i, got, want)
+		}
+	}
+
+	w := send(handler, "GET", "/items", "192.0.2.1:1001", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("fourth request: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	// One token comes back every 20 seconds, the whole bucket after a minute
+	if got := w.Header().Get("Retry-After"); got != "20" {
+		t.Errorf("Retry-After = %s, want 20", got)
+	}
+	if got := w.Header().Get("RateLimit-Reset"); got != "60" {
+		t.Errorf("RateLimit-Reset = %s, want 60", got)
+	}
+	if got := w.Header().Get("RateLimit-Policy"); got != "3;w=60" {
+		t.Errorf("RateLimit-Policy = %s, want 3;w=60", got)
+	}
+
+	advance(20 * time.Second)
+	if w := send(handler, "GET", "/items", "192.0.2.1:1002", ""); w.Code != http.StatusOK {
+		t.Errorf("after 20s: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := send(handler, "GET", "/items", "192.0.2.1:1003", ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second request after 20s: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitCostClasses(t *testing.T) {
+	_, handler, _ := newTestLimiter(t, 10, "")
+	addr := "192.0.2.1:1000"
+
+	// 10 tokens pay for a write, a search and two reads, but not another search
+	for _, req := range []struct{ method, target string }{
+		{"POST", "/items"}, {"GET", "/search"}, {"GET", "/items"}, {"GET", "/items"},
+	} {
+		if w := send(handler, req.method, req.target, addr, ""); w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status = %d", req.method, req.target, w.Code)
+		}
+	}
+	if w := send(handler, "GET", "/search", addr, ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("search with 0 tokens left: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// A class set for one method leaves the others at their default cost: 8 tokens pay
+	// for a download and an upload of an archive, but not another read
+	_, archives, _ := newTestLimiter(t, 8, "")
+	for _, method := range []string{"GET", "POST"} {
+		if w := send(archives, method, "/archive", addr, ""); w.Code != http.StatusOK {
+			t.Fatalf("%s /archive: status = %d", method, w.Code)
+		}
+	}
+	if w := send(archives, "GET", "/items", addr, ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("read with 0 tokens left: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// A cost above the limit is capped so it can still be paid
+	_, small, _ := newTestLimiter(t, 2, "")
+	if w := send(small, "DELETE", "/items", addr, ""); w.Code != http.StatusOK {
+		t.Errorf("write on a limit of 2: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimitKeysOnUser(t *testing.T) {
+	_, handler, _ := newTestLimiter(t, 1, "")
+	addr := "192.0.2.1:1000"
+	ada, bob := sessionToken(t, 1, testSecret), sessionToken(t, 2, testSecret)
+
+	// Users sharing an address have buckets of their own
+	if w := send(handler, "GET", "/items", addr, ada); w.Code != http.StatusOK {
+		t.Errorf("ada: status = %d", w.Code)
+	}
+	if w := send(handler, "GET", "/items", addr, bob); w.Code != http.StatusOK {
+		t.Errorf("bob: status = %d", w.Code)
+	}
+	if w := send(handler, "GET", "/items", "198.51.100.7:1000", ada); w.Code != http.StatusTooManyRequests {
+		t.Errorf("ada from another address: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// Forged tokens count against the address
+	forged := sessionToken(t, 3, "other-secret")
+	if w := send(handler, "GET", "/items", addr, forged); w.Code != http.StatusOK {
+		t.Errorf("first forged token: status = %d", w.Code)
+	}
+	if w := send(handler, "GET", "/items", addr, sessionToken(t, 4, "other-secret")); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second forged token: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	rl, _, _ := newTestLimiter(t, 1, "10.0.0.0/8, 127.0.0.1")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "192.0.2.1:1000", nil, "ip:192.0.2.1"},
+		{"untrusted proxy", "192.0.2.1:1000", []string{"198.51.100.7"}, "ip:192.0.2.1"},
+		{"trusted proxy", "127.0.0.1:1000", []string{"198.51.100.7"}, "ip:198.51.100.7"},
+		{"spoofed start", "127.0.0.1:1000", []string{"203.0.113.9, 198.51.100.7"}, "ip:198.51.100.7"},
+		{"proxy chain", "127.0.0.1:1000", []string{"198.51.100.7, 10.1.2.3", "10.4.5.6"}, "ip:198.51.100.7"},
+		{"garbage", "127.0.0.1:1000", []string{"198.51.100.7, nonsense"}, "ip:127.0.0.1"},
+		{"no header", "10.1.2.3:1000", nil, "ip:10.1.2.3"},
+		{"ipv6 /64", "[2001:db8:1:2:3:4:5:6]:1000", nil, "ip:2001:db8:1:2::/64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/items", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := rl.key(r); got != tt.want {
+				t.Errorf("key = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitEvictsRefilledBuckets(t *testing.T) {
+	rl, handler, advance := newTestLimiter(t, 2, "")
+	for i := 0; i < 50; i++ {
+		send(handler, "GET", "/items", "192.0.2."+strconv.Itoa(i)+":1000", "")
+	}
+
+	advance(time.Minute)
+	send(handler, "GET", "/items", "192.0.2.1:1000", "")
+
+	// Sweeping happens per shard as it is used, so sweep the rest explicitly
+	buckets := 0
+	for i := range rl.shards {
+		rl.sweep(&rl.shards[i], rl.now())
+		buckets += len(rl.shards[i].buckets)
+	}
+	if buckets != 1 {
+		t.Errorf("%d buckets left, want only the one used after a minute", buckets)
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"opm/logger"
+	"opm/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// sessionSeenInterval limits how often last_seen_at is written for an active session
+const sessionSeenInterval = 5 * time.Minute
+
+var errSessionRevoked = errors.New("session revoked or expired")
+
+// checkSession verifies the session a JWT was issued for has not been revoked and loads the
+// user's ban status into authUser. Tokens issued before sessions existed carry no jti and are
+// rejected.
+func checkSession(ctx context.Context, pool *pgxpool.Pool, claims *Claims, authUser *models.AuthUser) error {
+	if claims.ID == "" {
+		return errSessionRevoked
+	}
+
+	var lastSeen time.Time
+	err := pool.QueryRow(ctx, `
+		SELECT s.last_seen_at, `+banColumns+`
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > NOW()`,
+		claims.ID, claims.UserID,
+	).Scan(&lastSeen, &authUser.Ban.Banned, &authUser.Ban.Until, &authUser.Ban.Reason)
+	if err == pgx.ErrNoRows {
+		return errSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+
+	if time.Since(lastSeen) > sessionSeenInterval {
+		go touchSession(pool, claims.ID)
+	}
+	return nil
+}
+
+func touchSession(pool *pgxpool.Pool, sessionID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := pool.Exec(ctx, "UPDATE sessions SET last_seen_at = NOW() WHERE id = $1", sessionID); err != nil {
+		logger.MainLogger.Printf("Failed to update session %s: %v", sessionID, err)
+	}
+}
@@ -0,0 +1,315 @@
+// Package migrations versions the database schema. Migrations are numbered SQL files embedded
+// in the binary, NNNN_name.up.sql with a matching NNNN_name.down.sql, and the versions applied
+// to a database are recorded in its schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"opm/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockKey is the advisory lock held while migrating, so server instances starting together
+// don't apply the same migration twice
+const lockKey int64 = 0x6f706d5f6d6967 // "opm_mig"
+
+// Migration is one schema version
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied, if it was
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Load returns the embedded migrations ordered by version
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		file := entry.Name()
+		base, direction, ok := cutDirection(file)
+		if !ok {
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", file)
+		}
+		number, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name", file)
+		}
+
+		contents, err := files.ReadFile(path.Join("sql", file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func cutDirection(file string) (string, string, bool) {
+	if base, ok := strings.CutSuffix(file, ".up.sql"); ok {
+		return base, "up", true
+	}
+	if base, ok := strings.CutSuffix(file, ".down.sql"); ok {
+		return base, "down", true
+	}
+	return "", "", false
+}
+
+// Up applies every pending migration in order, each in its own transaction, and returns how
+// many it applied
+func Up(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	err = withLock(ctx, pool, func(conn *pgx.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		latest := migrations[len(migrations)-1].Version
+		for version := range done {
+			if version > latest {
+				return fmt.Errorf("database is at migration %d but this server only knows migrations up to %d", version, latest)
+			}
+		}
+
+		for _, m := range migrations {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			logger.MainLogger.Printf("✅ Applied migration %04d_%s", m.Version, m.Name)
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the latest steps applied migrations, newest first, and returns how many it
+// reverted
+func Down(ctx context.Context, pool *pgxpool.Pool, steps int) (int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return 0, err
+	}
+	byVersion := map[int]Migration{}
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	reverted := 0
+	err = withLock(ctx, pool, func(conn *pgx.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(done))
+		for version := range done {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for _, version := range versions {
+			if reverted == steps {
+				break
+			}
+			m, ok := byVersion[version]
+			if !ok {
+				return fmt.Errorf("migration %d is applied but unknown to this server", version)
+			}
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to revert migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			logger.MainLogger.Printf("↩️ Reverted migration %04d_%s", m.Version, m.Name)
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists the embedded migrations and when each was applied to the database
+func Status(ctx context.Context, pool *pgxpool.Pool) ([]MigrationStatus, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	appliedAt := map[int]time.Time{}
+	var exists bool
+	if err := pool.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to look up schema_migrations: %w", err)
+	}
+	if exists {
+		rows, err := pool.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var version int
+			var at time.Time
+			if err := rows.Scan(&version, &at); err != nil {
+				return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+			}
+			appliedAt[version] = at
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{Migration: m}
+		if at, ok := appliedAt[m.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// withLock runs fn on one connection while holding the migration advisory lock. Waiting
+// for the lock and migrating can outlast the pool's statement timeout, so the connection
+// is taken out of the pool, has the timeout lifted and is closed afterwards, which also
+// releases the lock.
+func withLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgx.Conn) error) error {
+	pooled, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection for migrating: %w", err)
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "SET statement_timeout = 0"); err != nil {
+		return fmt.Errorf("failed to lift statement timeout for migrating: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// ensureTable creates schema_migrations. A database set up from schema-mvp.sql before
+// migrations existed has exactly the 0001 schema, which is recorded instead of applied,
+// and the later migrations then upgrade it.
+func ensureTable(ctx context.Context, conn *pgx.Conn) error {
+	var exists, hasSchema bool
+	err := conn.QueryRow(ctx, `
+		SELECT to_regclass('schema_migrations') IS NOT NULL, to_regclass('packages') IS NOT NULL`,
+	).Scan(&exists, &hasSchema)
+	if err != nil {
+		return fmt.Errorf("failed to look up schema_migrations: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			CREATE TABLE schema_migrations (
+			    version INTEGER PRIMARY KEY,
+			    name VARCHAR(255) NOT NULL,
+			    applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`)
+		if err != nil {
+			return fmt.Errorf("failed to create schema_migrations: %w", err)
+		}
+		if hasSchema {
+			if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES (1, 'initial_schema')"); err != nil {
+				return fmt.Errorf("failed to record the existing schema: %w", err)
+			}
+			logger.MainLogger.Println("📋 Existing schema recorded as migration 0001_initial_schema")
+		}
+		return nil
+	})
+}
+
+// appliedVersions returns the versions recorded in schema_migrations
+func appliedVersions(ctx context.Context, conn *pgx.Conn) (map[int]struct{}, error) {
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	done := make(map[int]struct{}, len(versions))
+	for _, version := range versions {
+		done[version] = struct{}{}
+	}
+	return done, nil
+}
@@ -0,0 +1,154 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"opm/logger"
+	"opm/pgtest"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// cluster is the running test cluster, nil when the database tests are skipped
+var cluster *pgtest.Cluster
+
+func TestMain(m *testing.M) {
+	logger.MainLogger = log.New(io.Discard, "", 0)
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	c, err := pgtest.Start()
+	if errors.Is(err, pgtest.ErrNoPostgres) {
+		fmt.Fprintf(os.Stderr, "skipping database tests: %v\n", err)
+		return m.Run()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start PostgreSQL: %v\n", err)
+		return 1
+	}
+	defer c.Stop()
+
+	cluster = c
+	return m.Run()
+}
+
+// newDatabase returns a pool on an empty database of the running test
+func newDatabase(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	if cluster == nil {
+		t.Skip(pgtest.SkipMessage)
+	}
+	name := cluster.CreateDatabase(t, "")
+	pool, err := pgxpool.New(context.Background(), cluster.DSN(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d is numbered %04d, want %04d", i, m.Version, i+1)
+		}
+	}
+	if len(migrations) < 2 || migrations[0].Name != "initial_schema" {
+		t.Fatalf("migrations = %d, want 0001_initial_schema and later ones", len(migrations))
+	}
+}
+
+// TestUpgradesBaselineSchema migrates a database set up from schema-mvp.sql before
+// migrations existed, which is what 0001 holds
+func TestUpgradesBaselineSchema(t *testing.T) {
+	pool := newDatabase(t)
+	ctx := context.Background()
+
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Exec(ctx, migrations[0].Up); err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.Exec(ctx, `
+		INSERT INTO users (username, slug) VALUES ('ada', 'ada');
+		INSERT INTO packages (author_id, slug, display_name, description, type, status, repository_url)
+		SELECT id, 'parser', 'Parser', 'Parses things', 'library', 'ready', 'https://example.com/ada/parser'
+		FROM users WHERE slug = 'ada';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := Up(ctx, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations)-1 {
+		t.Errorf("applied %d migrations, want every one after 0001 (%d)", applied, len(migrations)-1)
+	}
+
+	statuses, err := Status(ctx, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Errorf("migration %04d_%s is not recorded", s.Version, s.Name)
+		}
+	}
+
+	// The later tables and columns exist, and the existing rows survived
+	var dependents int
+	var bannedUntil, canonicalID interface{}
+	err = pool.QueryRow(ctx, `
+		SELECT p.dependents_count, u.banned_until,
+		       (SELECT canonical_id FROM tags LIMIT 1)
+		FROM packages p JOIN users u ON u.id = p.author_id
+		WHERE p.slug = 'parser'`,
+	).Scan(&dependents, &bannedUntil, &canonicalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"package_versions", "package_dependencies", "sessions", "api_tokens", "audit_events", "package_trending"} {
+		var exists bool
+		if err := pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Errorf("table %s is missing after the upgrade", table)
+		}
+	}
+}
+
+// TestUpDown applies every migration to an empty database, reverts them all and applies
+// them again, which only works when each down file undoes its up file completely
+func TestUpDown(t *testing.T) {
+	pool := newDatabase(t)
+	ctx := context.Background()
+
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := Up(ctx, pool); err != nil || applied != len(migrations) {
+		t.Fatalf("Up = %d, %v; want %d", applied, err, len(migrations))
+	}
+	if reverted, err := Down(ctx, pool, len(migrations)); err != nil || reverted != len(migrations) {
+		t.Fatalf("Down = %d, %v; want %d", reverted, err, len(migrations))
+	}
+	if applied, err := Up(ctx, pool); err != nil || applied != len(migrations) {
+		t.Fatalf("Up after Down = %d, %v; want %d", applied, err, len(migrations))
+	}
+}
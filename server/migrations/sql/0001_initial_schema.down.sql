@@ -0,0 +1,26 @@
+-- Drops everything 0001_initial_schema.up.sql creates except the pg_trgm extension, which
+-- other database objects may use
+
+-- Triggers go with their tables
+DROP TABLE IF EXISTS
+    flags,
+    bookmarks,
+    tag_votes,
+    package_tags,
+    tags,
+    package_views,
+    packages,
+    users
+    CASCADE;
+
+DROP FUNCTION IF EXISTS
+    update_package_search_vector(),
+    update_package_search_vector_by_id(INTEGER),
+    track_package_view(INTEGER, INTEGER),
+    update_tag_search_vector(),
+    update_updated_at(),
+    update_tag_usage_count(),
+    update_bookmark_count(),
+    trigger_update_package_search_on_tag_change();
+
+DROP TYPE IF EXISTS package_status, package_type;
@@ -0,0 +1,52 @@
+-- Reverts 0002_versions_sessions_moderation.up.sql, back to the schema of schema-mvp.sql
+
+DROP MATERIALIZED VIEW IF EXISTS package_trending;
+
+-- Triggers and indexes go with their tables and columns
+DROP TABLE IF EXISTS
+    audit_events,
+    user_bans,
+    sessions,
+    api_tokens,
+    package_snapshots,
+    package_dependencies,
+    package_manifests,
+    package_versions
+    CASCADE;
+
+DROP TRIGGER IF EXISTS users_ban_revoke_sessions ON users;
+DROP TRIGGER IF EXISTS packages_link_dependencies ON packages;
+
+DROP TRIGGER IF EXISTS packages_updated_at ON packages;
+CREATE TRIGGER packages_updated_at BEFORE UPDATE ON packages
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at();
+
+DROP FUNCTION IF EXISTS
+    update_dependents_count(),
+    touch_package_on_publish(),
+    link_package_dependencies(),
+    revoke_sessions_on_ban(),
+    prevent_audit_event_change();
+
+DROP INDEX IF EXISTS
+    idx_packages_updated_at,
+    idx_packages_slug_trgm,
+    idx_tags_match_key,
+    idx_package_views_viewed_at,
+    idx_tag_votes_package_recent,
+    idx_flags_user;
+
+ALTER TABLE tags
+    DROP COLUMN IF EXISTS canonical_id,
+    DROP COLUMN IF EXISTS category;
+DROP TYPE IF EXISTS tag_category;
+
+ALTER TABLE packages
+    DROP COLUMN IF EXISTS dependents_count,
+    DROP COLUMN IF EXISTS quarantined_at,
+    DROP COLUMN IF EXISTS quarantine_reason;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS banned_until,
+    DROP COLUMN IF EXISTS ban_reason,
+    DROP COLUMN IF EXISTS packages_hidden;
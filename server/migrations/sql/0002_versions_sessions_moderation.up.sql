@@ -0,0 +1,269 @@
+-- Everything added to the schema since schema-mvp.sql: versions, manifests and
+-- dependencies, snapshots, tokens and sessions, tag categories and aliases, moderation
+-- and the audit log
+
+-----------------------------------------------------------------------------------
+-- Users
+
+ALTER TABLE users
+    ADD COLUMN banned_until TIMESTAMPTZ, -- NULL with is_banned means permanent; a suspension ends here
+    ADD COLUMN ban_reason TEXT,
+    ADD COLUMN packages_hidden BOOLEAN NOT NULL DEFAULT FALSE; -- hide the author's packages from listings while banned
+
+-----------------------------------------------------------------------------------
+-- Packages
+
+ALTER TABLE packages
+    ADD COLUMN dependents_count INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN quarantined_at TIMESTAMPTZ, -- set when weighted flags cross a threshold; hidden from listings until cleared
+    ADD COLUMN quarantine_reason VARCHAR(50); -- the flag reason that crossed its threshold
+
+CREATE INDEX idx_packages_dependents_count ON packages(dependents_count DESC);
+CREATE INDEX idx_packages_updated_at ON packages(updated_at DESC);
+CREATE INDEX idx_packages_slug_trgm ON packages USING GIN(slug gin_trgm_ops);
+CREATE INDEX idx_packages_quarantined ON packages(quarantined_at) WHERE quarantined_at IS NOT NULL;
+
+CREATE INDEX idx_package_views_viewed_at ON package_views(viewed_at);
+
+-----------------------------------------------------------------------------------
+-- Tags
+
+CREATE TYPE tag_category AS ENUM ('domain', 'platform', 'feature');
+
+-- Tag names are normalized: lowercase letters and digits separated by hyphens
+ALTER TABLE tags
+    ADD COLUMN category tag_category,
+    ADD COLUMN canonical_id INTEGER REFERENCES tags(id) ON DELETE CASCADE, -- set on aliases, which are never attached to packages
+    ADD CONSTRAINT tags_alias_not_self CHECK (canonical_id <> id);
+
+CREATE INDEX idx_tags_match_key ON tags((replace(name, '-', ''))); -- "gamedev" matches "game-dev"
+CREATE INDEX idx_tags_canonical ON tags(canonical_id) WHERE canonical_id IS NOT NULL;
+
+CREATE INDEX idx_tag_votes_package_recent ON tag_votes(package_id, updated_at DESC);
+
+CREATE INDEX idx_flags_user ON flags(user_id, status);
+
+-----------------------------------------------------------------------------------
+-- New tables
+
+-- Package versions (semver releases backed by repository git tags)
+CREATE TABLE package_versions (
+    id SERIAL PRIMARY KEY,
+    package_id INTEGER NOT NULL REFERENCES packages(id) ON DELETE CASCADE,
+    version VARCHAR(100) NOT NULL, -- canonical semver, without leading 'v' or build metadata
+    major INTEGER NOT NULL,
+    minor INTEGER NOT NULL,
+    patch INTEGER NOT NULL,
+    prerelease VARCHAR(100) NOT NULL DEFAULT '',
+    prerelease_key TEXT COLLATE "C" NOT NULL DEFAULT '', -- sorts prereleases by semver precedence
+    tag_name VARCHAR(255) NOT NULL,
+    commit_sha VARCHAR(64) NOT NULL CHECK (commit_sha ~ '^[0-9a-f]{7,64}$'),
+    release_notes TEXT,
+    yanked BOOLEAN NOT NULL DEFAULT FALSE,
+    yanked_at TIMESTAMPTZ,
+    published_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    published_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(package_id, version),
+    UNIQUE(package_id, tag_name)
+);
+
+-- Package manifests (opm.json / mod.pkg parsed from the repository root)
+CREATE TABLE package_manifests (
+    package_id INTEGER PRIMARY KEY REFERENCES packages(id) ON DELETE CASCADE,
+    source_file VARCHAR(50) NOT NULL, -- opm.json, mod.pkg
+    name VARCHAR(100),
+    version VARCHAR(100) NOT NULL,
+    description TEXT,
+    license VARCHAR(100),
+    min_odin_version VARCHAR(50),
+    raw JSONB NOT NULL,
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Package dependencies, declared in the package manifest or added by hand by the author.
+-- Dependencies are declared by name and linked to the package of that name once it exists.
+CREATE TABLE package_dependencies (
+    package_id INTEGER NOT NULL REFERENCES packages(id) ON DELETE CASCADE,
+    name VARCHAR(201) NOT NULL, -- author-slug/package-slug
+    dependency_id INTEGER REFERENCES packages(id) ON DELETE SET NULL, -- NULL while no package has the name
+    version_constraint VARCHAR(100) NOT NULL DEFAULT '*',
+    source VARCHAR(10) NOT NULL CHECK (source IN ('manifest', 'manual')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (package_id, name),
+    CONSTRAINT package_dependencies_no_self CHECK (package_id <> dependency_id)
+);
+
+-- Source archive snapshots (normalized tar.gz of a repository tree at a commit)
+CREATE TABLE package_snapshots (
+    id SERIAL PRIMARY KEY,
+    package_id INTEGER NOT NULL REFERENCES packages(id) ON DELETE CASCADE,
+    ref VARCHAR(255) NOT NULL, -- ref as requested, '' for the default branch
+    commit_sha VARCHAR(64) NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    storage_key TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(package_id, commit_sha)
+);
+
+-- Personal API tokens (only the SHA-256 of the token is stored)
+CREATE TABLE api_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    token_prefix VARCHAR(16) NOT NULL, -- first characters, shown so users can tell tokens apart
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    last_used_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Login sessions, one per issued JWT (keyed by its jti claim)
+CREATE TABLE sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent TEXT,
+    ip_address VARCHAR(64),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+-- Ban, suspension and unban history
+CREATE TABLE user_bans (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    moderator_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('ban', 'suspend', 'unban')),
+    reason TEXT NOT NULL,
+    expires_at TIMESTAMPTZ,
+    hide_packages BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Append-only audit log of privileged actions
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INTEGER, -- no foreign key: events outlive the users they mention
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20) NOT NULL,
+    target_id VARCHAR(100) NOT NULL,
+    before JSONB,
+    after JSONB,
+    request_id VARCHAR(32),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Trending score per package: daily views of the last 14 days, each day's weight halving
+-- every 3 days. Refreshed periodically by the server (REFRESH ... CONCURRENTLY needs the
+-- unique index).
+CREATE MATERIALIZED VIEW package_trending AS
+SELECT package_id,
+       SUM(power(0.5, (CURRENT_DATE - viewed_at) / 3.0))::DOUBLE PRECISION AS score
+FROM package_views
+WHERE viewed_at > CURRENT_DATE - 14
+GROUP BY package_id;
+CREATE UNIQUE INDEX idx_package_trending_package ON package_trending(package_id);
+
+CREATE INDEX idx_package_dependencies_dependency ON package_dependencies(dependency_id);
+
+CREATE INDEX idx_package_snapshots_ref ON package_snapshots(package_id, ref, created_at DESC);
+
+CREATE INDEX idx_package_versions_order ON package_versions(package_id, major DESC, minor DESC, patch DESC);
+
+CREATE INDEX idx_api_tokens_user ON api_tokens(user_id);
+
+CREATE INDEX idx_sessions_user ON sessions(user_id, created_at DESC);
+
+CREATE INDEX idx_user_bans_user ON user_bans(user_id, created_at DESC);
+
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at DESC);
+CREATE INDEX idx_audit_events_target ON audit_events(target_type, target_id, created_at DESC);
+CREATE INDEX idx_audit_events_actor ON audit_events(actor_id, created_at DESC);
+CREATE INDEX idx_audit_events_action ON audit_events(action, created_at DESC);
+
+-----------------------------------------------------------------------------------
+-- Triggers
+
+-- Update dependents count trigger; only dependencies linked to a package count
+CREATE OR REPLACE FUNCTION update_dependents_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE packages SET dependents_count = dependents_count - 1 WHERE id = OLD.dependency_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE packages SET dependents_count = dependents_count + 1 WHERE id = NEW.dependency_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER package_dependencies_count_update
+    AFTER INSERT OR DELETE OR UPDATE OF dependency_id ON package_dependencies
+    FOR EACH ROW EXECUTE FUNCTION update_dependents_count();
+
+-- "Recently updated" sorts on updated_at, so only changes to the package itself move it,
+-- not its view, bookmark and dependents counts, quarantine or search vector
+DROP TRIGGER packages_updated_at ON packages;
+CREATE TRIGGER packages_updated_at
+    BEFORE UPDATE OF slug, display_name, description, type, status, repository_url, license ON packages
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at();
+
+-- Publishing a version updates its package too
+CREATE OR REPLACE FUNCTION touch_package_on_publish() RETURNS trigger AS $$
+BEGIN
+    UPDATE packages SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.package_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER package_versions_touch_package
+    AFTER INSERT ON package_versions
+    FOR EACH ROW EXECUTE FUNCTION touch_package_on_publish();
+
+-- Link dependencies declared before their package existed to the new package
+CREATE OR REPLACE FUNCTION link_package_dependencies() RETURNS trigger AS $$
+BEGIN
+    UPDATE package_dependencies d
+    SET dependency_id = NEW.id
+    FROM users u
+    WHERE u.id = NEW.author_id
+      AND d.name = u.slug || '/' || NEW.slug
+      AND d.dependency_id IS NULL
+      AND d.package_id <> NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER packages_link_dependencies
+    AFTER INSERT ON packages
+    FOR EACH ROW EXECUTE FUNCTION link_package_dependencies();
+
+-- Revoke all sessions when a user is banned or suspended
+CREATE OR REPLACE FUNCTION revoke_sessions_on_ban() RETURNS trigger AS $$
+BEGIN
+    IF NEW.is_banned AND (NOT OLD.is_banned OR NEW.banned_until IS DISTINCT FROM OLD.banned_until) THEN
+        UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+        WHERE user_id = NEW.id AND revoked_at IS NULL;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_ban_revoke_sessions
+    AFTER UPDATE OF is_banned, banned_until ON users
+    FOR EACH ROW EXECUTE FUNCTION revoke_sessions_on_ban();
+
+-- Keep the audit log append-only
+CREATE OR REPLACE FUNCTION prevent_audit_event_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION prevent_audit_event_change();
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -19,33 +21,165 @@ type User struct {
 	DiscordVerified  bool       `json:"discord_verified"`
 	GitHubVerified   bool       `json:"github_verified"`
 	IsBanned         bool       `json:"is_banned"`
+	BannedUntil      *time.Time `json:"banned_until,omitempty"`
 	CreatedAt        time.Time  `json:"created_at"`
 	UpdatedAt        time.Time  `json:"updated_at"`
 }
 
 // Package represents a package in the registry
 type Package struct {
-	ID            int           `json:"id"`
-	Slug          string        `json:"slug"`  // URL-safe name
-	DisplayName   string        `json:"display_name"`
-	Description   string        `json:"description"`
-	Type          PackageType   `json:"type"`
-	Status        PackageStatus `json:"status"`
-	RepositoryURL string        `json:"repository_url"`
-	License       *string       `json:"license,omitempty"`
-	AuthorID      int           `json:"author_id"`
-	Author        *User         `json:"author,omitempty"`
-	Tags          []Tag         `json:"tags,omitempty"`
-	CreatedAt     time.Time     `json:"created_at"`
-	UpdatedAt     time.Time     `json:"updated_at"`
+	ID            int             `json:"id"`
+	Slug          string          `json:"slug"`  // URL-safe name
+	DisplayName   string          `json:"display_name"`
+	Description   string          `json:"description"`
+	Type          PackageType     `json:"type"`
+	Status        PackageStatus   `json:"status"`
+	RepositoryURL string          `json:"repository_url"`
+	License       *string         `json:"license,omitempty"`
+	AuthorID      int             `json:"author_id"`
+	Author        *User           `json:"author,omitempty"`
+	Tags          []Tag           `json:"tags,omitempty"`
+	LatestVersion *PackageVersion `json:"latest_version,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
 	
 	// Stats
-	ViewCount     int  `json:"view_count"`
-	BookmarkCount int  `json:"bookmark_count"`
+	ViewCount       int  `json:"view_count"`
+	BookmarkCount   int  `json:"bookmark_count"`
+	DependentsCount int  `json:"dependents_count"`
+	TrendingScore   float64 `json:"trending_score"` // Decayed views of the last 14 days
 	
 	// Computed fields
 	IsBookmarked       bool `json:"is_bookmarked"`
 	ActiveReportsCount int  `json:"active_reports_count"`
+
+	// Set while the package is quarantined
+	Quarantine *QuarantineWarning `json:"quarantine,omitempty"`
+}
+
+// QuarantineWarning is shown on a package that was hidden pending moderator review
+type QuarantineWarning struct {
+	Reason  string    `json:"reason"`
+	Since   time.Time `json:"since"`
+	Message string    `json:"message"`
+}
+
+// NewQuarantineWarning describes a package quarantined for reason since the given time
+func NewQuarantineWarning(reason string, since time.Time) *QuarantineWarning {
+	return &QuarantineWarning{
+		Reason:  reason,
+		Since:   since,
+		Message: fmt.Sprintf("This package was flagged by several users for %q and is hidden from listings until a moderator reviews it.", reason),
+	}
+}
+
+// PackageVersion represents a published release of a package, backed by a git tag
+type PackageVersion struct {
+	ID           int        `json:"id"`
+	PackageID    int        `json:"package_id"`
+	Version      string     `json:"version"` // Semver, without leading "v"
+	TagName      string     `json:"tag_name"`
+	CommitSHA    string     `json:"commit_sha"`
+	ReleaseNotes *string    `json:"release_notes,omitempty"`
+	Yanked       bool       `json:"yanked"`
+	YankedAt     *time.Time `json:"yanked_at,omitempty"`
+	PublishedBy  *int       `json:"published_by,omitempty"`
+	PublishedAt  time.Time  `json:"published_at"`
+}
+
+// PublishVersionInput represents the input for publishing a package version
+type PublishVersionInput struct {
+	Version      string  `json:"version" validate:"required,semver"`
+	TagName      *string `json:"tag_name,omitempty"` // Defaults to "v" + version
+	CommitSHA    string  `json:"commit_sha" validate:"required,hexadecimal"`
+	ReleaseNotes *string `json:"release_notes,omitempty"`
+}
+
+// PackageDependency represents an edge in the dependency graph, seen from the package being queried
+type PackageDependency struct {
+	PackageID         int    `json:"package_id"`
+	Slug              string `json:"slug"`
+	DisplayName       string `json:"display_name"`
+	AuthorSlug        string `json:"author_slug"`
+	VersionConstraint string `json:"version_constraint"`
+	Source            string `json:"source"`    // manifest or manual
+	Depth             int    `json:"depth"`     // 1 for direct edges
+	ParentID          int    `json:"parent_id"` // Package on the other end of this edge
+	Cycle             bool   `json:"cycle"`     // Edge is part of a cycle: its package leads back to ParentID
+}
+
+// AddDependencyInput represents the input for declaring a dependency on another package
+type AddDependencyInput struct {
+	Dependency        string `json:"dependency" validate:"required"` // author-slug/package-slug
+	VersionConstraint string `json:"version_constraint,omitempty"`
+}
+
+// PackageManifest represents the manifest file (opm.json or mod.pkg) found in a package repository
+type PackageManifest struct {
+	PackageID      int                  `json:"package_id"`
+	SourceFile     string               `json:"source_file"`
+	Name           *string              `json:"name,omitempty"`
+	Version        string               `json:"version"`
+	Description    *string              `json:"description,omitempty"`
+	License        *string              `json:"license,omitempty"`
+	MinOdinVersion *string              `json:"min_odin_version,omitempty"`
+	Dependencies   []ManifestDependency `json:"dependencies"`
+	FetchedAt      time.Time            `json:"fetched_at"`
+}
+
+// ManifestDependency represents a dependency declared in a package manifest
+type ManifestDependency struct {
+	Name              string `json:"name"` // author-slug/package-slug
+	VersionConstraint string `json:"version_constraint"`
+}
+
+// PackageSnapshot represents a stored source archive of a package at a commit
+type PackageSnapshot struct {
+	ID         int       `json:"id"`
+	PackageID  int       `json:"package_id"`
+	Ref        string    `json:"ref"`
+	CommitSHA  string    `json:"commit_sha"`
+	SHA256     string    `json:"sha256"`
+	SizeBytes  int64     `json:"size_bytes"`
+	StorageKey string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Lockfile pins a set of package requirements to exact commits
+type Lockfile struct {
+	LockfileVersion int             `json:"lockfile_version"`
+	GeneratedAt     time.Time       `json:"generated_at"`
+	Packages        []LockedPackage `json:"packages"`
+}
+
+// LockedPackage is a single resolved requirement in a lockfile
+type LockedPackage struct {
+	Name          string `json:"name"`                // author-slug/package-slug
+	Requested     string `json:"requested,omitempty"` // Ref or version constraint as requested
+	Version       string `json:"version,omitempty"`   // Published version, if the ref is a release
+	Ref           string `json:"ref"`
+	RefType       string `json:"ref_type"` // tag, branch, default_branch, commit
+	Commit        string `json:"commit"`
+	RepositoryURL string `json:"repository_url"`
+}
+
+// ResolveInput represents the input for resolving requirements, or verifying a lockfile
+type ResolveInput struct {
+	Requirements []string  `json:"requirements"` // author/pkg[@ref]
+	Lockfile     *Lockfile `json:"lockfile,omitempty"`
+}
+
+// ResolveError explains why a requirement or locked package could not be resolved
+type ResolveError struct {
+	Requirement string `json:"requirement"`
+	Reason      string `json:"reason"`
+}
+
+// ResolveResult is the response of the resolve endpoint
+type ResolveResult struct {
+	Lockfile Lockfile       `json:"lockfile"`
+	Errors   []ResolveError `json:"errors"`
+	Verified *bool          `json:"verified,omitempty"` // Only set when verifying a lockfile
 }
 
 // Tag represents a tag that can be applied to packages
@@ -55,6 +189,8 @@ type Tag struct {
 	AddedBy    *int      `json:"added_by,omitempty"`
 	UsageCount int       `json:"usage_count"`
 	CreatedAt  time.Time `json:"created_at"`
+	Category   *TagCategory `json:"category,omitempty"`
+	Aliases    []string     `json:"aliases,omitempty"` // Other names resolving to this tag
 	
 	// For package-tag relation
 	NetScore  int `json:"net_score"`  // Sum of all votes
@@ -98,14 +234,102 @@ type PackageFilter struct {
 	Tags     []string       `json:"tags,omitempty"`  // Tag names, not slugs
 	AuthorID *int           `json:"author_id,omitempty"`
 	Search   *string        `json:"search,omitempty"`
-	Limit    int            `json:"limit,omitempty"`
-	Offset   int            `json:"offset,omitempty"`
 }
 
 // AuthUser represents the authenticated user stored in context
 type AuthUser struct {
-	UserID int
-	Token  string
+	UserID     int
+	Token      string
+	SessionID  string   // jti of the session JWT
+	APITokenID int      // Set when authenticated with a personal API token instead of a session
+	Scopes     []string // Scopes granted to the API token; sessions are not restricted
+	Ban        BanStatus
+}
+
+// UserBan is an entry in a user's ban history
+type UserBan struct {
+	ID                int        `json:"id"`
+	UserID            int        `json:"user_id"`
+	ModeratorID       *int       `json:"moderator_id,omitempty"`
+	ModeratorUsername *string    `json:"moderator_username,omitempty"`
+	Action            string     `json:"action"` // ban, suspend, unban
+	Reason            string     `json:"reason"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	HidePackages      bool       `json:"hide_packages"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// BanUserInput represents a moderator's input for banning, suspending or unbanning a user
+type BanUserInput struct {
+	Reason        string `json:"reason"`
+	DurationHours int    `json:"duration_hours,omitempty"` // Required for a suspension
+	HidePackages  bool   `json:"hide_packages,omitempty"`  // Hide the user's packages from listings and search while banned
+}
+
+// BanStatus is the ban or suspension currently in effect for a user, if any
+type BanStatus struct {
+	Banned bool
+	Until  *time.Time // End of a suspension; nil for a permanent ban
+	Reason *string
+}
+
+// HasScope reports whether the request may act with scope. Browser sessions hold every scope.
+func (a *AuthUser) HasScope(scope string) bool {
+	if a.APITokenID == 0 {
+		return true
+	}
+	for _, s := range a.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Session represents a login on one device
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  *string   `json:"user_agent,omitempty"`
+	IPAddress  *string   `json:"ip_address,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// API token scopes
+const (
+	ScopePackagesWrite  = "packages:write"
+	ScopeTagsVote       = "tags:vote"
+	ScopeFlagsWrite     = "flags:write"
+	ScopeBookmarksWrite = "bookmarks:write"
+)
+
+// APITokenScopes lists every scope a token can be granted
+var APITokenScopes = []string{ScopePackagesWrite, ScopeTagsVote, ScopeFlagsWrite, ScopeBookmarksWrite}
+
+// APIToken represents a personal API token. The token itself is only returned once, on creation.
+type APIToken struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	TokenPrefix string     `json:"token_prefix"`
+	Scopes      []string   `json:"scopes"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Token       string     `json:"token,omitempty"`
+}
+
+// CreateAPITokenInput represents the input for creating an API token
+type CreateAPITokenInput struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays *int     `json:"expires_in_days,omitempty"` // Omit for a token that never expires
+}
+
+// UpdateAPITokenInput represents the input for renaming an API token
+type UpdateAPITokenInput struct {
+	Name string `json:"name"`
 }
 
 // PackageView represents a view of a package
@@ -136,6 +360,59 @@ type Flag struct {
 	UpdatedAt  time.Time  `json:"updated_at"`
 }
 
+// FlagWithContext is a flag with the package and users it involves, as moderators list them
+type FlagWithContext struct {
+	Flag
+	PackageSlug        string  `json:"package_slug"`
+	PackageDisplayName string  `json:"package_display_name"`
+	ReporterUsername   string  `json:"reporter_username"`
+	ResolverUsername   *string `json:"resolver_username,omitempty"`
+}
+
+// UserFlag is a flag as its reporter sees it
+type UserFlag struct {
+	ID                 int        `json:"id"`
+	PackageID          int        `json:"package_id"`
+	Reason             string     `json:"reason"`
+	Details            *string    `json:"details,omitempty"`
+	Status             string     `json:"status"`
+	ResolvedAt         *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	PackageSlug        string     `json:"package_slug"`
+	PackageDisplayName string     `json:"package_display_name"`
+	AuthorSlug         string     `json:"author_slug"`
+}
+
+// FlagStats summarizes the flags of a package
+type FlagStats struct {
+	PendingCount   int        `json:"pending_count"`
+	ReviewedCount  int        `json:"reviewed_count"`
+	ResolvedCount  int        `json:"resolved_count"`
+	DismissedCount int        `json:"dismissed_count"`
+	TotalCount     int        `json:"total_count"`
+	UniqueReasons  int        `json:"unique_reasons"`
+	LastFlagDate   *time.Time `json:"last_flag_date,omitempty"`
+}
+
+// FlagReasonCount is how often a package was flagged for a reason
+type FlagReasonCount struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+// QuarantinedPackage is a package waiting for moderator review
+type QuarantinedPackage struct {
+	PackageID          int       `json:"package_id"`
+	PackageSlug        string    `json:"package_slug"`
+	PackageDisplayName string    `json:"package_display_name"`
+	AuthorSlug         string    `json:"author_slug"`
+	Reason             string    `json:"reason"`
+	QuarantinedAt      time.Time `json:"quarantined_at"`
+	PendingFlags       int       `json:"pending_flags"`
+	Score              float64   `json:"score"`
+	Threshold          float64   `json:"threshold"`
+}
+
 // TagVote represents a user's vote on a package tag
 type TagVote struct {
 	ID        int       `json:"id"`
@@ -156,4 +433,57 @@ type CreateFlagInput struct {
 // TagVoteInput represents the input for voting on a tag
 type TagVoteInput struct {
 	Vote int `json:"vote" validate:"required,min=-1,max=1"` // -1, 0, or 1
-}
\ No newline at end of file
+}
+
+// AuditEvent is an entry of the moderation audit log
+type AuditEvent struct {
+	ID            int64           `json:"id"`
+	ActorID       *int            `json:"actor_id,omitempty"`
+	ActorUsername *string         `json:"actor_username,omitempty"`
+	Action        string          `json:"action"`
+	TargetType    string          `json:"target_type"`
+	TargetID      string          `json:"target_id"`
+	Before        json.RawMessage `json:"before,omitempty"`
+	After         json.RawMessage `json:"after,omitempty"`
+	RequestID     *string         `json:"request_id,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// ReputationBreakdown explains how a user's reputation adds up
+type ReputationBreakdown struct {
+	UserID     int                `json:"user_id"`
+	Reputation int                `json:"reputation"`
+	Rank       string             `json:"rank"`
+	NextRank   *string            `json:"next_rank,omitempty"`
+	NextRankAt *int               `json:"next_rank_at,omitempty"` // Reputation needed for NextRank
+	Sources    []ReputationSource `json:"sources"`
+}
+
+// ReputationSource is one kind of event contributing to a user's reputation
+type ReputationSource struct {
+	Source string `json:"source"`
+	Count  int    `json:"count"`
+	Points int    `json:"points"`
+}
+
+// PackageFacets holds the counts requested with the facets parameter of a package listing
+type PackageFacets struct {
+	Type    map[string]int `json:"type,omitempty"`
+	Status  map[string]int `json:"status,omitempty"`
+	License map[string]int `json:"license,omitempty"`
+	Tag     []TagFacet     `json:"tag,omitempty"`
+}
+
+// TagFacet is a tag occurring on the listed packages
+type TagFacet struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// PackageSuggestion is an autocomplete entry for a package
+type PackageSuggestion struct {
+	Slug        string      `json:"slug"`
+	DisplayName string      `json:"display_name"`
+	Type        PackageType `json:"type"`
+	AuthorSlug  string      `json:"author_slug"`
+}
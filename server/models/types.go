@@ -70,6 +70,15 @@ const (
 	TagCategoryFeature  TagCategory = "feature"
 )
 
+// IsValid reports whether tc is one of the known categories
+func (tc TagCategory) IsValid() bool {
+	switch tc {
+	case TagCategoryDomain, TagCategoryPlatform, TagCategoryFeature:
+		return true
+	}
+	return false
+}
+
 func (tc *TagCategory) Scan(value interface{}) error {
 	if value == nil {
 		return nil
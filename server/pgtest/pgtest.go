@@ -0,0 +1,159 @@
+// Package pgtest runs a throwaway PostgreSQL cluster for tests, started from the initdb
+// and pg_ctl found in OPM_TEST_PG_BIN, on the PATH or under /usr/lib/postgresql. Tests
+// that need it are skipped when no PostgreSQL installation is found.
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNoPostgres is returned by Start when there is no PostgreSQL installation to run
+var ErrNoPostgres = errors.New("no PostgreSQL installation found")
+
+// SkipMessage explains to a skipped test how to run it
+const SkipMessage = "PostgreSQL is not installed; set OPM_TEST_PG_BIN to its bin directory"
+
+// Cluster is a PostgreSQL server listening only on a unix socket in a temporary directory
+type Cluster struct {
+	bin string
+	dir string
+}
+
+// findBin returns the directory holding initdb and pg_ctl
+func findBin() (string, error) {
+	if dir := os.Getenv("OPM_TEST_PG_BIN"); dir != "" {
+		return dir, nil
+	}
+	if initdb, err := exec.LookPath("initdb"); err == nil {
+		return filepath.Dir(initdb), nil
+	}
+	// Debian and Ubuntu keep the server binaries off the PATH; take the newest version
+	dirs, _ := filepath.Glob("/usr/lib/postgresql/*/bin")
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirVersion(dirs[i]) < dirVersion(dirs[j])
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if _, err := os.Stat(filepath.Join(dirs[i], "initdb")); err == nil {
+			return dirs[i], nil
+		}
+	}
+	return "", ErrNoPostgres
+}
+
+// dirVersion is the version of a /usr/lib/postgresql/<version>/bin directory, which is
+// numeric ("9.6", "16") so it must not sort as text, or -1 when it isn't a version
+func dirVersion(dir string) float64 {
+	version, err := strconv.ParseFloat(filepath.Base(filepath.Dir(dir)), 64)
+	if err != nil {
+		return -1
+	}
+	return version
+}
+
+// Start creates and starts a cluster, which Stop removes again
+func Start() (*Cluster, error) {
+	bin, err := findBin()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "opm-pg-")
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{bin: bin, dir: dir}
+
+	data := filepath.Join(dir, "data")
+	if err := c.exec("initdb", "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync"); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	options := fmt.Sprintf("-k %s -c listen_addresses='' -c fsync=off -c full_page_writes=off", dir)
+	if err := c.exec("pg_ctl", "-D", data, "-l", filepath.Join(dir, "postgres.log"), "-o", options, "-w", "start"); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Cluster) exec(name string, args ...string) error {
+	out, err := exec.Command(filepath.Join(c.bin, name), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v\n%s", name, err, out)
+	}
+	return nil
+}
+
+// Stop shuts the cluster down and deletes its files
+func (c *Cluster) Stop() {
+	c.exec("pg_ctl", "-D", filepath.Join(c.dir, "data"), "-m", "immediate", "stop")
+	os.RemoveAll(c.dir)
+}
+
+// DSN returns the connection string for database name
+func (c *Cluster) DSN(name string) string {
+	return fmt.Sprintf("host=%s user=postgres dbname=%s sslmode=disable", c.dir, name)
+}
+
+// Admin runs statements that can't run inside a database being created or dropped
+func (c *Cluster) Admin(ctx context.Context, statements ...string) error {
+	conn, err := pgx.Connect(ctx, c.DSN("postgres"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	for _, statement := range statements {
+		if _, err := conn.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("%s: %w", statement, err)
+		}
+	}
+	return nil
+}
+
+// CreateDatabase creates a database for the running test, copied from template unless it
+// is empty, and drops it when the test ends. It returns the database's name.
+func (c *Cluster) CreateDatabase(t *testing.T, template string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	name := DatabaseName(t)
+	create := "CREATE DATABASE " + name
+	if template != "" {
+		create += " TEMPLATE " + template
+	}
+	if err := c.Admin(ctx, "DROP DATABASE IF EXISTS "+name, create); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := c.Admin(ctx, "DROP DATABASE "+name+" WITH (FORCE)"); err != nil {
+			t.Error(err)
+		}
+	})
+	return name
+}
+
+// DatabaseName turns a test name into a database name
+func DatabaseName(t *testing.T) string {
+	name := strings.ToLower(t.Name())
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	if len(name) > 50 {
+		name = name[:50]
+	}
+	return "opm_" + name
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"opm/config"
+	"opm/db"
+	"opm/gitremote"
+	"opm/logger"
+	"opm/migrations"
+	"opm/pgtest"
+)
+
+// The integration tests run against a throwaway PostgreSQL cluster from pgtest. Each test
+// gets its own database, copied from a migrated template. Without PostgreSQL the tests
+// are skipped.
+
+const testSecret = "integration-test-secret"
+
+// templateDB holds the migrated schema every test database is copied from
+const templateDB = "opm_template"
+
+// cluster is the running test cluster, nil when the tests are skipped
+var cluster *pgtest.Cluster
+
+func TestMain(m *testing.M) {
+	logger.MainLogger = log.New(io.Discard, "", 0)
+	logger.SecurityLogger = log.New(io.Discard, "", 0)
+	// The auth middleware reads the secret from the environment
+	os.Setenv("JWT_SECRET", testSecret)
+	// Packages of the resolve tests live in local repositories
+	gitremote.AllowedProtocols += ":file"
+
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	c, err := pgtest.Start()
+	if errors.Is(err, pgtest.ErrNoPostgres) {
+		fmt.Fprintf(os.Stderr, "skipping integration tests: %v\n", err)
+		return m.Run()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start PostgreSQL: %v\n", err)
+		return 1
+	}
+	defer c.Stop()
+
+	if err := createTemplate(c); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create template database: %v\n", err)
+		return 1
+	}
+	cluster = c
+	return m.Run()
+}
+
+// createTemplate creates the template database and migrates it
+func createTemplate(c *pgtest.Cluster) error {
+	ctx := context.Background()
+	if err := c.Admin(ctx, "CREATE DATABASE "+templateDB); err != nil {
+		return err
+	}
+
+	pool, err := db.New(ctx, testConfig(c.DSN(templateDB)))
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	_, err = migrations.Up(ctx, pool)
+	return err
+}
+
+// testConfig returns the configuration of a test server using databaseURL
+func testConfig(databaseURL string) *config.Config {
+	return &config.Config{
+		Port:        "0",
+		Host:        "http://localhost",
+		Env:         "test",
+		DatabaseURL: databaseURL,
+		JWTSecret:   testSecret,
+
+		RateLimit:  "100000",
+		RateWindow: "1m",
+
+		SnapshotStorage: "local",
+		SnapshotDir:     os.TempDir(),
+
+		TrendingRefresh: "10m",
+		MigrateOnStart:  "false",
+
+		DBMaxConns:         "8",
+		DBMinConns:         "0",
+		DBMaxConnLifetime:  "1h",
+		DBMaxConnIdleTime:  "10m",
+		DBConnectTimeout:   "5s",
+		DBStatementTimeout: "10s",
+	}
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"opm/config"
+	"opm/db"
+	"opm/handlers/auth"
+	"opm/handlers/health"
+	"opm/handlers/packages"
+	"opm/handlers/tags"
+	"opm/handlers/users"
+	"opm/logger"
+	"opm/middleware"
+	"opm/migrations"
+	"opm/models"
+	"opm/snapshot"
+	"opm/store"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/cors"
+)
+
+// Server is the API handler together with the pool and stores behind it
+type Server struct {
+	Handler http.Handler
+	Pool    *pgxpool.Pool
+	Stores  *store.Store
+
+	cfg     *config.Config
+	proxies middleware.TrustedProxies
+	limiter *middleware.RateLimiter
+}
+
+// New connects to the database, applies pending migrations when DB_MIGRATE_ON_START is set
+// and builds the routes. Snapshot storage and quarantine thresholds are set process-wide
+// from cfg, so Servers sharing a process must agree on them.
+func New(cfg *config.Config) (*Server, error) {
+	ctx := context.Background()
+
+	migrateOnStart, err := strconv.ParseBool(cfg.MigrateOnStart)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_MIGRATE_ON_START %q", cfg.MigrateOnStart)
+	}
+
+	pool, err := db.New(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	logger.MainLogger.Println("✅ Database connection pool initialized")
+
+	if migrateOnStart {
+		if _, err := migrations.Up(ctx, pool); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+	}
+
+	if err := snapshot.InitStorage(cfg.SnapshotStorage, cfg.SnapshotDir); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to initialize snapshot storage: %w", err)
+	}
+
+	if err := packages.InitQuarantineThresholds(cfg.QuarantineThresholds); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to load quarantine thresholds: %w", err)
+	}
+
+	proxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	limiter, err := middleware.NewRateLimiter(cfg)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	s := &Server{
+		Pool:    pool,
+		Stores:  store.NewPostgres(pool),
+		cfg:     cfg,
+		proxies: proxies,
+		limiter: limiter,
+	}
+	s.Handler = s.routes()
+	return s, nil
+}
+
+// StartJobs runs the background jobs until ctx is done
+func (s *Server) StartJobs(ctx context.Context) error {
+	if err := packages.StartTrendingRefresh(ctx, s.Pool, s.cfg.TrendingRefresh); err != nil {
+		return fmt.Errorf("failed to start trending refresh: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database pool
+func (s *Server) Close() {
+	s.Pool.Close()
+	logger.MainLogger.Println("✅ Database connection pool closed")
+}
+
+// routes builds the router wrapped in CORS
+func (s *Server) routes() http.Handler {
+	cfg, stores, pool := s.cfg, s.Stores, s.Pool
+
+	r := mux.NewRouter()
+	authMiddleware := middleware.NewAuth(pool)
+
+	r.Use(s.proxies.ClientIPs)
+	r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
+	s.limiter.SetCost(middleware.CostSearch, "/packages/search", "/packages/suggest", "/resolve", "/readme", "/repository/metadata",
+		"GET /packages/{userSlug}/{pkgSlug}/archive")
+	r.Use(s.limiter.Middleware)
+
+	authApi := r.NewRoute().Subrouter()
+	authApi.Use(authMiddleware.RequireAuthMiddleware)
+
+	// Public routes with optional auth (for bookmark/vote status)
+	optionalAuthApi := r.NewRoute().Subrouter()
+	optionalAuthApi.Use(authMiddleware.OptionalAuthMiddleware)
+
+	r.HandleFunc("/health", health.Check(pool)).Methods("GET")
+
+	// Auth routes (these don't require authentication)
+	r.HandleFunc("/auth/github", auth.GitHubLogin(cfg)).Methods("GET")
+	optionalAuthApi.HandleFunc("/auth/github/callback", auth.GitHubCallback(cfg, pool)).Methods("GET") // session needed when linking
+	r.HandleFunc("/auth/discord", auth.DiscordLogin(cfg)).Methods("GET")
+	optionalAuthApi.HandleFunc("/auth/discord/callback", auth.DiscordCallback(cfg, pool)).Methods("GET")
+	authApi.HandleFunc("/auth/github/link", middleware.RequireSession(auth.GitHubLink(cfg))).Methods("GET")   // param: merge
+	authApi.HandleFunc("/auth/discord/link", middleware.RequireSession(auth.DiscordLink(cfg))).Methods("GET") // param: merge
+	optionalAuthApi.HandleFunc("/auth/logout", auth.Logout(pool)).Methods("POST")
+	authApi.HandleFunc("/auth/me", users.GetCurrentUser(pool)).Methods("GET")
+
+	// Package routes
+	optionalAuthApi.HandleFunc("/readme", packages.GetPackageReadme(pool)).Methods("GET")
+	authApi.HandleFunc("/repository/metadata", packages.GetRepositoryMetadata).Methods("GET")
+	optionalAuthApi.HandleFunc("/packages", packages.List(stores)).Methods("GET")
+	optionalAuthApi.HandleFunc("/packages/search", packages.Search(stores)).Methods("GET")
+	optionalAuthApi.HandleFunc("/packages/suggest", packages.Suggest(stores)).Methods("GET") // param: q
+	authApi.HandleFunc("/packages", middleware.RequireScope(models.ScopePackagesWrite, packages.Create(stores, pool))).Methods("POST")
+	authApi.HandleFunc("/packages/bookmark", middleware.RequireScope(models.ScopeBookmarksWrite, packages.Bookmark(stores, pool))).Methods("POST")     // param: package_id
+	authApi.HandleFunc("/packages/bookmark", middleware.RequireScope(models.ScopeBookmarksWrite, packages.Unbookmark(stores, pool))).Methods("DELETE") // param: package_id
+	optionalAuthApi.HandleFunc("/resolve", packages.Resolve(pool)).Methods("POST")                                                                     // body: requirements or lockfile
+	// MUST BE BELOW OTHER ROUTES DUE TO WILDCARD MUX:
+	optionalAuthApi.HandleFunc("/packages/{userSlug}/{pkgSlug}", packages.Get(stores)).Methods("GET")
+	optionalAuthApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/versions", packages.ListVersions(pool)).Methods("GET")
+	authApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/versions", middleware.RequireScope(models.ScopePackagesWrite, packages.PublishVersion(pool))).Methods("POST")
+	authApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/versions/{version}/yank", middleware.RequireScope(models.ScopePackagesWrite, packages.YankVersion(pool))).Methods("PUT") // body: {"yanked": bool}
+	optionalAuthApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/manifest", packages.GetManifest(pool)).Methods("GET")
+	authApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/manifest", middleware.RequireScope(models.ScopePackagesWrite, packages.RefreshManifest(pool))).Methods("POST")
+	optionalAuthApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/dependencies", packages.GetDependencies(pool)).Methods("GET") // param: depth
+	optionalAuthApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/dependents", packages.GetDependents(pool)).Methods("GET")     // param: depth
+	authApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/dependencies", middleware.RequireScope(models.ScopePackagesWrite, packages.AddDependency(pool))).Methods("POST")
+	authApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/dependencies/{depUserSlug}/{depPkgSlug}", middleware.RequireScope(models.ScopePackagesWrite, packages.RemoveDependency(pool))).Methods("DELETE")
+	optionalAuthApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/archive", packages.GetArchive(pool)).Methods("GET")                                                  // param: ref
+	authApi.HandleFunc("/packages/{userSlug}/{pkgSlug}/archive", middleware.RequireScope(models.ScopePackagesWrite, packages.CreateSnapshot(pool))).Methods("POST") // param: ref
+	authApi.HandleFunc("/packages/{id}", middleware.RequireScope(models.ScopePackagesWrite, packages.Update(stores, pool))).Methods("PUT")
+	authApi.HandleFunc("/packages/{id}", middleware.RequireScope(models.ScopePackagesWrite, packages.Delete(stores))).Methods("DELETE")
+
+	// Tag routes (require auth)
+	authApi.HandleFunc("/tags", middleware.RequireScope(models.ScopeTagsVote, packages.AddTag(pool))).Methods("POST")
+	authApi.HandleFunc("/tags/vote", middleware.RequireScope(models.ScopeTagsVote, packages.VoteTag(pool))).Methods("POST") // param: package_id
+
+	// Flag/moderation routes
+	authApi.HandleFunc("/flags", middleware.RequireScope(models.ScopeFlagsWrite, packages.FlagPackage(stores))).Methods("POST")
+	optionalAuthApi.HandleFunc("/flags", packages.GetPackageFlags(stores)).Methods("GET")                                      // param: package_id
+	optionalAuthApi.HandleFunc("/flags/stats", packages.GetFlagStats(stores)).Methods("GET")                                   // param: package_id
+	authApi.HandleFunc("/flags/all", middleware.RequireSession(packages.GetAllFlags(stores))).Methods("GET")                   // Moderator only
+	authApi.HandleFunc("/flags/quarantine", middleware.RequireSession(packages.GetQuarantinedPackages(stores))).Methods("GET") // Moderator only
+	authApi.HandleFunc("/users/me/flags", packages.GetUserFlags(stores)).Methods("GET")
+	authApi.HandleFunc("/flags/{id}/resolve", middleware.RequireSession(packages.ResolveFlag(stores, pool))).Methods("PUT") // Moderator only
+	authApi.HandleFunc("/flags/{id}", middleware.RequireScope(models.ScopeFlagsWrite, packages.DeleteFlag(stores))).Methods("DELETE")
+
+	// User moderation routes (Moderator only)
+	authApi.HandleFunc("/moderation/users/{userSlug}/bans", middleware.RequireSession(users.ListUserBans(pool))).Methods("GET")
+	authApi.HandleFunc("/moderation/users/{userSlug}/ban", middleware.RequireSession(users.BanUser(pool))).Methods("POST")         // body: reason, hide_packages
+	authApi.HandleFunc("/moderation/users/{userSlug}/suspend", middleware.RequireSession(users.SuspendUser(pool))).Methods("POST") // body: reason, duration_hours, hide_packages
+	authApi.HandleFunc("/moderation/users/{userSlug}/unban", middleware.RequireSession(users.UnbanUser(pool))).Methods("POST")     // body: reason
+
+	// Audit log (Moderator only)
+	authApi.HandleFunc("/admin/audit", middleware.RequireSession(users.ListAuditEvents(pool))).Methods("GET") // params: actor_id, action, target_type, target_id, since, until, limit, offset
+
+	// Tags
+	r.HandleFunc("/tags", tags.List(stores)).Methods("GET")
+	authApi.HandleFunc("/tags/{id}", middleware.RequireSession(tags.UpdateTag(pool))).Methods("PUT")         // Moderator only; body: category
+	authApi.HandleFunc("/tags/{id}/aliases", middleware.RequireSession(tags.AddAlias(pool))).Methods("POST") // Moderator only; body: name
+	authApi.HandleFunc("/tags/{id}/merge", middleware.RequireSession(tags.MergeTag(pool))).Methods("POST")   // Moderator only; body: into
+
+	// User routes
+	authApi.HandleFunc("/users/me/packages", users.ListUserPackages(stores)).Methods("GET")
+	authApi.HandleFunc("/users/me", middleware.RequireSession(users.UpdateProfile(stores))).Methods("PUT")
+	authApi.HandleFunc("/users/check-user-slug", users.CheckSlugAvailability(stores)).Methods("GET")
+	optionalAuthApi.HandleFunc("/users/{userSlug}/reputation", users.GetReputation(pool)).Methods("GET")
+
+	authApi.HandleFunc("/users/me/identities/{provider}", middleware.RequireSession(users.UnlinkIdentity(pool))).Methods("DELETE")
+
+	// Session routes
+	authApi.HandleFunc("/users/me/sessions", middleware.RequireSession(users.ListSessions(pool))).Methods("GET")
+	authApi.HandleFunc("/users/me/sessions", middleware.RequireSession(users.RevokeSessions(pool))).Methods("DELETE") // param: keep_current
+	authApi.HandleFunc("/users/me/sessions/{id}", middleware.RequireSession(users.RevokeSession(pool))).Methods("DELETE")
+
+	// API token routes (browser session only, so a token can't mint or revoke tokens)
+	authApi.HandleFunc("/users/me/tokens", middleware.RequireSession(users.ListTokens(pool))).Methods("GET")
+	authApi.HandleFunc("/users/me/tokens", middleware.RequireSession(users.CreateToken(pool))).Methods("POST")
+	authApi.HandleFunc("/users/me/tokens/{id}", middleware.RequireSession(users.UpdateToken(pool))).Methods("PUT") // body: {"name": string}
+	authApi.HandleFunc("/users/me/tokens/{id}", middleware.RequireSession(users.RevokeToken(pool))).Methods("DELETE")
+	// authApi.HandleFunc("/users/me/bookmarks", users.ListBookmarks).Methods("GET")
+
+	// CORS
+	c := cors.New(cors.Options{
+		AllowedOrigins:   []string{"http://localhost:9000", "https://pkg-odin.org"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Cookie", "Content-Disposition"},
+		ExposedHeaders:   []string{"Set-Cookie", "Content-Disposition", "X-Content-SHA256", "X-Commit-SHA", "Link", "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+		AllowCredentials: true,
+		MaxAge:           300,
+	})
+
+	return c.Handler(r)
+}
@@ -0,0 +1,703 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"opm/helpers"
+	"opm/models"
+	"opm/pgtest"
+)
+
+// testServer is the full API served over HTTP on a database of its own. Snapshot storage
+// and the quarantine thresholds are process-wide, so the tests don't run in parallel.
+type testServer struct {
+	*httptest.Server
+	app *Server
+	t   *testing.T
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	if cluster == nil {
+		t.Skip(pgtest.SkipMessage)
+	}
+
+	name := cluster.CreateDatabase(t, templateDB)
+	cfg := testConfig(cluster.DSN(name))
+	cfg.SnapshotDir = t.TempDir()
+	app, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testServer{Server: httptest.NewServer(app.Handler), app: app, t: t}
+	t.Cleanup(func() {
+		ts.Close()
+		app.Close()
+	})
+	return ts
+}
+
+// testUser is a signed in user
+type testUser struct {
+	ID    int
+	Slug  string
+	token string
+}
+
+// addUser creates a user with a browser session
+func (ts *testServer) addUser(slug string, moderator bool) *testUser {
+	ts.t.Helper()
+	ctx := context.Background()
+
+	u := &testUser{Slug: slug}
+	err := ts.app.Pool.QueryRow(ctx, `
+		INSERT INTO users (username, slug, github_id, is_moderator)
+		VALUES ($1, $1, $1, $2)
+		RETURNING id`,
+		slug, moderator,
+	).Scan(&u.ID)
+	if err != nil {
+		ts.t.Fatalf("create user %s: %v", slug, err)
+	}
+
+	u.token, err = helpers.CreateSession(ctx, ts.app.Pool, httptest.NewRequest("GET", "/", nil), u.ID, testSecret)
+	if err != nil {
+		ts.t.Fatalf("create session for %s: %v", slug, err)
+	}
+	return u
+}
+
+// do sends a request as user, anonymous when nil, with body encoded as JSON, and decodes
+// the response into out, reset first, after checking its status
+func (ts *testServer) do(method, path string, body interface{}, user *testUser, status int, out interface{}) {
+	ts.t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			ts.t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, ts.URL+path, &reader)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if user != nil {
+		req.Header.Set("Authorization", "Bearer "+user.token)
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var raw bytes.Buffer
+	raw.ReadFrom(resp.Body)
+	if resp.StatusCode != status {
+		ts.t.Fatalf("%s %s: status = %d, want %d (body %q)", method, path, resp.StatusCode, status, raw.String())
+	}
+	if out != nil {
+		reflect.ValueOf(out).Elem().SetZero()
+		if err := json.Unmarshal(raw.Bytes(), out); err != nil {
+			ts.t.Fatalf("%s %s: decode %q: %v", method, path, raw.String(), err)
+		}
+	}
+}
+
+// createPackage creates a ready library of author and returns its ID
+func (ts *testServer) createPackage(author *testUser, slug, description string) int {
+	ts.t.Helper()
+	var created struct {
+		ID int `json:"id"`
+	}
+	ts.do("POST", "/packages", models.CreatePackageInput{
+		Slug:        slug,
+		DisplayName: strings.ToUpper(slug[:1]) + slug[1:],
+		Description: description,
+		Type:        models.PackageTypeLibrary,
+		Status:      models.PackageStatusReady,
+		// An unresolvable host makes the manifest and snapshot fetches fail straight away
+		RepositoryURL: "https://example.invalid/" + author.Slug + "/" + slug,
+	}, author, http.StatusCreated, &created)
+	return created.ID
+}
+
+type packagePage struct {
+	Items      []models.Package `json:"items"`
+	NextCursor *string          `json:"next_cursor"`
+	Total      int              `json:"total"`
+}
+
+func slugs(packages []models.Package) string {
+	s := make([]string, len(packages))
+	for i, p := range packages {
+		s[i] = p.Slug
+	}
+	return strings.Join(s, ",")
+}
+
+func TestHealth(t *testing.T) {
+	ts := newTestServer(t)
+
+	var health struct {
+		Status string `json:"status"`
+		Pool   struct {
+			MaxConns int `json:"max_conns"`
+		} `json:"pool"`
+	}
+	ts.do("GET", "/health", nil, nil, http.StatusOK, &health)
+	if health.Status != "ok" || health.Pool.MaxConns != 8 {
+		t.Errorf("health = %+v, want ok with 8 max conns", health)
+	}
+}
+
+func TestSessionsRunInUTC(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Every pooled connection gets the session settings, not just the first
+	for i := 0; i < 3; i++ {
+		conn, err := ts.app.Pool.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Release()
+
+		var timezone string
+		if err := conn.QueryRow(context.Background(), "SHOW timezone").Scan(&timezone); err != nil {
+			t.Fatal(err)
+		}
+		if timezone != "UTC" {
+			t.Errorf("connection %d: timezone = %s, want UTC", i, timezone)
+		}
+	}
+}
+
+func TestCreateListSearchAndGet(t *testing.T) {
+	ts := newTestServer(t)
+	ada := ts.addUser("ada", false)
+
+	ts.createPackage(ada, "raylib", "Bindings for the raylib game library")
+	ts.createPackage(ada, "raygui", "Immediate mode GUI on top of raylib")
+	ts.createPackage(ada, "sokol", "Cross platform graphics headers")
+
+	// Slugs are unique across authors
+	ts.do("POST", "/packages", models.CreatePackageInput{
+		Slug: "sokol", DisplayName: "Sokol", Description: "Another sokol",
+		Type: models.PackageTypeLibrary, Status: models.PackageStatusReady,
+	}, ada, http.StatusConflict, nil)
+
+	// Creating needs a signed in user
+	ts.do("POST", "/packages", models.CreatePackageInput{Slug: "anon"}, nil, http.StatusUnauthorized, nil)
+
+	var page packagePage
+	ts.do("GET", "/packages?limit=2", nil, nil, http.StatusOK, &page)
+	if got := slugs(page.Items); got != "sokol,raygui" || page.Total != 3 || page.NextCursor == nil {
+		t.Fatalf("first page = %s of %d, want sokol,raygui of 3 with a next cursor", got, page.Total)
+	}
+	ts.do("GET", "/packages?limit=2&cursor="+url.QueryEscape(*page.NextCursor), nil, nil, http.StatusOK, &page)
+	if got := slugs(page.Items); got != "raylib" || page.NextCursor != nil {
+		t.Errorf("second page = %s, want raylib only", got)
+	}
+
+	ts.do("GET", "/packages/search?q=ray", nil, nil, http.StatusOK, &page)
+	if got := slugs(page.Items); got != "raylib,raygui" && got != "raygui,raylib" {
+		t.Errorf("search ray = %s, want raylib and raygui", got)
+	}
+
+	var p models.Package
+	ts.do("GET", "/packages/ada/raylib", nil, nil, http.StatusOK, &p)
+	if p.DisplayName != "Raylib" || p.Author == nil || p.Author.Slug != "ada" {
+		t.Errorf("package = %+v, want Raylib by ada", p)
+	}
+	ts.do("GET", "/packages/ada/missing", nil, nil, http.StatusNotFound, nil)
+}
+
+func TestBookmarks(t *testing.T) {
+	ts := newTestServer(t)
+	ada := ts.addUser("ada", false)
+	bob := ts.addUser("bob", false)
+	id := ts.createPackage(ada, "raylib", "Bindings for the raylib game library")
+	path := fmt.Sprintf("/packages/bookmark?package_id=%d", id)
+
+	ts.do("POST", path, nil, nil, http.StatusUnauthorized, nil)
+	ts.do("POST", path, nil, bob, http.StatusOK, nil)
+
+	var p models.Package
+	ts.do("GET", "/packages/ada/raylib", nil, bob, http.StatusOK, &p)
+	if !p.IsBookmarked || p.BookmarkCount != 1 {
+		t.Errorf("after bookmarking: bookmarked = %v with %d bookmarks, want true with 1", p.IsBookmarked, p.BookmarkCount)
+	}
+	ts.do("GET", "/packages/ada/raylib", nil, ada, http.StatusOK, &p)
+	if p.IsBookmarked {
+		t.Error("bookmark of bob shows for ada")
+	}
+
+	ts.do("DELETE", path, nil, bob, http.StatusOK, nil)
+	ts.do("GET", "/packages/ada/raylib", nil, bob, http.StatusOK, &p)
+	if p.IsBookmarked || p.BookmarkCount != 0 {
+		t.Errorf("after removing: bookmarked = %v with %d bookmarks, want false with 0", p.IsBookmarked, p.BookmarkCount)
+	}
+}
+
+func TestTagsAndVotes(t *testing.T) {
+	ts := newTestServer(t)
+	ada := ts.addUser("ada", false)
+	bob := ts.addUser("bob", false)
+	mod := ts.addUser("mod", true)
+	id := ts.createPackage(ada, "raylib", "Bindings for the raylib game library")
+
+	// New users can't introduce tags, moderators can
+	tag := map[string]interface{}{"package_id": id, "tag_name": "graphics"}
+	ts.do("POST", "/tags", tag, bob, http.StatusForbidden, nil)
+
+	var added struct {
+		TagID     int `json:"tag_id"`
+		VoteValue int `json:"vote_value"`
+	}
+	ts.do("POST", "/tags", tag, mod, http.StatusOK, &added)
+
+	var vote struct {
+		NetScore int  `json:"net_score"`
+		Removed  bool `json:"removed"`
+	}
+	ts.do("POST", "/tags/vote", map[string]int{"package_id": id, "tag_id": added.TagID, "vote": 1}, bob, http.StatusOK, &vote)
+	if vote.Removed || vote.NetScore <= added.VoteValue {
+		t.Errorf("after an upvote: %+v, want a score above %d", vote, added.VoteValue)
+	}
+
+	var page packagePage
+	ts.do("GET", "/packages?tag=graphics", nil, nil, http.StatusOK, &page)
+	if got := slugs(page.Items); got != "raylib" {
+		t.Fatalf("packages tagged graphics = %s, want raylib", got)
+	}
+	if tags := page.Items[0].Tags; len(tags) != 1 || tags[0].Name != "graphics" || tags[0].NetScore != vote.NetScore {
+		t.Errorf("tags = %+v, want graphics scoring %d", tags, vote.NetScore)
+	}
+
+	// Turning the upvote into an equal downvote leaves no score, which takes the tag off
+	ts.do("POST", "/tags/vote", map[string]int{"package_id": id, "tag_id": added.TagID, "vote": -1}, bob, http.StatusOK, &vote)
+	if !vote.Removed {
+		t.Errorf("after the downvote: %+v, want the tag removed", vote)
+	}
+	ts.do("GET", "/packages?tag=graphics", nil, nil, http.StatusOK, &page)
+	if page.Total != 0 {
+		t.Errorf("packages tagged graphics = %s, want none", slugs(page.Items))
+	}
+}
+
+func TestSortByRecentlyUpdated(t *testing.T) {
+	ts := newTestServer(t)
+	ada := ts.addUser("ada", false)
+	bob := ts.addUser("bob", false)
+	raylib := ts.createPackage(ada, "raylib", "Bindings for the raylib game library")
+	sokol := ts.createPackage(ada, "sokol", "Bindings for the sokol headers")
+
+	// Setting updated_at alone doesn't fire the trigger
+	_, err := ts.app.Pool.Exec(context.Background(), `
+		UPDATE packages SET updated_at = NOW() - INTERVAL '2 days' WHERE slug = 'raylib';
+		UPDATE packages SET updated_at = NOW() - INTERVAL '1 day' WHERE slug = 'sokol';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := func(want string) {
+		t.Helper()
+		var page packagePage
+		ts.do("GET", "/packages?sort=updated", nil, nil, http.StatusOK, &page)
+		if got := slugs(page.Items); got != want {
+			t.Errorf("recently updated = %s, want %s", got, want)
+		}
+	}
+	order("sokol,raylib")
+
+	// Views, bookmarks and dependents are not updates
+	ts.do("GET", "/packages/ada/raylib", nil, bob, http.StatusOK, nil)
+	ts.do("POST", fmt.Sprintf("/packages/bookmark?package_id=%d", raylib), nil, bob, http.StatusOK, nil)
+	ts.do("POST", "/packages/ada/sokol/dependencies", models.AddDependencyInput{
+		Dependency: "ada/raylib", VersionConstraint: "*",
+	}, ada, http.StatusCreated, nil)
+	order("sokol,raylib")
+
+	// Publishing a version and editing the package are
+	ts.do("POST", "/packages/ada/raylib/versions", models.PublishVersionInput{
+		Version: "1.0.0", CommitSHA: "abcdef1",
+	}, ada, http.StatusCreated, nil)
+	order("raylib,sokol")
+
+	description := "Bindings for the sokol single-file headers"
+	ts.do("PUT", fmt.Sprintf("/packages/%d", sokol), models.UpdatePackageInput{Description: &description}, ada, http.StatusOK, nil)
+	order("sokol,raylib")
+}
+
+func TestTagVoteWeightsLimitsAndBrigades(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	ada := ts.addUser("ada", false)
+	bob := ts.addUser("bob", false)
+	carol := ts.addUser("carol", false)
+	dave := ts.addUser("dave", false)
+	mod := ts.addUser("mod", true)
+	id := ts.createPackage(ada, "raylib", "Bindings for the raylib game library")
+
+	// Everyone so far is an established account; carol is trusted
+	_, err := ts.app.Pool.Exec(ctx, `
+		UPDATE users SET created_at = NOW() - INTERVAL '30 days';
+		UPDATE users SET reputation_rank = 'trusted' WHERE slug = 'carol'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addTag := func(name string) int {
+		t.Helper()
+		var added struct {
+			TagID int `json:"tag_id"`
+		}
+		ts.do("POST", "/tags", map[string]interface{}{"package_id": id, "tag_name": name}, mod, http.StatusOK, &added)
+		return added.TagID
+	}
+	type voteResult struct {
+		VoteValue int  `json:"vote_value"`
+		NetScore  int  `json:"net_score"`
+		Removed   bool `json:"removed"`
+	}
+	vote := func(user *testUser, tagID, value, status int) voteResult {
+		t.Helper()
+		var result voteResult
+		var out interface{}
+		if status == http.StatusOK {
+			out = &result
+		}
+		ts.do("POST", "/tags/vote", map[string]int{"package_id": id, "tag_id": tagID, "vote": value}, user, status, out)
+		return result
+	}
+
+	// Votes weigh by rank, and the author's by at least 4
+	graphics := addTag("graphics")
+	if got := vote(carol, graphics, 1, http.StatusOK); got.VoteValue != 6 {
+		t.Errorf("trusted vote = %+v, want a weight of 6", got)
+	}
+	if got := vote(ada, graphics, 1, http.StatusOK); got.VoteValue != 4 || got.NetScore != 1+6+4 {
+		t.Errorf("author vote = %+v, want a weight of 4 and a score of 11", got)
+	}
+
+	// A newbie has 20 votes a day, and can always take one back
+	_, err = ts.app.Pool.Exec(ctx, `
+		WITH bulk AS (
+			INSERT INTO tags (name) SELECT 'bulk-' || n FROM generate_series(1, 20) n RETURNING id
+		), tagged AS (
+			INSERT INTO package_tags (package_id, tag_id) SELECT $1, id FROM bulk RETURNING tag_id
+		)
+		INSERT INTO tag_votes (package_id, tag_id, user_id, vote_value)
+		SELECT $1, tag_id, $2, 1 FROM tagged`,
+		id, bob.ID,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vote(bob, graphics, 1, http.StatusTooManyRequests)
+	vote(bob, graphics, 0, http.StatusOK)
+
+	// Five new accounts downvoting a tag stop counting on that tag only
+	audio := addTag("audio")
+	var newcomer *testUser
+	for i := 0; i < 5; i++ {
+		newcomer = ts.addUser(fmt.Sprintf("new%d", i), false)
+		vote(newcomer, graphics, -1, http.StatusOK)
+	}
+	if got := vote(carol, graphics, 1, http.StatusOK); got.NetScore != 11 {
+		t.Errorf("graphics after the brigade = %+v, want the brigade ignored at 11", got)
+	}
+	if got := vote(newcomer, audio, -1, http.StatusOK); !got.Removed {
+		t.Errorf("audio = %+v, want the new account's vote to count and remove it", got)
+	}
+
+	// A tag scoring 0 without the brigade stays while the brigade's votes keep it up
+	physics := addTag("physics")
+	for i := 0; i < 5; i++ {
+		vote(ts.addUser(fmt.Sprintf("fan%d", i), false), physics, 1, http.StatusOK)
+	}
+	if got := vote(dave, physics, -1, http.StatusOK); got.Removed || got.NetScore != 0 {
+		t.Errorf("physics = %+v, want a score of 0 without removal", got)
+	}
+}
+
+func TestMergeUsersMovesVotesAndBans(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	ada := ts.addUser("ada", false)
+	alt := ts.addUser("alt", false)
+	mod := ts.addUser("mod", true)
+	id := ts.createPackage(ada, "raylib", "Bindings for the raylib game library")
+
+	// alt signed in with Discord instead
+	_, err := ts.app.Pool.Exec(ctx, "UPDATE users SET github_id = NULL, discord_id = 'alt' WHERE id = $1", alt.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ts.app.Pool.Exec(ctx, "INSERT INTO user_bans (user_id, moderator_id, action, reason) VALUES ($1, $2, 'unban', 'Appeal')", alt.ID, mod.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var added struct {
+		TagID     int `json:"tag_id"`
+		VoteValue int `json:"vote_value"`
+	}
+	ts.do("POST", "/tags", map[string]interface{}{"package_id": id, "tag_name": "graphics"}, mod, http.StatusOK, &added)
+	var vote struct {
+		VoteValue int `json:"vote_value"`
+	}
+	ts.do("POST", "/tags/vote", map[string]int{"package_id": id, "tag_id": added.TagID, "vote": 1}, ada, http.StatusOK, &vote)
+	ts.do("POST", "/tags/vote", map[string]int{"package_id": id, "tag_id": added.TagID, "vote": -1}, alt, http.StatusOK, nil)
+
+	if err := helpers.MergeUsers(ctx, ts.app.Pool, ada.ID, alt.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// ada's vote wins over alt's, and the score no longer counts alt's
+	var page packagePage
+	ts.do("GET", "/packages?tag=graphics", nil, nil, http.StatusOK, &page)
+	if len(page.Items) != 1 || len(page.Items[0].Tags) != 1 || page.Items[0].Tags[0].NetScore != added.VoteValue+vote.VoteValue {
+		t.Errorf("packages tagged graphics = %+v, want raylib scoring %d", page.Items, added.VoteValue+vote.VoteValue)
+	}
+
+	var bans, merges int
+	err = ts.app.Pool.QueryRow(ctx, "SELECT COUNT(*) FROM user_bans WHERE user_id = $1", ada.ID).Scan(&bans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bans != 1 {
+		t.Errorf("ada has %d ban history entries, want alt's one", bans)
+	}
+	err = ts.app.Pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM audit_events WHERE action = 'user.merge' AND target_id = $1 AND (before->>'merged_user_id')::INTEGER = $2",
+		strconv.Itoa(ada.ID), alt.ID,
+	).Scan(&merges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merges != 1 {
+		t.Errorf("%d audit events for the merge, want 1", merges)
+	}
+}
+
+func TestFlagsAndModeration(t *testing.T) {
+	ts := newTestServer(t)
+	ada := ts.addUser("ada", false)
+	bob := ts.addUser("bob", false)
+	mod := ts.addUser("mod", true)
+	id := ts.createPackage(ada, "raylib", "Bindings for the raylib game library")
+
+	flag := map[string]interface{}{"package_id": id, "reason": "Spam", "details": "Links to a shop"}
+	var created struct {
+		ID int `json:"id"`
+	}
+	ts.do("POST", "/flags", flag, bob, http.StatusCreated, &created)
+	ts.do("POST", "/flags", flag, bob, http.StatusConflict, nil)
+
+	// Details are only shown to signed in users
+	var flags []models.Flag
+	ts.do("GET", fmt.Sprintf("/flags?package_id=%d", id), nil, nil, http.StatusOK, &flags)
+	if len(flags) != 1 || flags[0].Details != nil {
+		t.Errorf("anonymous flags = %+v, want one without details", flags)
+	}
+	ts.do("GET", fmt.Sprintf("/flags?package_id=%d", id), nil, ada, http.StatusOK, &flags)
+	if len(flags) != 1 || flags[0].Details == nil || *flags[0].Details != "Links to a shop" {
+		t.Errorf("flags = %+v, want one with details", flags)
+	}
+
+	// Only moderators review flags
+	resolve := fmt.Sprintf("/flags/%d/resolve", created.ID)
+	ts.do("GET", "/flags/all", nil, ada, http.StatusForbidden, nil)
+	ts.do("PUT", resolve, map[string]string{"status": "dismissed"}, ada, http.StatusForbidden, nil)
+
+	var pending struct {
+		Items []models.FlagWithContext `json:"items"`
+	}
+	ts.do("GET", "/flags/all", nil, mod, http.StatusOK, &pending)
+	if len(pending.Items) != 1 || pending.Items[0].PackageSlug != "raylib" || pending.Items[0].ReporterUsername != "bob" {
+		t.Fatalf("pending flags = %+v, want bob's flag on raylib", pending.Items)
+	}
+	ts.do("PUT", resolve, map[string]string{"status": "dismissed"}, mod, http.StatusOK, nil)
+
+	var mine []models.UserFlag
+	ts.do("GET", "/users/me/flags", nil, bob, http.StatusOK, &mine)
+	if len(mine) != 1 || mine[0].Status != "dismissed" {
+		t.Errorf("bob's flags = %+v, want one dismissed", mine)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	ts := newTestServer(t)
+	ada := ts.addUser("ada", false)
+	for _, slug := range []string{"app", "gui", "net", "core"} {
+		ts.createPackage(ada, slug, "Package "+slug)
+	}
+	add := func(from, to string, status int) {
+		t.Helper()
+		ts.do("POST", "/packages/ada/"+from+"/dependencies", models.AddDependencyInput{
+			Dependency: "ada/" + to, VersionConstraint: "^1.0.0",
+		}, ada, status, nil)
+	}
+
+	// A diamond: app needs gui and net, which both need core
+	add("app", "gui", http.StatusCreated)
+	add("app", "net", http.StatusCreated)
+	add("gui", "core", http.StatusCreated)
+	add("net", "core", http.StatusCreated)
+	add("core", "app", http.StatusConflict)
+
+	var edges []models.PackageDependency
+	ts.do("GET", "/packages/ada/app/dependencies?depth=10", nil, nil, http.StatusOK, &edges)
+	if len(edges) != 4 {
+		t.Fatalf("dependencies of app = %+v, want the 4 edges of the diamond", edges)
+	}
+	for _, e := range edges {
+		if e.Cycle || e.Source != "manual" || (e.Slug == "core") != (e.Depth == 2) {
+			t.Errorf("edge %+v, want a manual edge without a cycle, core at depth 2", e)
+		}
+	}
+
+	// Manifest dependencies are part of the graph, and are linked once their package exists
+	_, err := ts.app.Pool.Exec(context.Background(), `
+		INSERT INTO package_dependencies (package_id, name, version_constraint, source)
+		SELECT id, 'ada/log', '*', 'manifest' FROM packages WHERE slug = 'core'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.createPackage(ada, "log", "Package log")
+	var p models.Package
+	ts.do("GET", "/packages/ada/log", nil, nil, http.StatusOK, &p)
+	if p.DependentsCount != 1 {
+		t.Errorf("dependents of log = %d, want 1", p.DependentsCount)
+	}
+	ts.do("GET", "/packages/ada/log/dependents?depth=3", nil, nil, http.StatusOK, &edges)
+	if len(edges) != 5 || edges[0].Slug != "core" || edges[0].Source != "manifest" {
+		t.Errorf("dependents of log = %+v, want core from its manifest, then gui, net and app twice", edges)
+	}
+
+	// Only hand-added dependencies can be removed by hand
+	ts.do("DELETE", "/packages/ada/core/dependencies/ada/log", nil, ada, http.StatusNotFound, nil)
+	ts.do("DELETE", "/packages/ada/app/dependencies/ada/gui", nil, ada, http.StatusNoContent, nil)
+	ts.do("GET", "/packages/ada/gui", nil, nil, http.StatusOK, &p)
+	if p.DependentsCount != 0 {
+		t.Errorf("dependents of gui = %d after removing app's dependency, want 0", p.DependentsCount)
+	}
+}
+
+func TestVersionsSortBySemver(t *testing.T) {
+	ts := newTestServer(t)
+	ada := ts.addUser("ada", false)
+	ts.createPackage(ada, "raylib", "Bindings for the raylib game library")
+
+	publish := func(version string, status int) {
+		t.Helper()
+		ts.do("POST", "/packages/ada/raylib/versions", models.PublishVersionInput{
+			Version: version, CommitSHA: "abcdef1",
+		}, ada, status, nil)
+	}
+	for _, version := range []string{"1.0.0-rc.9", "1.0.0-rc.10", "1.0.0-beta", "0.9.0"} {
+		publish(version, http.StatusCreated)
+	}
+	publish("1.0.0-rc.10+build.5", http.StatusBadRequest)
+
+	var versions []models.PackageVersion
+	ts.do("GET", "/packages/ada/raylib/versions", nil, nil, http.StatusOK, &versions)
+	got := make([]string, len(versions))
+	for i, v := range versions {
+		got[i] = v.Version
+	}
+	if want := "1.0.0-rc.10,1.0.0-rc.9,1.0.0-beta,0.9.0"; strings.Join(got, ",") != want {
+		t.Errorf("versions = %s, want %s", strings.Join(got, ","), want)
+	}
+}
+
+// git runs git in dir as a throwaway identity and returns its output
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=opm", "GIT_AUTHOR_EMAIL=opm@example.invalid",
+		"GIT_COMMITTER_NAME=opm", "GIT_COMMITTER_EMAIL=opm@example.invalid",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit commits a change to dir and returns the new commit
+func commit(t *testing.T, dir, file string) string {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(file+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "add", file)
+	git(t, dir, "commit", "--quiet", "-m", "Add "+file)
+	return git(t, dir, "rev-parse", "HEAD")
+}
+
+func TestResolveAndVerifyLockfile(t *testing.T) {
+	ts := newTestServer(t)
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	ada := ts.addUser("ada", false)
+	ts.createPackage(ada, "parser", "Parses things")
+
+	// The package's repository is a local one with a tag and a branch
+	dir := t.TempDir()
+	git(t, dir, "init", "--quiet", "--initial-branch=main")
+	v1 := commit(t, dir, "parser.odin")
+	git(t, dir, "tag", "v1.0.0")
+	head := commit(t, dir, "lexer.odin")
+	_, err := ts.app.Pool.Exec(ctx, "UPDATE packages SET repository_url = $1 WHERE slug = 'parser'", "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result models.ResolveResult
+	ts.do("POST", "/resolve", models.ResolveInput{
+		Requirements: []string{"ada/parser@v1.0.0", "ada/parser@main", "ada/parser@v2.0.0", "ada/missing"},
+	}, nil, http.StatusOK, &result)
+	if len(result.Lockfile.Packages) != 2 || len(result.Errors) != 2 {
+		t.Fatalf("resolved %+v with errors %+v, want v1.0.0 and main, and two errors", result.Lockfile.Packages, result.Errors)
+	}
+	tag, branch := result.Lockfile.Packages[0], result.Lockfile.Packages[1]
+	if tag.RefType != "tag" || tag.Commit != v1 || branch.RefType != "branch" || branch.Commit != head {
+		t.Errorf("locked %+v and %+v, want v1.0.0 at %s and main at %s", tag, branch, v1, head)
+	}
+
+	lockfile := result.Lockfile
+	ts.do("POST", "/resolve", models.ResolveInput{Lockfile: &lockfile}, nil, http.StatusOK, &result)
+	if result.Verified == nil || !*result.Verified || len(result.Errors) != 0 {
+		t.Errorf("verify = %v with errors %+v, want verified", result.Verified, result.Errors)
+	}
+
+	// Moving the tag and the branch breaks the lockfile
+	git(t, dir, "tag", "--force", "v1.0.0", head)
+	commit(t, dir, "ast.odin")
+	ts.do("POST", "/resolve", models.ResolveInput{Lockfile: &lockfile}, nil, http.StatusOK, &result)
+	if result.Verified == nil || *result.Verified || len(result.Errors) != 2 {
+		t.Errorf("verify after moving v1.0.0 and main = %v with errors %+v, want both to have drifted", result.Verified, result.Errors)
+	}
+}
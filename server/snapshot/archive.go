@@ -0,0 +1,175 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"opm/gitremote"
+)
+
+// MaxArchiveSize caps the uncompressed size of a snapshot, and how much of a repository
+// is downloaded to build one
+var MaxArchiveSize int64 = 200 * 1024 * 1024
+
+// Archive describes a stored snapshot
+type Archive struct {
+	Commit string
+	SHA256 string // Hex encoded digest of the .tar.gz
+	Size   int64  // Compressed size in bytes
+	Key    string
+}
+
+// Build fetches repoURL at ref, normalizes the tree into a tar.gz and stores it under
+// "<keyPrefix>/<commit>.tar.gz". The same commit always produces the same bytes.
+func Build(ctx context.Context, repoURL, ref, keyPrefix string) (*Archive, error) {
+	if Store == nil {
+		return nil, fmt.Errorf("snapshot storage is not configured")
+	}
+
+	workDir, err := os.MkdirTemp("", "opm-snapshot-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	checkout := filepath.Join(workDir, "repo")
+	commit, err := gitremote.Fetch(ctx, repoURL, ref, checkout, MaxArchiveSize)
+	if errors.Is(err, gitremote.ErrTooLarge) {
+		return nil, errTooLarge()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := gitremote.ListFiles(ctx, checkout)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(workDir, "archive-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	if err := writeArchive(io.MultiWriter(tmp, hash, counter), checkout, entries); err != nil {
+		return nil, err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{
+		Commit: commit,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+		Size:   counter.n,
+		Key:    keyPrefix + "/" + commit + ".tar.gz",
+	}
+	if err := Store.Put(ctx, archive.Key, tmp); err != nil {
+		return nil, fmt.Errorf("failed to store snapshot: %w", err)
+	}
+	return archive, nil
+}
+
+// writeArchive writes a deterministic tar.gz: entries sorted by path, fixed timestamps and
+// ownership, permissions reduced to 0644/0755, no .git directory and no submodules.
+func writeArchive(w io.Writer, root string, entries []gitremote.TreeEntry) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gz)
+
+	epoch := time.Unix(0, 0).UTC()
+	var total int64
+
+	for _, entry := range entries {
+		hdr := &tar.Header{
+			Name:    entry.Path,
+			ModTime: epoch,
+			Format:  tar.FormatPAX,
+		}
+		path := filepath.Join(root, filepath.FromSlash(entry.Path))
+
+		switch entry.Mode {
+		case "120000":
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = target
+			hdr.Mode = 0777
+		case "100755", "100644":
+			info, err := os.Lstat(path)
+			if err != nil {
+				return err
+			}
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = info.Size()
+			hdr.Mode = 0644
+			if entry.Mode == "100755" {
+				hdr.Mode = 0755
+			}
+			total += hdr.Size
+			if total > MaxArchiveSize {
+				return errTooLarge()
+			}
+		default:
+			// Submodules are not part of the snapshot
+			continue
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if err := copyFile(tw, path, hdr.Size); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func copyFile(w io.Writer, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(w, f, size)
+	return err
+}
+
+func errTooLarge() error {
+	return fmt.Errorf("repository exceeds the %d MB snapshot limit", MaxArchiveSize/1024/1024)
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,108 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage persists snapshot archives by key
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Store is the storage backend used by the snapshot handlers, set by InitStorage
+var Store Storage
+
+var ErrNotFound = errors.New("snapshot not found")
+
+// InitStorage configures Store from the SNAPSHOT_STORAGE / SNAPSHOT_DIR settings
+func InitStorage(kind, dir string) error {
+	switch kind {
+	case "", "local":
+		local, err := NewLocalStorage(dir)
+		if err != nil {
+			return err
+		}
+		Store = local
+		return nil
+	default:
+		return fmt.Errorf("unsupported snapshot storage %q", kind)
+	}
+}
+
+// LocalStorage stores archives as files below a directory
+type LocalStorage struct {
+	Dir string
+}
+
+// NewLocalStorage creates the storage directory if needed
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	return &LocalStorage{Dir: dir}, nil
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if filepath.IsAbs(clean) || clean == "." || strings.HasPrefix(clean, "..") {
+		return "", fmt.Errorf("invalid snapshot key %q", key)
+	}
+	return filepath.Join(s.Dir, clean), nil
+}
+
+// Put writes the archive atomically, so readers never see a partial file
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
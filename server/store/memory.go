@@ -0,0 +1,261 @@
+package store
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"opm/helpers"
+	"opm/models"
+)
+
+// Memory keeps everything in maps, so handler tests run without Postgres. It follows the
+// Postgres stores closely enough for handlers, with these simplifications:
+//   - search matches every query word as a prefix of a word of the name, slug or description,
+//     ranks all matches the same and never suggests alternatives
+//   - every pending flag weighs 1 towards quarantine
+//   - bans, tag votes and the trending score (always 0) are ignored
+//   - every view counts
+//   - nothing is audited
+//
+// Cursors are only valid with the store that issued them.
+type Memory struct {
+	mu          sync.Mutex
+	lastID      int
+	users       map[int]*models.User
+	packages    map[int]*models.Package
+	versions    map[int][]models.PackageVersion // By package
+	tags        map[int]*models.Tag
+	packageTags map[int]map[int]int // Package to tag to score
+	flags       map[int]*models.Flag
+	bookmarks   map[int]map[int]bool // User to packages
+}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		users:       map[int]*models.User{},
+		packages:    map[int]*models.Package{},
+		versions:    map[int][]models.PackageVersion{},
+		tags:        map[int]*models.Tag{},
+		packageTags: map[int]map[int]int{},
+		flags:       map[int]*models.Flag{},
+		bookmarks:   map[int]map[int]bool{},
+	}
+}
+
+// Store returns the stores backed by m
+func (m *Memory) Store() *Store {
+	return &Store{
+		Packages:  &memPackages{m},
+		Users:     &memUsers{m},
+		Tags:      &memTags{m},
+		Flags:     &memFlags{m},
+		Bookmarks: &memBookmarks{m},
+	}
+}
+
+// nextID returns a new id, unique across all rows. The caller holds m.mu.
+func (m *Memory) nextID() int {
+	m.lastID++
+	return m.lastID
+}
+
+// AddUser adds a user and returns its id. CreatedAt defaults to now.
+func (m *Memory) AddUser(u models.User) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u.ID = m.nextID()
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+		u.UpdatedAt = u.CreatedAt
+	}
+	m.users[u.ID] = &u
+	return u.ID
+}
+
+// AddPackage adds a package of an existing author and returns its id. CreatedAt and
+// UpdatedAt default to now; Quarantine is kept.
+func (m *Memory) AddPackage(p models.Package) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p.ID = m.nextID()
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now()
+	}
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = p.CreatedAt
+	}
+	p.Author = nil
+	p.Tags = nil
+	p.LatestVersion = nil
+	m.packages[p.ID] = &p
+	return p.ID
+}
+
+// AddVersion publishes a version of a package and returns its id
+func (m *Memory) AddVersion(v models.PackageVersion) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v.ID = m.nextID()
+	if v.PublishedAt.IsZero() {
+		v.PublishedAt = time.Now()
+	}
+	m.versions[v.PackageID] = append(m.versions[v.PackageID], v)
+	return v.ID
+}
+
+// AddTag adds a canonical tag and returns its id. Its aliases match in tag filters.
+func (m *Memory) AddTag(t models.Tag) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.ID = m.nextID()
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+	m.tags[t.ID] = &t
+	return t.ID
+}
+
+// TagPackage tags a package with a net vote score
+func (m *Memory) TagPackage(packageID, tagID, score int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.packageTags[packageID] == nil {
+		m.packageTags[packageID] = map[int]int{}
+	}
+	m.packageTags[packageID][tagID] = score
+}
+
+// packageView returns a copy of a package with its author and report count, as the
+// Postgres stores read it. The caller holds m.mu.
+func (m *Memory) packageView(p *models.Package) models.Package {
+	view := *p
+	if author, ok := m.users[p.AuthorID]; ok {
+		a := *author
+		view.Author = &a
+	}
+	view.ActiveReportsCount = 0
+	for _, f := range m.flags {
+		if f.PackageID == p.ID && f.Status == "pending" {
+			view.ActiveReportsCount++
+		}
+	}
+	return view
+}
+
+// usageCount is how many packages carry a tag. The caller holds m.mu.
+func (m *Memory) usageCount(tagID int) int {
+	count := 0
+	for _, tags := range m.packageTags {
+		if _, ok := tags[tagID]; ok {
+			count++
+		}
+	}
+	return count
+}
+
+// sortedIDs returns the keys of a map in ascending order, so iteration is deterministic
+func sortedIDs[V any](rows map[int]V) []int {
+	ids := make([]int, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// paginate sorts rows by the page's sort order and returns the rows after its cursor, at
+// most FetchLimit of them, with their keys. key formats one sort column of a row the way
+// Postgres casts it to text.
+func paginate[T any](page *helpers.Page, rows []T, key func(T, helpers.SortColumn) string) ([]T, [][]string) {
+	columns := page.Columns()
+	keys := make([][]string, len(rows))
+	order := make([]int, len(rows))
+	for i, row := range rows {
+		keys[i] = make([]string, len(columns))
+		for j, c := range columns {
+			keys[i][j] = key(row, c)
+		}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return compareKeys(columns, keys[order[a]], keys[order[b]]) < 0
+	})
+
+	after := page.After()
+	result := []T{}
+	resultKeys := [][]string{}
+	for _, i := range order {
+		if len(result) == page.FetchLimit() {
+			break
+		}
+		if after != nil && compareKeys(columns, keys[i], after) <= 0 {
+			continue
+		}
+		result = append(result, rows[i])
+		resultKeys = append(resultKeys, keys[i])
+	}
+	return result, resultKeys
+}
+
+// compareKeys compares two sort keys in the order of columns
+func compareKeys(columns []helpers.SortColumn, a, b []string) int {
+	for i, c := range columns {
+		cmp := compareValues(c.Type, a[i], b[i])
+		if c.Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// compareValues compares two key values of an SQL type, falling back to comparing them as
+// text when they don't parse
+func compareValues(sqlType, a, b string) int {
+	switch sqlType {
+	case "INTEGER", "BIGINT":
+		x, errA := strconv.ParseInt(a, 10, 64)
+		y, errB := strconv.ParseInt(b, 10, 64)
+		if errA == nil && errB == nil {
+			return compareOrdered(x, y)
+		}
+	case "REAL", "DOUBLE PRECISION":
+		x, errA := strconv.ParseFloat(a, 64)
+		y, errB := strconv.ParseFloat(b, 64)
+		if errA == nil && errB == nil {
+			return compareOrdered(x, y)
+		}
+	case "TIMESTAMPTZ":
+		x, errA := time.Parse(time.RFC3339Nano, a)
+		y, errB := time.Parse(time.RFC3339Nano, b)
+		if errA == nil && errB == nil {
+			return x.Compare(y)
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
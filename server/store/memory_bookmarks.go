@@ -0,0 +1,45 @@
+package store
+
+import "context"
+
+type memBookmarks struct {
+	m *Memory
+}
+
+func (s *memBookmarks) Add(ctx context.Context, userID, packageID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if s.m.bookmarks[userID] == nil {
+		s.m.bookmarks[userID] = map[int]bool{}
+	}
+	if p, ok := s.m.packages[packageID]; ok && !s.m.bookmarks[userID][packageID] {
+		s.m.bookmarks[userID][packageID] = true
+		p.BookmarkCount++
+	}
+	return nil
+}
+
+func (s *memBookmarks) Remove(ctx context.Context, userID, packageID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if s.m.bookmarks[userID][packageID] {
+		delete(s.m.bookmarks[userID], packageID)
+		s.m.packages[packageID].BookmarkCount--
+	}
+	return nil
+}
+
+func (s *memBookmarks) Bookmarked(ctx context.Context, userID int, packageIDs []int) (map[int]bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	bookmarked := make(map[int]bool)
+	for _, id := range packageIDs {
+		if s.m.bookmarks[userID][id] {
+			bookmarked[id] = true
+		}
+	}
+	return bookmarked, nil
+}
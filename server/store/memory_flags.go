@@ -0,0 +1,284 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"opm/helpers"
+	"opm/models"
+)
+
+type memFlags struct {
+	m *Memory
+}
+
+// newestFlags returns the flags passing keep, newest first. The caller holds m.mu.
+func (m *Memory) newestFlags(keep func(*models.Flag) bool) []models.Flag {
+	flags := []models.Flag{}
+	for _, id := range sortedIDs(m.flags) {
+		if f := m.flags[id]; keep(f) {
+			flags = append(flags, *f)
+		}
+	}
+	sort.SliceStable(flags, func(i, j int) bool {
+		if !flags[i].CreatedAt.Equal(flags[j].CreatedAt) {
+			return flags[i].CreatedAt.After(flags[j].CreatedAt)
+		}
+		return flags[i].ID > flags[j].ID
+	})
+	return flags
+}
+
+func (s *memFlags) Create(ctx context.Context, packageID, userID int, reason string, details *string) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	now := time.Now()
+	f := &models.Flag{
+		ID:        s.m.nextID(),
+		PackageID: packageID,
+		UserID:    userID,
+		Reason:    reason,
+		Details:   details,
+		Status:    "pending",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.m.flags[f.ID] = f
+	return f.ID, nil
+}
+
+func (s *memFlags) Get(ctx context.Context, flagID int) (*models.Flag, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	f, ok := s.m.flags[flagID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	flag := *f
+	return &flag, nil
+}
+
+func (s *memFlags) Delete(ctx context.Context, flagID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	delete(s.m.flags, flagID)
+	return nil
+}
+
+func (s *memFlags) HasPending(ctx context.Context, packageID, userID int) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, f := range s.m.flags {
+		if f.PackageID == packageID && f.UserID == userID && f.Status == "pending" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memFlags) ListPending(ctx context.Context, packageID int) ([]models.Flag, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	return s.m.newestFlags(func(f *models.Flag) bool {
+		return f.PackageID == packageID && f.Status == "pending"
+	}), nil
+}
+
+func flagKey(f models.FlagWithContext, c helpers.SortColumn) string {
+	switch c.Expr {
+	case "f.created_at":
+		return formatTime(f.CreatedAt)
+	case "f.id":
+		return strconv.Itoa(f.ID)
+	}
+	panic("store: no in-memory sort key for " + c.Expr)
+}
+
+func (s *memFlags) List(ctx context.Context, q FlagQuery) (*FlagPage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	matched := []models.FlagWithContext{}
+	for _, f := range s.m.newestFlags(func(f *models.Flag) bool {
+		return q.Status == "all" || f.Status == q.Status
+	}) {
+		withContext := models.FlagWithContext{Flag: f}
+		if p, ok := s.m.packages[f.PackageID]; ok {
+			withContext.PackageSlug = p.Slug
+			withContext.PackageDisplayName = p.DisplayName
+		}
+		if u, ok := s.m.users[f.UserID]; ok {
+			withContext.ReporterUsername = u.Username
+		}
+		if f.ResolvedBy != nil {
+			if u, ok := s.m.users[*f.ResolvedBy]; ok {
+				withContext.ResolverUsername = &u.Username
+			}
+		}
+		matched = append(matched, withContext)
+	}
+
+	page := &FlagPage{Total: len(matched)}
+	page.Flags, page.Keys = paginate(q.Page, matched, flagKey)
+	return page, nil
+}
+
+func (s *memFlags) ListByReporter(ctx context.Context, userID int) ([]models.UserFlag, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	flags := []models.UserFlag{}
+	for _, f := range s.m.newestFlags(func(f *models.Flag) bool { return f.UserID == userID }) {
+		userFlag := models.UserFlag{
+			ID:         f.ID,
+			PackageID:  f.PackageID,
+			Reason:     f.Reason,
+			Details:    f.Details,
+			Status:     f.Status,
+			ResolvedAt: f.ResolvedAt,
+			CreatedAt:  f.CreatedAt,
+		}
+		if p, ok := s.m.packages[f.PackageID]; ok {
+			userFlag.PackageSlug = p.Slug
+			userFlag.PackageDisplayName = p.DisplayName
+			if author, ok := s.m.users[p.AuthorID]; ok {
+				userFlag.AuthorSlug = author.Slug
+			}
+		}
+		flags = append(flags, userFlag)
+	}
+	return flags, nil
+}
+
+func (s *memFlags) Stats(ctx context.Context, packageID int) (*models.FlagStats, []models.FlagReasonCount, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	stats := &models.FlagStats{}
+	counts := map[string]int{}
+	for _, f := range s.m.flags {
+		if f.PackageID != packageID {
+			continue
+		}
+		switch f.Status {
+		case "pending":
+			stats.PendingCount++
+		case "reviewed":
+			stats.ReviewedCount++
+		case "resolved":
+			stats.ResolvedCount++
+		case "dismissed":
+			stats.DismissedCount++
+		}
+		stats.TotalCount++
+		counts[f.Reason]++
+		if stats.LastFlagDate == nil || f.CreatedAt.After(*stats.LastFlagDate) {
+			createdAt := f.CreatedAt
+			stats.LastFlagDate = &createdAt
+		}
+	}
+	stats.UniqueReasons = len(counts)
+
+	reasons := []models.FlagReasonCount{}
+	for reason, count := range counts {
+		reasons = append(reasons, models.FlagReasonCount{Reason: reason, Count: count})
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if reasons[i].Count != reasons[j].Count {
+			return reasons[i].Count > reasons[j].Count
+		}
+		return reasons[i].Reason < reasons[j].Reason
+	})
+	return stats, reasons, nil
+}
+
+func (s *memFlags) Resolve(ctx context.Context, flagID int, status string, resolverID int, thresholds map[string]float64) (*models.Flag, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	f, ok := s.m.flags[flagID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	now := time.Now()
+	f.Status = status
+	f.ResolvedBy = &resolverID
+	f.ResolvedAt = &now
+
+	if p, ok := s.m.packages[f.PackageID]; ok && status != "resolved" && p.Quarantine != nil && p.Quarantine.Reason == f.Reason {
+		threshold := thresholds[f.Reason]
+		if threshold <= 0 || float64(s.m.quarantineScore(p.ID, f.Reason)) < threshold {
+			p.Quarantine = nil
+		}
+	}
+	flag := *f
+	return &flag, nil
+}
+
+// quarantineScore counts the pending flags for reason. The caller holds m.mu.
+func (m *Memory) quarantineScore(packageID int, reason string) int {
+	score := 0
+	for _, f := range m.flags {
+		if f.PackageID == packageID && f.Reason == reason && f.Status == "pending" {
+			score++
+		}
+	}
+	return score
+}
+
+func (s *memFlags) Quarantine(ctx context.Context, packageID int, reason string, threshold float64) (*QuarantineCheck, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	p, ok := s.m.packages[packageID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	check := &QuarantineCheck{
+		Quarantined: p.Quarantine != nil,
+		Score:       float64(s.m.quarantineScore(packageID, reason)),
+	}
+	if !check.Quarantined && check.Score >= threshold {
+		p.Quarantine = models.NewQuarantineWarning(reason, time.Now())
+		check.Quarantined = true
+		check.Newly = true
+	}
+	return check, nil
+}
+
+func (s *memFlags) ListQuarantined(ctx context.Context) ([]models.QuarantinedPackage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	packages := []models.QuarantinedPackage{}
+	for _, id := range sortedIDs(s.m.packages) {
+		p := s.m.packages[id]
+		if p.Quarantine == nil {
+			continue
+		}
+		q := models.QuarantinedPackage{
+			PackageID:          p.ID,
+			PackageSlug:        p.Slug,
+			PackageDisplayName: p.DisplayName,
+			Reason:             p.Quarantine.Reason,
+			QuarantinedAt:      p.Quarantine.Since,
+		}
+		if author, ok := s.m.users[p.AuthorID]; ok {
+			q.AuthorSlug = author.Slug
+		}
+		q.PendingFlags = s.m.quarantineScore(p.ID, q.Reason)
+		q.Score = float64(q.PendingFlags)
+		packages = append(packages, q)
+	}
+	sort.SliceStable(packages, func(i, j int) bool {
+		return packages[i].QuarantinedAt.Before(packages[j].QuarantinedAt)
+	})
+	return packages, nil
+}
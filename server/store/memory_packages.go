@@ -0,0 +1,414 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"opm/helpers"
+	"opm/models"
+)
+
+type memPackages struct {
+	m *Memory
+}
+
+// packageKey formats a sort column of a package. Every match ranks 0 in a search.
+func packageKey(p models.Package, c helpers.SortColumn) string {
+	switch c.Expr {
+	case "p.id":
+		return strconv.Itoa(p.ID)
+	case "p.created_at":
+		return formatTime(p.CreatedAt)
+	case "p.updated_at":
+		return formatTime(p.UpdatedAt)
+	case "p.view_count":
+		return strconv.Itoa(p.ViewCount)
+	case "p.bookmark_count":
+		return strconv.Itoa(p.BookmarkCount)
+	case "p.dependents_count":
+		return strconv.Itoa(p.DependentsCount)
+	case trendingScore, searchRank.Expr:
+		return "0"
+	}
+	panic("store: no in-memory sort key for " + c.Expr)
+}
+
+// visiblePackages returns the packages listings show, in id order. The caller holds m.mu.
+func (m *Memory) visiblePackages() []models.Package {
+	packages := []models.Package{}
+	for _, id := range sortedIDs(m.packages) {
+		if p := m.packages[id]; p.Quarantine == nil {
+			packages = append(packages, m.packageView(p))
+		}
+	}
+	return packages
+}
+
+// hasTags reports whether a package carries a tag matching each of keys. The caller holds
+// m.mu.
+func (m *Memory) hasTags(packageID int, keys []string) bool {
+	for _, key := range keys {
+		found := false
+		for tagID := range m.packageTags[packageID] {
+			if tagMatches(m.tags[tagID], key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// tagMatches reports whether a tag or one of its aliases has a match key
+func tagMatches(t *models.Tag, key string) bool {
+	if helpers.TagMatchKey(t.Name) == key {
+		return true
+	}
+	for _, alias := range t.Aliases {
+		if helpers.TagMatchKey(alias) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memPackages) List(ctx context.Context, q PackageQuery) (*PackagePage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	keys := tagMatchKeys(q.Filter.Tags)
+	matched := []models.Package{}
+	for _, p := range s.m.visiblePackages() {
+		if q.Filter.Type != nil && p.Type != *q.Filter.Type ||
+			q.Filter.Status != nil && p.Status != *q.Filter.Status ||
+			q.Filter.AuthorID != nil && p.AuthorID != *q.Filter.AuthorID ||
+			!s.m.hasTags(p.ID, keys) {
+			continue
+		}
+		matched = append(matched, p)
+	}
+
+	page := &PackagePage{Total: len(matched)}
+	page.Packages, page.Keys = paginate(q.Page, matched, packageKey)
+	if len(q.Facets) > 0 {
+		page.Facets = s.m.packageFacets(matched, q.Facets, keys)
+	}
+	return page, nil
+}
+
+// packageFacets counts the requested facets over the matched packages, leaving the tags
+// filtered on out of the tag facet. The caller holds m.mu.
+func (m *Memory) packageFacets(matched []models.Package, facets []string, filteredKeys []string) *models.PackageFacets {
+	result := &models.PackageFacets{}
+	for _, facet := range facets {
+		switch facet {
+		case "type":
+			result.Type = map[string]int{}
+			for _, p := range matched {
+				result.Type[string(p.Type)]++
+			}
+		case "status":
+			result.Status = map[string]int{}
+			for _, p := range matched {
+				result.Status[string(p.Status)]++
+			}
+		case "license":
+			result.License = map[string]int{}
+			for _, p := range matched {
+				license := "none"
+				if p.License != nil {
+					license = *p.License
+				}
+				result.License[license]++
+			}
+		case "tag":
+			counts := map[int]int{}
+			for _, p := range matched {
+				for tagID := range m.packageTags[p.ID] {
+					counts[tagID]++
+				}
+			}
+			result.Tag = []models.TagFacet{}
+		tags:
+			for tagID, count := range counts {
+				for _, key := range filteredKeys {
+					if tagMatches(m.tags[tagID], key) {
+						continue tags
+					}
+				}
+				result.Tag = append(result.Tag, models.TagFacet{Name: m.tags[tagID].Name, Count: count})
+			}
+			sort.Slice(result.Tag, func(i, j int) bool {
+				if result.Tag[i].Count != result.Tag[j].Count {
+					return result.Tag[i].Count > result.Tag[j].Count
+				}
+				return result.Tag[i].Name < result.Tag[j].Name
+			})
+			if len(result.Tag) > maxTagFacets {
+				result.Tag = result.Tag[:maxTagFacets]
+			}
+		}
+	}
+	return result
+}
+
+// matchesWords reports whether every word is a prefix of a word of the texts
+func matchesWords(words []string, texts ...string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	candidates := searchWords(strings.ToLower(strings.Join(texts, " ")))
+	for _, word := range words {
+		found := false
+		for _, candidate := range candidates {
+			if strings.HasPrefix(candidate, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *memPackages) Search(ctx context.Context, q SearchQuery) (*PackagePage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	words := searchWords(strings.ToLower(q.Text))
+	matched := []models.Package{}
+	for _, p := range s.m.visiblePackages() {
+		if matchesWords(words, p.DisplayName, p.Slug, p.Description) {
+			matched = append(matched, p)
+		}
+	}
+
+	page := &PackagePage{Total: len(matched)}
+	page.Packages, page.Keys = paginate(q.Page, matched, packageKey)
+	return page, nil
+}
+
+func (s *memPackages) ListByAuthor(ctx context.Context, authorID int, page *helpers.Page) (*PackagePage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	matched := []models.Package{}
+	for _, id := range sortedIDs(s.m.packages) {
+		if p := s.m.packages[id]; p.AuthorID == authorID {
+			matched = append(matched, s.m.packageView(p))
+		}
+	}
+
+	result := &PackagePage{Total: len(matched)}
+	result.Packages, result.Keys = paginate(page, matched, packageKey)
+	return result, nil
+}
+
+func (s *memPackages) Suggest(ctx context.Context, query string, limit int) ([]models.PackageSuggestion, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	prefix := strings.ToLower(query)
+	matched := []models.Package{}
+	for _, p := range s.m.visiblePackages() {
+		if strings.HasPrefix(strings.ToLower(p.DisplayName), prefix) || strings.HasPrefix(strings.ToLower(p.Slug), prefix) {
+			matched = append(matched, p)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].ViewCount > matched[j].ViewCount
+	})
+
+	suggestions := []models.PackageSuggestion{}
+	for _, p := range matched {
+		if len(suggestions) == limit {
+			break
+		}
+		suggestions = append(suggestions, models.PackageSuggestion{
+			Slug:        p.Slug,
+			DisplayName: p.DisplayName,
+			Type:        p.Type,
+			AuthorSlug:  p.Author.Slug,
+		})
+	}
+	return suggestions, nil
+}
+
+func (s *memPackages) DidYouMean(ctx context.Context, query string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (s *memPackages) GetBySlugs(ctx context.Context, userSlug, pkgSlug string) (*models.Package, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, p := range s.m.packages {
+		if author := s.m.users[p.AuthorID]; p.Slug == pkgSlug && author != nil && author.Slug == userSlug {
+			view := s.m.packageView(p)
+			return &view, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memPackages) Exists(ctx context.Context, packageID int) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	_, ok := s.m.packages[packageID]
+	return ok, nil
+}
+
+func (s *memPackages) LatestVersion(ctx context.Context, packageID int) (*models.PackageVersion, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var latest *models.PackageVersion
+	var latestSemver *helpers.Semver
+	for i, v := range s.m.versions[packageID] {
+		semver, err := helpers.ParseSemver(v.Version)
+		if v.Yanked || err != nil {
+			continue
+		}
+		if latest == nil || newerVersion(*semver, *latestSemver) {
+			latest, latestSemver = &s.m.versions[packageID][i], semver
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	v := *latest
+	return &v, nil
+}
+
+// newerVersion reports whether a is preferred over b as the latest version: stable releases
+// win over prereleases, then the higher version wins
+func newerVersion(a, b helpers.Semver) bool {
+	if (a.Prerelease == "") != (b.Prerelease == "") {
+		return a.Prerelease == ""
+	}
+	return a.Compare(b) > 0
+}
+
+func (s *memPackages) TrackView(ctx context.Context, packageID int, userID *int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if p, ok := s.m.packages[packageID]; ok {
+		p.ViewCount++
+	}
+	return nil
+}
+
+func (s *memPackages) AuthorID(ctx context.Context, packageID int) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	p, ok := s.m.packages[packageID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return p.AuthorID, nil
+}
+
+func (s *memPackages) Create(ctx context.Context, authorID int, input models.CreatePackageInput) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, p := range s.m.packages {
+		if p.Slug == input.Slug {
+			return 0, ErrSlugTaken
+		}
+	}
+
+	now := time.Now()
+	p := &models.Package{
+		ID:            s.m.nextID(),
+		Slug:          input.Slug,
+		DisplayName:   input.DisplayName,
+		Description:   input.Description,
+		Type:          input.Type,
+		Status:        input.Status,
+		RepositoryURL: input.RepositoryURL,
+		License:       input.License,
+		AuthorID:      authorID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	s.m.packages[p.ID] = p
+
+	// Memory tags are all canonical, so unknown ids are the only ones to skip
+	for _, tagID := range input.TagIDs {
+		if _, ok := s.m.tags[tagID]; !ok {
+			continue
+		}
+		if s.m.packageTags[p.ID] == nil {
+			s.m.packageTags[p.ID] = map[int]int{}
+		}
+		s.m.packageTags[p.ID][tagID] = 0
+	}
+	return p.ID, nil
+}
+
+func (s *memPackages) Update(ctx context.Context, packageID int, input models.UpdatePackageInput) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	p, ok := s.m.packages[packageID]
+	if !ok {
+		return ErrNotFound
+	}
+	if input.DisplayName != nil {
+		p.DisplayName = *input.DisplayName
+	}
+	if input.Description != nil {
+		p.Description = *input.Description
+	}
+	if input.Type != nil {
+		p.Type = *input.Type
+	}
+	if input.Status != nil {
+		p.Status = *input.Status
+	}
+	if input.RepositoryURL != nil {
+		p.RepositoryURL = *input.RepositoryURL
+	}
+	if input.License != nil {
+		p.License = nil
+		if *input.License != "" {
+			license := *input.License
+			p.License = &license
+		}
+	}
+	p.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *memPackages) Delete(ctx context.Context, packageID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if _, ok := s.m.packages[packageID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.m.packages, packageID)
+	delete(s.m.versions, packageID)
+	delete(s.m.packageTags, packageID)
+	for id, f := range s.m.flags {
+		if f.PackageID == packageID {
+			delete(s.m.flags, id)
+		}
+	}
+	for _, bookmarks := range s.m.bookmarks {
+		delete(bookmarks, packageID)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"opm/helpers"
+	"opm/models"
+)
+
+type memTags struct {
+	m *Memory
+}
+
+func tagKey(t models.Tag, c helpers.SortColumn) string {
+	switch c.Expr {
+	case "COALESCE(t.usage_count, 0)":
+		return strconv.Itoa(t.UsageCount)
+	case "t.name":
+		return t.Name
+	case "t.id":
+		return strconv.Itoa(t.ID)
+	}
+	panic("store: no in-memory sort key for " + c.Expr)
+}
+
+// List matches the search as a substring of tag names and aliases
+func (s *memTags) List(ctx context.Context, q TagQuery) (*TagPage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	search := strings.ToLower(q.Search)
+	matched := []models.Tag{}
+	for _, id := range sortedIDs(s.m.tags) {
+		t := *s.m.tags[id]
+		names := strings.ToLower(strings.Join(append([]string{t.Name}, t.Aliases...), " "))
+		if search != "" && !strings.Contains(names, search) ||
+			q.Category != nil && (t.Category == nil || *t.Category != *q.Category) {
+			continue
+		}
+		t.UsageCount = s.m.usageCount(t.ID)
+		t.Aliases = append([]string{}, t.Aliases...)
+		sort.Strings(t.Aliases)
+		matched = append(matched, t)
+	}
+
+	page := &TagPage{Total: len(matched)}
+	page.Tags, page.Keys = paginate(q.Page, matched, tagKey)
+	return page, nil
+}
+
+func (s *memTags) ForPackage(ctx context.Context, packageID, userID int) ([]models.Tag, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	tags := []models.Tag{}
+	for tagID, score := range s.m.packageTags[packageID] {
+		t := *s.m.tags[tagID]
+		t.UsageCount = s.m.usageCount(tagID)
+		t.Aliases = nil
+		t.NetScore = score
+		tags = append(tags, t)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].NetScore != tags[j].NetScore {
+			return tags[i].NetScore > tags[j].NetScore
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
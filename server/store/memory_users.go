@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"opm/models"
+)
+
+type memUsers struct {
+	m *Memory
+}
+
+func (s *memUsers) IsModerator(ctx context.Context, userID int) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	u, ok := s.m.users[userID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	return u.IsModerator, nil
+}
+
+func (s *memUsers) SlugTaken(ctx context.Context, slug string, exceptUserID int) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, u := range s.m.users {
+		if u.Slug == slug && u.ID != exceptUserID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memUsers) UpdateProfile(ctx context.Context, userID int, input models.UpdateUserInput) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	u, ok := s.m.users[userID]
+	if !ok {
+		return nil
+	}
+	if input.Slug != nil {
+		u.Slug = *input.Slug
+	}
+	if input.DisplayName != nil {
+		displayName := *input.DisplayName
+		u.DisplayName = &displayName
+	}
+	if input.AvatarURL != nil {
+		avatarURL := *input.AvatarURL
+		u.AvatarURL = &avatarURL
+	}
+	u.UpdatedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"time"
+
+	"opm/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// NewPostgres returns the stores backed by a Postgres pool
+func NewPostgres(pool *pgxpool.Pool) *Store {
+	return &Store{
+		Packages:  &pgPackages{pool},
+		Users:     &pgUsers{pool},
+		Tags:      &pgTags{pool},
+		Flags:     &pgFlags{pool},
+		Bookmarks: &pgBookmarks{pool},
+	}
+}
+
+// packageColumns are the columns scanPackage reads, from packageFrom
+const packageColumns = `p.id, p.slug, p.display_name, p.description, p.type, p.status,
+		       p.repository_url, p.license, p.author_id, p.created_at, p.updated_at,
+		       p.view_count, p.bookmark_count, p.dependents_count,
+		       u.id, u.username, u.slug, u.display_name, u.avatar_url,
+		       u.discord_verified, u.github_verified,
+		       (SELECT COUNT(*) FROM flags WHERE package_id = p.id AND status = 'pending'),
+		       ` + trendingScore + `, p.quarantined_at, p.quarantine_reason`
+
+// packageFrom joins the author and trending score of packages p
+const packageFrom = `
+		FROM packages p
+		JOIN users u ON p.author_id = u.id` + trendingJoin
+
+// trendingJoin attaches the trending score of each package; packages without recent views
+// score 0
+const trendingJoin = `
+		LEFT JOIN package_trending tr ON tr.package_id = p.id`
+
+// trendingScore is the trending sort key of a package
+const trendingScore = `COALESCE(tr.score, 0)`
+
+// visibleAuthorCondition hides packages of authors banned with hide_packages from listings.
+// The packages come back on their own once a suspension ends.
+const visibleAuthorCondition = `NOT (u.packages_hidden AND u.is_banned AND (u.banned_until IS NULL OR u.banned_until > NOW()))`
+
+// notQuarantinedCondition hides quarantined packages from listings
+const notQuarantinedCondition = `p.quarantined_at IS NULL`
+
+// scanPackage scans a row selecting packageColumns followed by the extra destinations
+func scanPackage(row pgx.Row, extra ...interface{}) (models.Package, error) {
+	var p models.Package
+	var author models.User
+	var quarantinedAt *time.Time
+	var quarantineReason *string
+
+	dest := []interface{}{
+		&p.ID, &p.Slug, &p.DisplayName, &p.Description, &p.Type, &p.Status,
+		&p.RepositoryURL, &p.License, &p.AuthorID, &p.CreatedAt, &p.UpdatedAt,
+		&p.ViewCount, &p.BookmarkCount, &p.DependentsCount,
+		&author.ID, &author.Username, &author.Slug, &author.DisplayName, &author.AvatarURL,
+		&author.DiscordVerified, &author.GitHubVerified,
+		&p.ActiveReportsCount, &p.TrendingScore, &quarantinedAt, &quarantineReason,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return p, err
+	}
+
+	p.Author = &author
+	if quarantinedAt != nil && quarantineReason != nil {
+		p.Quarantine = models.NewQuarantineWarning(*quarantineReason, *quarantinedAt)
+	}
+	return p, nil
+}
+
+// scanPackages scans the rows of a paginated package query, each ending with its sort key
+func scanPackages(rows pgx.Rows, page *PackagePage) error {
+	defer rows.Close()
+	for rows.Next() {
+		var key []string
+		p, err := scanPackage(rows, &key)
+		if err != nil {
+			return err
+		}
+		page.Packages = append(page.Packages, p)
+		page.Keys = append(page.Keys, key)
+	}
+	return rows.Err()
+}
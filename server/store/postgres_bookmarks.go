@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type pgBookmarks struct {
+	pool *pgxpool.Pool
+}
+
+func (s *pgBookmarks) Add(ctx context.Context, userID, packageID int) error {
+	_, err := s.pool.Exec(ctx,
+		"INSERT INTO bookmarks (user_id, package_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		userID, packageID,
+	)
+	return err
+}
+
+func (s *pgBookmarks) Remove(ctx context.Context, userID, packageID int) error {
+	_, err := s.pool.Exec(ctx,
+		"DELETE FROM bookmarks WHERE user_id = $1 AND package_id = $2",
+		userID, packageID,
+	)
+	return err
+}
+
+func (s *pgBookmarks) Bookmarked(ctx context.Context, userID int, packageIDs []int) (map[int]bool, error) {
+	bookmarked := make(map[int]bool)
+	if len(packageIDs) == 0 {
+		return bookmarked, nil
+	}
+
+	rows, err := s.pool.Query(ctx,
+		"SELECT package_id FROM bookmarks WHERE user_id = $1 AND package_id = ANY($2)",
+		userID, packageIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var packageID int
+		if err := rows.Scan(&packageID); err != nil {
+			return nil, err
+		}
+		bookmarked[packageID] = true
+	}
+	return bookmarked, rows.Err()
+}
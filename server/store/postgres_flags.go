@@ -0,0 +1,443 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"opm/audit"
+	"opm/logger"
+	"opm/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type pgFlags struct {
+	pool *pgxpool.Pool
+}
+
+const flagColumns = `f.id, f.package_id, f.user_id, f.reason, f.details, f.status,
+		       f.resolved_by, f.resolved_at, f.created_at, f.updated_at`
+
+func scanFlag(row pgx.Row, extra ...interface{}) (models.Flag, error) {
+	var f models.Flag
+	dest := []interface{}{&f.ID, &f.PackageID, &f.UserID, &f.Reason, &f.Details, &f.Status,
+		&f.ResolvedBy, &f.ResolvedAt, &f.CreatedAt, &f.UpdatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	return f, err
+}
+
+func (s *pgFlags) Create(ctx context.Context, packageID, userID int, reason string, details *string) (int, error) {
+	var flagID int
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO flags (package_id, user_id, reason, details, status)
+		VALUES ($1, $2, $3, $4, 'pending')
+		RETURNING id`,
+		packageID, userID, reason, details,
+	).Scan(&flagID)
+	return flagID, err
+}
+
+func (s *pgFlags) Get(ctx context.Context, flagID int) (*models.Flag, error) {
+	f, err := scanFlag(s.pool.QueryRow(ctx, "SELECT "+flagColumns+" FROM flags f WHERE f.id = $1", flagID))
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (s *pgFlags) Delete(ctx context.Context, flagID int) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM flags WHERE id = $1", flagID)
+	return err
+}
+
+func (s *pgFlags) HasPending(ctx context.Context, packageID, userID int) (bool, error) {
+	var exists bool
+	err := s.pool.QueryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM flags WHERE package_id = $1 AND user_id = $2 AND status = 'pending')",
+		packageID, userID,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (s *pgFlags) ListPending(ctx context.Context, packageID int) ([]models.Flag, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+flagColumns+`
+		FROM flags f
+		WHERE f.package_id = $1 AND f.status = 'pending'
+		ORDER BY f.created_at DESC`,
+		packageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := []models.Flag{}
+	for rows.Next() {
+		f, err := scanFlag(rows)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, f)
+	}
+	return flags, rows.Err()
+}
+
+func (s *pgFlags) List(ctx context.Context, q FlagQuery) (*FlagPage, error) {
+	fromWhere := `
+		FROM flags f
+		JOIN packages p ON f.package_id = p.id
+		JOIN users u ON f.user_id = u.id
+		LEFT JOIN users ru ON f.resolved_by = ru.id
+		WHERE 1=1`
+
+	args := []interface{}{}
+	argIndex := 1
+
+	if q.Status != "all" {
+		fromWhere += fmt.Sprintf(" AND f.status = $%d", argIndex)
+		args = append(args, q.Status)
+		argIndex++
+	}
+	filterArgs := args
+
+	after, afterArgs := q.Page.KeysetCondition(argIndex)
+	args = append(args, afterArgs...)
+	argIndex += len(afterArgs)
+	query := `
+		SELECT ` + flagColumns + `,
+		       p.slug, p.display_name,
+		       u.username as reporter_username,
+		       ru.username as resolver_username,
+		       ` + q.Page.KeyColumn() + fromWhere + `
+		  AND ` + after + `
+		ORDER BY ` + q.Page.OrderBy() + fmt.Sprintf(`
+		LIMIT $%d`, argIndex)
+	args = append(args, q.Page.FetchLimit())
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &FlagPage{Flags: []models.FlagWithContext{}}
+	for rows.Next() {
+		var f models.FlagWithContext
+		var key []string
+		f.Flag, err = scanFlag(rows,
+			&f.PackageSlug, &f.PackageDisplayName,
+			&f.ReporterUsername, &f.ResolverUsername, &key,
+		)
+		if err != nil {
+			return nil, err
+		}
+		page.Flags = append(page.Flags, f)
+		page.Keys = append(page.Keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.pool.QueryRow(ctx, "SELECT COUNT(*)"+fromWhere, filterArgs...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count flags: %w", err)
+	}
+	return page, nil
+}
+
+func (s *pgFlags) ListByReporter(ctx context.Context, userID int) ([]models.UserFlag, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT f.id, f.package_id, f.reason, f.details, f.status,
+		       f.resolved_at, f.created_at,
+		       p.slug, p.display_name, u.slug as author_slug
+		FROM flags f
+		JOIN packages p ON f.package_id = p.id
+		JOIN users u ON p.author_id = u.id
+		WHERE f.user_id = $1
+		ORDER BY f.created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := []models.UserFlag{}
+	for rows.Next() {
+		var f models.UserFlag
+		err := rows.Scan(
+			&f.ID, &f.PackageID, &f.Reason, &f.Details, &f.Status,
+			&f.ResolvedAt, &f.CreatedAt,
+			&f.PackageSlug, &f.PackageDisplayName, &f.AuthorSlug,
+		)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, f)
+	}
+	return flags, rows.Err()
+}
+
+func (s *pgFlags) Stats(ctx context.Context, packageID int) (*models.FlagStats, []models.FlagReasonCount, error) {
+	var stats models.FlagStats
+	err := s.pool.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE status = 'pending') as pending_count,
+			COUNT(*) FILTER (WHERE status = 'reviewed') as reviewed_count,
+			COUNT(*) FILTER (WHERE status = 'resolved') as resolved_count,
+			COUNT(*) FILTER (WHERE status = 'dismissed') as dismissed_count,
+			COUNT(*) as total_count,
+			COUNT(DISTINCT reason) as unique_reasons,
+			MAX(created_at) as last_flag_date
+		FROM flags
+		WHERE package_id = $1`,
+		packageID,
+	).Scan(
+		&stats.PendingCount, &stats.ReviewedCount, &stats.ResolvedCount, &stats.DismissedCount,
+		&stats.TotalCount, &stats.UniqueReasons, &stats.LastFlagDate,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT reason, COUNT(*) as count
+		FROM flags
+		WHERE package_id = $1
+		GROUP BY reason
+		ORDER BY count DESC`,
+		packageID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	reasons := []models.FlagReasonCount{}
+	for rows.Next() {
+		var r models.FlagReasonCount
+		if err := rows.Scan(&r.Reason, &r.Count); err != nil {
+			return nil, nil, err
+		}
+		reasons = append(reasons, r)
+	}
+	return &stats, reasons, rows.Err()
+}
+
+// flagResolution is the audited part of a flag
+type flagResolution struct {
+	Status     string `json:"status"`
+	ResolvedBy *int   `json:"resolved_by"`
+}
+
+func (s *pgFlags) Resolve(ctx context.Context, flagID int, status string, resolverID int, thresholds map[string]float64) (*models.Flag, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Keep the previous state for the audit log
+	var before flagResolution
+	err = tx.QueryRow(ctx,
+		"SELECT status, resolved_by FROM flags WHERE id = $1 FOR UPDATE",
+		flagID,
+	).Scan(&before.Status, &before.ResolvedBy)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	flag, err := scanFlag(tx.QueryRow(ctx, `
+		UPDATE flags f
+		SET status = $1, resolved_by = $2, resolved_at = CURRENT_TIMESTAMP
+		WHERE f.id = $3
+		RETURNING `+flagColumns,
+		status, resolverID, flagID,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	err = audit.Record(ctx, tx, audit.Event{
+		Action:     audit.ActionFlagResolve,
+		TargetType: audit.TargetFlag,
+		TargetID:   strconv.Itoa(flagID),
+		Before:     before,
+		After:      flagResolution{Status: status, ResolvedBy: &resolverID},
+	})
+
+	// Reviewing or dismissing a flag may clear the package. Resolving it upholds the flag,
+	// so a quarantined package stays hidden.
+	if err == nil && status != "resolved" {
+		err = releaseQuarantine(ctx, tx, flag.PackageID, flag.Reason, thresholds[flag.Reason])
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &flag, nil
+}
+
+// reporterWeight is how much a pending flag f by reporter u counts towards a threshold.
+// Accounts younger than 30 days count proportionally less (but at least 0.1), and the
+// reporter's track record scales that between 0 and 2: a reporter without resolved flags
+// counts 1, one whose flags were mostly upheld approaches 2 and one whose flags were mostly
+// dismissed approaches 0.
+const reporterWeight = `
+	GREATEST(0.1, LEAST(1.0, EXTRACT(EPOCH FROM NOW() - u.created_at) / 2592000.0))
+	* 2.0 * (acc.upheld + 1) / (acc.upheld + acc.dismissed + 2)`
+
+// reporterAccuracy joins the reporter's past flag outcomes as acc
+const reporterAccuracy = `
+	LEFT JOIN LATERAL (
+		SELECT COUNT(*) FILTER (WHERE status = 'resolved') AS upheld,
+		       COUNT(*) FILTER (WHERE status = 'dismissed') AS dismissed
+		FROM flags
+		WHERE user_id = f.user_id
+	) acc ON TRUE`
+
+// pendingFlagScore is the weight of the pending flags for reason $2 on package $1
+const pendingFlagScore = `
+	SELECT COALESCE(SUM(` + reporterWeight + `), 0)
+	FROM flags f
+	JOIN users u ON f.user_id = u.id
+	` + reporterAccuracy + `
+	WHERE f.package_id = $1 AND f.reason = $2 AND f.status = 'pending'`
+
+func (s *pgFlags) Quarantine(ctx context.Context, packageID int, reason string, threshold float64) (*QuarantineCheck, error) {
+	var check QuarantineCheck
+	err := s.pool.QueryRow(ctx, `
+		SELECT (`+pendingFlagScore+`), quarantined_at IS NOT NULL
+		FROM packages
+		WHERE id = $1`,
+		packageID, reason,
+	).Scan(&check.Score, &check.Quarantined)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute flag score for package %d: %w", packageID, err)
+	}
+	if check.Quarantined || check.Score < threshold {
+		return &check, nil
+	}
+
+	var before, after json.RawMessage
+	err = s.pool.QueryRow(ctx, `
+		UPDATE packages p SET quarantined_at = NOW(), quarantine_reason = $2
+		FROM packages old
+		WHERE p.id = $1 AND old.id = p.id AND p.quarantined_at IS NULL
+		RETURNING `+quarantineAuditState("old")+`, `+quarantineAuditState("p"),
+		packageID, reason,
+	).Scan(&before, &after)
+	if err == pgx.ErrNoRows {
+		check.Quarantined = true // Quarantined concurrently
+		return &check, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to quarantine package %d: %w", packageID, err)
+	}
+	check.Quarantined = true
+	check.Newly = true
+
+	// The actor is the reporter whose flag crossed the threshold. The quarantine stands
+	// even if the audit event can't be written.
+	err = audit.Record(ctx, s.pool, audit.Event{
+		Action:     audit.ActionPackageQuarantine,
+		TargetType: audit.TargetPackage,
+		TargetID:   strconv.Itoa(packageID),
+		Before:     before,
+		After:      after,
+	})
+	if err != nil {
+		logger.MainLogger.Printf("%v", err)
+	}
+	return &check, nil
+}
+
+// releaseQuarantine lifts the quarantine of a package as part of the review of a flag for
+// reason. Only a quarantine for that reason is lifted, and only once the flags for it that
+// are still pending weigh less than threshold; a threshold of 0 no longer quarantines.
+func releaseQuarantine(ctx context.Context, tx pgx.Tx, packageID int, reason string, threshold float64) error {
+	var quarantineReason *string
+	err := tx.QueryRow(ctx, "SELECT quarantine_reason FROM packages WHERE id = $1 FOR UPDATE", packageID).Scan(&quarantineReason)
+	if err != nil {
+		return fmt.Errorf("failed to check quarantine of package %d: %w", packageID, err)
+	}
+	if quarantineReason == nil || *quarantineReason != reason {
+		return nil
+	}
+
+	if threshold > 0 {
+		var score float64
+		if err := tx.QueryRow(ctx, pendingFlagScore, packageID, reason).Scan(&score); err != nil {
+			return fmt.Errorf("failed to compute flag score for package %d: %w", packageID, err)
+		}
+		if score >= threshold {
+			return nil
+		}
+	}
+
+	var before, after json.RawMessage
+	err = tx.QueryRow(ctx, `
+		UPDATE packages p SET quarantined_at = NULL, quarantine_reason = NULL
+		FROM packages old
+		WHERE p.id = $1 AND old.id = p.id
+		RETURNING `+quarantineAuditState("old")+`, `+quarantineAuditState("p"),
+		packageID,
+	).Scan(&before, &after)
+	if err != nil {
+		return fmt.Errorf("failed to release package %d from quarantine: %w", packageID, err)
+	}
+
+	return audit.Record(ctx, tx, audit.Event{
+		Action:     audit.ActionPackageRelease,
+		TargetType: audit.TargetPackage,
+		TargetID:   strconv.Itoa(packageID),
+		Before:     before,
+		After:      after,
+	})
+}
+
+func quarantineAuditState(alias string) string {
+	return fmt.Sprintf(`jsonb_build_object('quarantined_at', %[1]s.quarantined_at,
+		'quarantine_reason', %[1]s.quarantine_reason)`, alias)
+}
+
+func (s *pgFlags) ListQuarantined(ctx context.Context) ([]models.QuarantinedPackage, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT p.id, p.slug, p.display_name, au.slug, p.quarantine_reason, p.quarantined_at,
+		       COUNT(f.id), COALESCE(SUM(`+reporterWeight+`), 0)
+		FROM packages p
+		JOIN users au ON p.author_id = au.id
+		LEFT JOIN flags f ON f.package_id = p.id AND f.reason = p.quarantine_reason AND f.status = 'pending'
+		LEFT JOIN users u ON f.user_id = u.id
+		`+reporterAccuracy+`
+		WHERE p.quarantined_at IS NOT NULL
+		GROUP BY p.id, au.slug
+		ORDER BY p.quarantined_at ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	packages := []models.QuarantinedPackage{}
+	for rows.Next() {
+		var q models.QuarantinedPackage
+		err := rows.Scan(&q.PackageID, &q.PackageSlug, &q.PackageDisplayName, &q.AuthorSlug,
+			&q.Reason, &q.QuarantinedAt, &q.PendingFlags, &q.Score)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, q)
+	}
+	return packages, rows.Err()
+}
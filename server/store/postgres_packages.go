@@ -0,0 +1,515 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"opm/audit"
+	"opm/helpers"
+	"opm/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type pgPackages struct {
+	pool *pgxpool.Pool
+}
+
+func (s *pgPackages) List(ctx context.Context, q PackageQuery) (*PackagePage, error) {
+	// The total and the facets share the page's FROM and WHERE
+	fromWhere := packageFrom + `
+		WHERE ` + visibleAuthorCondition + ` AND ` + notQuarantinedCondition
+
+	args := []interface{}{}
+	argIndex := 1
+
+	if q.Filter.Type != nil {
+		fromWhere += fmt.Sprintf(" AND p.type = $%d", argIndex)
+		args = append(args, *q.Filter.Type)
+		argIndex++
+	}
+
+	if q.Filter.Status != nil {
+		fromWhere += fmt.Sprintf(" AND p.status = $%d", argIndex)
+		args = append(args, *q.Filter.Status)
+		argIndex++
+	}
+
+	if q.Filter.AuthorID != nil {
+		fromWhere += fmt.Sprintf(" AND p.author_id = $%d", argIndex)
+		args = append(args, *q.Filter.AuthorID)
+		argIndex++
+	}
+
+	// Packages must have all the tags, matched like AddTag matches them so aliases and
+	// spelling variants work
+	filteredTagIDs := "SELECT NULL::INTEGER WHERE FALSE"
+	if keys := tagMatchKeys(q.Filter.Tags); len(keys) > 0 {
+		placeholders := make([]string, len(keys))
+		for i, key := range keys {
+			placeholders[i] = fmt.Sprintf("$%d", argIndex)
+			args = append(args, key)
+			argIndex++
+		}
+		filteredTagIDs = helpers.CanonicalTagIDsSQL(strings.Join(placeholders, ","))
+		fromWhere += fmt.Sprintf(`
+		AND p.id IN (
+			SELECT pt.package_id
+			FROM package_tags pt
+			WHERE pt.tag_id IN (%s)
+			GROUP BY pt.package_id
+			HAVING COUNT(DISTINCT pt.tag_id) = %d
+		)`, filteredTagIDs, len(keys))
+	}
+	filterArgs := args
+
+	after, afterArgs := q.Page.KeysetCondition(argIndex)
+	args = append(args, afterArgs...)
+	argIndex += len(afterArgs)
+	query := `
+		SELECT ` + packageColumns + `, ` + q.Page.KeyColumn() + fromWhere + `
+		  AND ` + after + `
+		ORDER BY ` + q.Page.OrderBy() + fmt.Sprintf(`
+		LIMIT $%d`, argIndex)
+	args = append(args, q.Page.FetchLimit())
+
+	// The total and the facets are sent in the same round trip as the page
+	batch := &pgx.Batch{}
+	batch.Queue(query, args...)
+	batch.Queue("SELECT COUNT(*)"+fromWhere, filterArgs...)
+	if len(q.Facets) > 0 {
+		batch.Queue(packageFacetsQuery(fromWhere, q.Facets, filteredTagIDs), filterArgs...)
+	}
+	results := s.pool.SendBatch(ctx, batch)
+	defer results.Close()
+
+	page := &PackagePage{Packages: []models.Package{}}
+	rows, err := results.Query()
+	if err != nil {
+		return nil, err
+	}
+	if err := scanPackages(rows, page); err != nil {
+		return nil, err
+	}
+	if err := results.QueryRow().Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count packages: %w", err)
+	}
+	if len(q.Facets) > 0 {
+		page.Facets = &models.PackageFacets{}
+		if err := results.QueryRow().Scan(page.Facets); err != nil {
+			return nil, fmt.Errorf("failed to compute package facets: %w", err)
+		}
+	}
+	return page, results.Close()
+}
+
+// tagMatchKeys returns the distinct match keys of normalized tag names
+func tagMatchKeys(tags []string) []string {
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		key := helpers.TagMatchKey(tag)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// maxTagFacets is how many co-occurring tags the tag facet returns
+const maxTagFacets = 20
+
+// packageFacets maps each facet to the aggregate computing it over the matched CTE. Counts
+// are keyed by value; packages without a license count as "none".
+var packageFacets = map[string]string{
+	"type": `(SELECT COALESCE(jsonb_object_agg(type, n), '{}') FROM (
+		SELECT type::text AS type, COUNT(*) AS n FROM matched GROUP BY 1) s)`,
+	"status": `(SELECT COALESCE(jsonb_object_agg(status, n), '{}') FROM (
+		SELECT status::text AS status, COUNT(*) AS n FROM matched GROUP BY 1) s)`,
+	"license": `(SELECT COALESCE(jsonb_object_agg(license, n), '{}') FROM (
+		SELECT COALESCE(license, 'none') AS license, COUNT(*) AS n FROM matched GROUP BY 1) s)`,
+	"tag": fmt.Sprintf(`(SELECT COALESCE(jsonb_agg(jsonb_build_object('name', t.name, 'count', s.n) ORDER BY s.n DESC, t.name), '[]') FROM (
+		SELECT pt.tag_id, COUNT(*) AS n FROM matched m JOIN package_tags pt ON pt.package_id = m.id
+		WHERE pt.tag_id <> ALL(%%s)
+		GROUP BY pt.tag_id ORDER BY n DESC, pt.tag_id LIMIT %d) s
+		JOIN tags t ON t.id = s.tag_id)`, maxTagFacets),
+}
+
+// packageFacetsQuery aggregates the requested facets over the packages matching fromWhere
+// into one JSON object. filteredTagIDs selects the tags already filtered on, which the tag
+// facet leaves out.
+func packageFacetsQuery(fromWhere string, facets []string, filteredTagIDs string) string {
+	fields := make([]string, 0, len(facets))
+	for _, facet := range facets {
+		aggregate := packageFacets[facet]
+		if facet == "tag" {
+			aggregate = fmt.Sprintf(aggregate, "ARRAY("+filteredTagIDs+")")
+		}
+		fields = append(fields, fmt.Sprintf("'%s', %s", facet, aggregate))
+	}
+
+	return `
+		WITH matched AS (
+			SELECT DISTINCT p.id, p.type, p.status, p.license
+			` + fromWhere + `
+		)
+		SELECT jsonb_build_object(` + strings.Join(fields, ",\n") + `)`
+}
+
+// searchMatchCondition matches packages whose search vector contains every word of the query,
+// each as a prefix ($2), or whose slug or display name is trigram-similar to the query ($1).
+// The % operator uses pg_trgm.similarity_threshold and the trigram indexes.
+const searchMatchCondition = `(p.search_vector @@ to_tsquery('english', $2)
+		       OR p.display_name % $1 OR p.slug % $1)`
+
+// prefixTsQuery turns a search query into a tsquery matching every word as a prefix, "ray
+// lib" becoming "ray:* & lib:*". Punctuation is dropped so user input can't break the
+// tsquery syntax.
+func prefixTsQuery(query string) string {
+	words := searchWords(query)
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// searchWords splits a search query into its words
+func searchWords(query string) []string {
+	return strings.FieldsFunc(query, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+func (s *pgPackages) Search(ctx context.Context, q SearchQuery) (*PackagePage, error) {
+	fromWhere := packageFrom + `
+		WHERE ` + searchMatchCondition + `
+		  AND ` + visibleAuthorCondition + `
+		  AND ` + notQuarantinedCondition
+
+	filterArgs := []interface{}{q.Text, prefixTsQuery(q.Text)}
+	after, afterArgs := q.Page.KeysetCondition(4)
+	args := append(append(filterArgs, q.Page.FetchLimit()), afterArgs...)
+
+	query := `
+		SELECT ` + packageColumns + `, ` + q.Page.KeyColumn() + fromWhere + `
+		  AND ` + after + `
+		ORDER BY ` + q.Page.OrderBy() + `
+		LIMIT $3`
+
+	page := &PackagePage{Packages: []models.Package{}}
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := scanPackages(rows, page); err != nil {
+		return nil, err
+	}
+
+	err = s.pool.QueryRow(ctx, "SELECT COUNT(*)"+fromWhere, filterArgs...).Scan(&page.Total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count search results: %w", err)
+	}
+	return page, nil
+}
+
+func (s *pgPackages) ListByAuthor(ctx context.Context, authorID int, page *helpers.Page) (*PackagePage, error) {
+	after, afterArgs := page.KeysetCondition(3)
+	args := append([]interface{}{authorID, page.FetchLimit()}, afterArgs...)
+
+	query := `
+		SELECT ` + packageColumns + `, ` + page.KeyColumn() + packageFrom + `
+		WHERE p.author_id = $1 AND ` + after + `
+		ORDER BY ` + page.OrderBy() + `
+		LIMIT $2`
+
+	result := &PackagePage{Packages: []models.Package{}}
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := scanPackages(rows, result); err != nil {
+		return nil, err
+	}
+
+	err = s.pool.QueryRow(ctx, "SELECT COUNT(*) FROM packages WHERE author_id = $1", authorID).Scan(&result.Total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count packages of user %d: %w", authorID, err)
+	}
+	return result, nil
+}
+
+// likeEscaper escapes the LIKE wildcards in user input
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Suggest ranks names starting with the query first, then trigram-similar ones. Both
+// conditions are served by the trigram indexes.
+func (s *pgPackages) Suggest(ctx context.Context, query string, limit int) ([]models.PackageSuggestion, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT p.slug, p.display_name, p.type, u.slug
+		FROM packages p
+		JOIN users u ON p.author_id = u.id
+		WHERE (p.display_name ILIKE $2 OR p.slug ILIKE $2 OR p.display_name % $1 OR p.slug % $1)
+		  AND `+visibleAuthorCondition+`
+		  AND `+notQuarantinedCondition+`
+		ORDER BY (p.display_name ILIKE $2 OR p.slug ILIKE $2) DESC,
+		         GREATEST(similarity(p.slug, $1), similarity(p.display_name, $1)) DESC,
+		         p.view_count DESC, p.id
+		LIMIT $3`,
+		query, likeEscaper.Replace(query)+"%", limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []models.PackageSuggestion{}
+	for rows.Next() {
+		var s models.PackageSuggestion
+		if err := rows.Scan(&s.Slug, &s.DisplayName, &s.Type, &s.AuthorSlug); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
+
+const (
+	didYouMeanThreshold = 0.15
+	maxDidYouMean       = 3
+)
+
+// DidYouMean's similarity cutoff is below the one of the % operator, so it scans instead of
+// using the trigram indexes; it only runs for empty results.
+func (s *pgPackages) DidYouMean(ctx context.Context, query string) ([]string, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT name FROM (
+			SELECT p.display_name AS name, GREATEST(similarity(p.slug, $1), similarity(p.display_name, $1)) AS score
+			FROM packages p
+			JOIN users u ON p.author_id = u.id
+			WHERE `+visibleAuthorCondition+` AND `+notQuarantinedCondition+`
+			UNION ALL
+			SELECT t.name, similarity(t.name, $1)
+			FROM tags t
+			WHERE t.canonical_id IS NULL
+		) candidates
+		WHERE score >= $2
+		GROUP BY name
+		ORDER BY MAX(score) DESC, name
+		LIMIT $3`,
+		query, didYouMeanThreshold, maxDidYouMean,
+	)
+	if err != nil {
+		return []string{}, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (s *pgPackages) GetBySlugs(ctx context.Context, userSlug, pkgSlug string) (*models.Package, error) {
+	p, err := scanPackage(s.pool.QueryRow(ctx, `
+		SELECT `+packageColumns+packageFrom+`
+		WHERE u.slug = $1 AND p.slug = $2`,
+		userSlug, pkgSlug,
+	))
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *pgPackages) Exists(ctx context.Context, packageID int) (bool, error) {
+	var exists bool
+	err := s.pool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM packages WHERE id = $1)", packageID).Scan(&exists)
+	return exists, err
+}
+
+// LatestVersion prefers stable releases over prereleases
+func (s *pgPackages) LatestVersion(ctx context.Context, packageID int) (*models.PackageVersion, error) {
+	var v models.PackageVersion
+	err := s.pool.QueryRow(ctx, `
+		SELECT id, package_id, version, tag_name, commit_sha, release_notes,
+		       yanked, yanked_at, published_by, published_at
+		FROM package_versions
+		WHERE package_id = $1 AND NOT yanked
+		ORDER BY (prerelease = '') DESC, major DESC, minor DESC, patch DESC, prerelease_key DESC, published_at DESC
+		LIMIT 1`,
+		packageID,
+	).Scan(
+		&v.ID, &v.PackageID, &v.Version, &v.TagName, &v.CommitSHA, &v.ReleaseNotes,
+		&v.Yanked, &v.YankedAt, &v.PublishedBy, &v.PublishedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *pgPackages) TrackView(ctx context.Context, packageID int, userID *int) error {
+	_, err := s.pool.Exec(ctx, "SELECT track_package_view($1, $2)", packageID, userID)
+	return err
+}
+
+// packageAuditState selects the audited fields of a package as JSON
+const packageAuditState = `jsonb_build_object(
+	'slug', slug, 'display_name', display_name, 'description', description, 'type', type,
+	'status', status, 'repository_url', repository_url, 'license', license, 'author_id', author_id)`
+
+func (s *pgPackages) AuthorID(ctx context.Context, packageID int) (int, error) {
+	var authorID int
+	err := s.pool.QueryRow(ctx, "SELECT author_id FROM packages WHERE id = $1", packageID).Scan(&authorID)
+	if err == pgx.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return authorID, err
+}
+
+func (s *pgPackages) Create(ctx context.Context, authorID int, input models.CreatePackageInput) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM packages WHERE slug = $1)", input.Slug).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check slug %s: %w", input.Slug, err)
+	}
+	if exists {
+		return 0, ErrSlugTaken
+	}
+
+	var packageID int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO packages (slug, display_name, description, type, status, repository_url, license, author_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		input.Slug, input.DisplayName, input.Description, input.Type, input.Status,
+		input.RepositoryURL, input.License, authorID,
+	).Scan(&packageID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert package %s: %w", input.Slug, err)
+	}
+
+	// An alias attaches its canonical tag
+	if len(input.TagIDs) > 0 {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO package_tags (package_id, tag_id)
+			SELECT DISTINCT $1::INTEGER, COALESCE(canonical_id, id) FROM tags WHERE id = ANY($2)
+			ON CONFLICT DO NOTHING`,
+			packageID, input.TagIDs,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to add tags to package %d: %w", packageID, err)
+		}
+	}
+
+	return packageID, tx.Commit(ctx)
+}
+
+func (s *pgPackages) Update(ctx context.Context, packageID int, input models.UpdatePackageInput) error {
+	updateFields := []string{"updated_at = CURRENT_TIMESTAMP"}
+	args := []interface{}{}
+	argIndex := 1
+	set := func(column string, value interface{}) {
+		updateFields = append(updateFields, fmt.Sprintf("%s = $%d", column, argIndex))
+		args = append(args, value)
+		argIndex++
+	}
+
+	if input.DisplayName != nil {
+		set("display_name", *input.DisplayName)
+	}
+	if input.Description != nil {
+		set("description", *input.Description)
+	}
+	if input.Type != nil {
+		set("type", *input.Type)
+	}
+	if input.Status != nil {
+		set("status", *input.Status)
+	}
+	if input.RepositoryURL != nil {
+		set("repository_url", *input.RepositoryURL)
+	}
+	if input.License != nil {
+		if *input.License == "" {
+			updateFields = append(updateFields, "license = NULL")
+		} else {
+			set("license", *input.License)
+		}
+	}
+
+	args = append(args, packageID)
+	query := fmt.Sprintf(
+		"UPDATE packages SET %s WHERE id = $%d RETURNING "+packageAuditState,
+		strings.Join(updateFields, ", "),
+		argIndex,
+	)
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var before, after json.RawMessage
+	err = tx.QueryRow(ctx, "SELECT "+packageAuditState+" FROM packages WHERE id = $1 FOR UPDATE", packageID).Scan(&before)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err == nil {
+		err = tx.QueryRow(ctx, query, args...).Scan(&after)
+	}
+	if err == nil {
+		err = audit.Record(ctx, tx, audit.Event{
+			Action:     audit.ActionPackageUpdate,
+			TargetType: audit.TargetPackage,
+			TargetID:   strconv.Itoa(packageID),
+			Before:     before,
+			After:      after,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *pgPackages) Delete(ctx context.Context, packageID int) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Versions, tags, flags and the rest cascade
+	var before json.RawMessage
+	err = tx.QueryRow(ctx, "DELETE FROM packages WHERE id = $1 RETURNING "+packageAuditState, packageID).Scan(&before)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err == nil {
+		err = audit.Record(ctx, tx, audit.Event{
+			Action:     audit.ActionPackageDelete,
+			TargetType: audit.TargetPackage,
+			TargetID:   strconv.Itoa(packageID),
+			Before:     before,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"opm/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type pgTags struct {
+	pool *pgxpool.Pool
+}
+
+// List lists aliases under their canonical tag
+func (s *pgTags) List(ctx context.Context, q TagQuery) (*TagPage, error) {
+	fromWhere := `
+		FROM tags t
+		WHERE t.canonical_id IS NULL`
+
+	args := []interface{}{}
+	argIndex := 1
+
+	if q.Search != "" {
+		// Searching for an alias finds its canonical tag
+		fromWhere += " AND (t.search_vector @@ plainto_tsquery('english', $" + strconv.Itoa(argIndex) + ")" +
+			" OR EXISTS(SELECT 1 FROM tags a WHERE a.canonical_id = t.id AND a.search_vector @@ plainto_tsquery('english', $" + strconv.Itoa(argIndex) + ")))"
+		args = append(args, q.Search)
+		argIndex++
+	}
+
+	if q.Category != nil {
+		fromWhere += " AND t.category = $" + strconv.Itoa(argIndex)
+		args = append(args, *q.Category)
+		argIndex++
+	}
+	filterArgs := args
+
+	after, afterArgs := q.Page.KeysetCondition(argIndex)
+	args = append(args, afterArgs...)
+	argIndex += len(afterArgs)
+	query := `
+		SELECT t.id, t.name, COALESCE(t.usage_count, 0), t.created_at, t.category,
+		       COALESCE(ARRAY(SELECT a.name FROM tags a WHERE a.canonical_id = t.id ORDER BY a.name), '{}'),
+		       ` + q.Page.KeyColumn() + fromWhere + `
+		  AND ` + after + `
+		ORDER BY ` + q.Page.OrderBy() + `
+		LIMIT $` + strconv.Itoa(argIndex)
+	args = append(args, q.Page.FetchLimit())
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &TagPage{Tags: []models.Tag{}}
+	for rows.Next() {
+		var t models.Tag
+		var key []string
+		err := rows.Scan(&t.ID, &t.Name, &t.UsageCount, &t.CreatedAt, &t.Category, &t.Aliases, &key)
+		if err != nil {
+			return nil, err
+		}
+		page.Tags = append(page.Tags, t)
+		page.Keys = append(page.Keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.pool.QueryRow(ctx, "SELECT COUNT(*)"+fromWhere, filterArgs...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count tags: %w", err)
+	}
+	return page, nil
+}
+
+func (s *pgTags) ForPackage(ctx context.Context, packageID, userID int) ([]models.Tag, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT t.id, t.name, COALESCE(t.usage_count, 0), t.created_at, t.category,
+		       pt.score as net_score,
+		       COALESCE(tv.vote_value, 0) as user_vote
+		FROM tags t
+		JOIN package_tags pt ON t.id = pt.tag_id
+		LEFT JOIN tag_votes tv ON pt.package_id = tv.package_id
+		                       AND pt.tag_id = tv.tag_id
+		                       AND tv.user_id = $2
+		WHERE pt.package_id = $1
+		ORDER BY pt.score DESC, t.name`,
+		packageID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.Tag{}
+	for rows.Next() {
+		var t models.Tag
+		err := rows.Scan(&t.ID, &t.Name, &t.UsageCount, &t.CreatedAt, &t.Category, &t.NetScore, &t.UserVote)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"opm/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type pgUsers struct {
+	pool *pgxpool.Pool
+}
+
+func (s *pgUsers) IsModerator(ctx context.Context, userID int) (bool, error) {
+	var isModerator bool
+	err := s.pool.QueryRow(ctx, "SELECT is_moderator FROM users WHERE id = $1", userID).Scan(&isModerator)
+	return isModerator, err
+}
+
+func (s *pgUsers) SlugTaken(ctx context.Context, slug string, exceptUserID int) (bool, error) {
+	var taken bool
+	err := s.pool.QueryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM users WHERE slug = $1 AND id != $2)",
+		slug, exceptUserID,
+	).Scan(&taken)
+	return taken, err
+}
+
+func (s *pgUsers) UpdateProfile(ctx context.Context, userID int, input models.UpdateUserInput) error {
+	updateFields := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{
+		{"slug", input.Slug},
+		{"display_name", input.DisplayName},
+		{"avatar_url", input.AvatarURL},
+	} {
+		if field.value == nil {
+			continue
+		}
+		updateFields = append(updateFields, fmt.Sprintf("%s = $%d", field.column, argIndex))
+		args = append(args, *field.value)
+		argIndex++
+	}
+	if len(updateFields) == 0 {
+		return nil
+	}
+
+	args = append(args, userID)
+	query := fmt.Sprintf(
+		"UPDATE users SET %s, updated_at = CURRENT_TIMESTAMP WHERE id = $%d",
+		strings.Join(updateFields, ", "),
+		argIndex,
+	)
+	_, err := s.pool.Exec(ctx, query, args...)
+	return err
+}
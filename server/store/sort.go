@@ -0,0 +1,59 @@
+package store
+
+import "opm/helpers"
+
+// The keyset sort orders of the paginated listings, for helpers.ParsePage. Each ends with a
+// unique id so cursors never land between tied rows. The expressions are the ones Postgres
+// sorts on; Memory sorts the same way and produces keys of the same length.
+
+// PackageSortOrders maps the sort parameter accepted by List and Search to sort orders
+var PackageSortOrders = map[string][]helpers.SortColumn{
+	"newest":     {createdAtDesc, idDesc},
+	"updated":    {{Expr: "p.updated_at", Type: "TIMESTAMPTZ", Desc: true}, idDesc},
+	"popular":    {{Expr: "p.view_count", Type: "BIGINT", Desc: true}, createdAtDesc, idDesc},
+	"bookmarked": {{Expr: "p.bookmark_count", Type: "INTEGER", Desc: true}, createdAtDesc, idDesc},
+	"trending":   {{Expr: trendingScore, Type: "DOUBLE PRECISION", Desc: true}, createdAtDesc, idDesc},
+	"dependents": {{Expr: "p.dependents_count", Type: "INTEGER", Desc: true}, createdAtDesc, idDesc},
+}
+
+var (
+	createdAtDesc = helpers.SortColumn{Expr: "p.created_at", Type: "TIMESTAMPTZ", Desc: true}
+	idDesc        = helpers.SortColumn{Expr: "p.id", Type: "INTEGER", Desc: true}
+)
+
+// searchRank is the relevance of a package to a search: its full-text rank plus the trigram
+// similarity of its best matching name. $1 is the query and $2 its prefix tsquery.
+var searchRank = helpers.SortColumn{
+	Expr: "(ts_rank(p.search_vector, to_tsquery('english', $2)) + GREATEST(similarity(p.slug, $1), similarity(p.display_name, $1)))",
+	Type: "REAL",
+	Desc: true,
+}
+
+// SearchSortOrder returns the sort order of a search: by relevance then recency when sort
+// is "", else by a PackageSortOrders order with relevance breaking ties
+func SearchSortOrder(sort string) ([]helpers.SortColumn, bool) {
+	if sort == "" {
+		return []helpers.SortColumn{searchRank, createdAtDesc, idDesc}, true
+	}
+	o, ok := PackageSortOrders[sort]
+	if !ok {
+		return nil, false
+	}
+	return append(append([]helpers.SortColumn{}, o[:len(o)-1]...), searchRank, idDesc), true
+}
+
+// AuthorPackageSortOrder lists an author's newest packages first
+var AuthorPackageSortOrder = []helpers.SortColumn{createdAtDesc, idDesc}
+
+// TagSortOrder lists the most used tags first, then alphabetically
+var TagSortOrder = []helpers.SortColumn{
+	{Expr: "COALESCE(t.usage_count, 0)", Type: "INTEGER", Desc: true},
+	{Expr: "t.name", Type: "VARCHAR"},
+	{Expr: "t.id", Type: "INTEGER"},
+}
+
+// FlagSortOrder lists the newest flags first
+var FlagSortOrder = []helpers.SortColumn{
+	{Expr: "f.created_at", Type: "TIMESTAMPTZ", Desc: true},
+	{Expr: "f.id", Type: "INTEGER", Desc: true},
+}
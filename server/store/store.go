@@ -0,0 +1,174 @@
+// Package store is the data access layer behind the handlers. Handlers depend on the
+// interfaces below; Postgres implements them for the server and Memory for tests.
+package store
+
+import (
+	"context"
+	"errors"
+
+	"opm/helpers"
+	"opm/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrSlugTaken is returned when creating a package whose slug is in use
+	ErrSlugTaken = errors.New("slug is taken")
+)
+
+// Store bundles the stores a handler may need
+type Store struct {
+	Packages  PackageStore
+	Users     UserStore
+	Tags      TagStore
+	Flags     FlagStore
+	Bookmarks BookmarkStore
+}
+
+// PackageStore reads packages for the listing, search and detail endpoints, and creates,
+// updates and deletes them for their authors
+type PackageStore interface {
+	// List returns a page of visible packages matching the query
+	List(ctx context.Context, q PackageQuery) (*PackagePage, error)
+	// Search returns a page of visible packages matching a search query
+	Search(ctx context.Context, q SearchQuery) (*PackagePage, error)
+	// ListByAuthor returns a page of an author's packages, hidden ones included
+	ListByAuthor(ctx context.Context, authorID int, page *helpers.Page) (*PackagePage, error)
+	// Suggest autocompletes a partially typed package name
+	Suggest(ctx context.Context, query string, limit int) ([]models.PackageSuggestion, error)
+	// DidYouMean suggests package and tag names resembling a query that matched nothing
+	DidYouMean(ctx context.Context, query string) ([]string, error)
+	// GetBySlugs returns a package with its author, or ErrNotFound
+	GetBySlugs(ctx context.Context, userSlug, pkgSlug string) (*models.Package, error)
+	// Exists reports whether a package exists
+	Exists(ctx context.Context, packageID int) (bool, error)
+	// LatestVersion returns the highest non-yanked version of a package, or nil
+	LatestVersion(ctx context.Context, packageID int) (*models.PackageVersion, error)
+	// TrackView counts a view of a package, at most once a day per viewer
+	TrackView(ctx context.Context, packageID int, userID *int) error
+	// AuthorID returns the author of a package, or ErrNotFound
+	AuthorID(ctx context.Context, packageID int) (int, error)
+	// Create adds a package with the canonical tags of input.TagIDs and returns its id,
+	// or ErrSlugTaken
+	Create(ctx context.Context, authorID int, input models.CreatePackageInput) (int, error)
+	// Update sets the non-nil fields of input, clearing the license when it is empty, and
+	// audits the change. It returns ErrNotFound for an unknown package.
+	Update(ctx context.Context, packageID int, input models.UpdatePackageInput) error
+	// Delete removes a package with everything that belongs to it and audits it. It
+	// returns ErrNotFound for an unknown package.
+	Delete(ctx context.Context, packageID int) error
+}
+
+// UserStore reads and updates user profiles
+type UserStore interface {
+	IsModerator(ctx context.Context, userID int) (bool, error)
+	// SlugTaken reports whether a user other than exceptUserID (0 for none) has slug
+	SlugTaken(ctx context.Context, slug string, exceptUserID int) (bool, error)
+	// UpdateProfile sets the non-nil fields of an already validated input
+	UpdateProfile(ctx context.Context, userID int, input models.UpdateUserInput) error
+}
+
+// TagStore reads tags
+type TagStore interface {
+	// List returns a page of canonical tags with their aliases
+	List(ctx context.Context, q TagQuery) (*TagPage, error)
+	// ForPackage returns the tags of a package with the votes of userID (0 for none)
+	ForPackage(ctx context.Context, packageID, userID int) ([]models.Tag, error)
+}
+
+// FlagStore files, lists and resolves moderation flags
+type FlagStore interface {
+	Create(ctx context.Context, packageID, userID int, reason string, details *string) (int, error)
+	// Get returns a flag, or ErrNotFound
+	Get(ctx context.Context, flagID int) (*models.Flag, error)
+	Delete(ctx context.Context, flagID int) error
+	// HasPending reports whether a user has a pending flag on a package
+	HasPending(ctx context.Context, packageID, userID int) (bool, error)
+	// ListPending returns the pending flags of a package, newest first
+	ListPending(ctx context.Context, packageID int) ([]models.Flag, error)
+	// List returns a page of flags for moderators
+	List(ctx context.Context, q FlagQuery) (*FlagPage, error)
+	// ListByReporter returns the flags a user filed, newest first
+	ListByReporter(ctx context.Context, userID int) ([]models.UserFlag, error)
+	Stats(ctx context.Context, packageID int) (*models.FlagStats, []models.FlagReasonCount, error)
+	// Resolve sets the status of a flag on behalf of a moderator and audits it. Unless the
+	// flag is upheld ("resolved"), a package quarantined for the flag's reason leaves
+	// quarantine once its pending flags for that reason weigh less than the reason's entry
+	// in thresholds. It returns ErrNotFound for an unknown flag.
+	Resolve(ctx context.Context, flagID int, status string, resolverID int, thresholds map[string]float64) (*models.Flag, error)
+	// Quarantine quarantines a package once its weighted pending flags for reason reach
+	// threshold, and reports whether the package is quarantined afterwards
+	Quarantine(ctx context.Context, packageID int, reason string, threshold float64) (*QuarantineCheck, error)
+	// ListQuarantined returns the quarantined packages, oldest first
+	ListQuarantined(ctx context.Context) ([]models.QuarantinedPackage, error)
+}
+
+// BookmarkStore keeps the packages users bookmarked
+type BookmarkStore interface {
+	Add(ctx context.Context, userID, packageID int) error
+	Remove(ctx context.Context, userID, packageID int) error
+	// Bookmarked returns which of packageIDs a user bookmarked
+	Bookmarked(ctx context.Context, userID int, packageIDs []int) (map[int]bool, error)
+}
+
+// PackageQuery selects the packages listed by List
+type PackageQuery struct {
+	Filter models.PackageFilter
+	Sort   string   // Key of PackageSortOrders
+	Facets []string // Facets to count over all matching packages, see Facets
+	Page   *helpers.Page
+}
+
+// SearchQuery selects the packages found by Search
+type SearchQuery struct {
+	Text string
+	Sort string // Key of PackageSortOrders, or "" to sort by relevance
+	Page *helpers.Page
+}
+
+// PackagePage is a page of packages. Keys holds the sort key of every fetched package, one
+// more than the page holds when there is a next page; see helpers.Page.NextPage.
+type PackagePage struct {
+	Packages []models.Package
+	Keys     [][]string
+	Total    int
+	Facets   *models.PackageFacets // Set when facets were requested
+}
+
+// TagQuery selects the tags listed by TagStore.List
+type TagQuery struct {
+	Search   string // Matches tag names and aliases
+	Category *models.TagCategory
+	Page     *helpers.Page
+}
+
+// TagPage is a page of tags, with keys as in PackagePage
+type TagPage struct {
+	Tags  []models.Tag
+	Keys  [][]string
+	Total int
+}
+
+// FlagQuery selects the flags listed by FlagStore.List
+type FlagQuery struct {
+	Status string // "all" for every status
+	Page   *helpers.Page
+}
+
+// FlagPage is a page of flags, with keys as in PackagePage
+type FlagPage struct {
+	Flags []models.FlagWithContext
+	Keys  [][]string
+	Total int
+}
+
+// QuarantineCheck is the outcome of FlagStore.Quarantine
+type QuarantineCheck struct {
+	Quarantined bool    // The package is quarantined
+	Newly       bool    // This check quarantined it
+	Score       float64 // Weighted pending flags for the reason
+}
+
+// Facets are the facets List can count
+var Facets = []string{"type", "status", "license", "tag"}